@@ -2,17 +2,23 @@ package main
 
 import (
 	"bytes"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/base32"
 	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"net/http"
-	"net/smtp"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -22,18 +28,30 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/pquerna/otp"
 	"github.com/pquerna/otp/totp"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
 var file string = "db/auth.db"
-var secretKey []byte
-var passwordDesktop string = ""
-var passwordIOS string = ""
-var email string = ""
-var emailPassword string = ""
+var signingKeys []SigningKey
+var signingKeysMutex sync.Mutex
 var url string = ""
+var maxUploadSize int64 = 10 * 1024 * 1024
+var maxAttachmentSize int64 = 10 * 1024 * 1024
+var accessTokenTTL time.Duration = 15 * time.Minute
+var refreshTokenTTL time.Duration = 30 * 24 * time.Hour
+var keyRotation time.Duration = 7 * 24 * time.Hour
+var maxFailedLogins int = 5
+var lockoutDuration time.Duration = 15 * time.Minute
+var ipLimiter = newRateLimiter(10, time.Minute)
+var accountLimiter = newRateLimiter(5, 5*time.Minute)
+var challengeLimiter = newRateLimiter(30, time.Minute)
+var challengeTTL time.Duration = 2 * time.Minute
+var resetTTL time.Duration = 30 * time.Minute
+var verifyTTL time.Duration = 24 * time.Hour
+var unverifiedTTL time.Duration = 7 * 24 * time.Hour
 
 type User struct {
 	gorm.Model
@@ -43,102 +61,1055 @@ type User struct {
 	Verified bool   `json:"verified" gorm:"default:false"`
 	Code     string `json:"code"`
 	Totp     string `json:"totp"`
+	Salt     string `json:"salt"`
+	Verifier string `json:"verifier"`
+
+	TotpPending   string `json:"-"`
+	TotpLastStep  int64  `json:"-" gorm:"default:0"`
+	WebauthnID    string `json:"-"`
+	CodeExpiresAt int64  `json:"-" gorm:"default:0"`
+	FailedLogins  int    `json:"-" gorm:"default:0"`
+	LockedUntil   int64  `json:"-" gorm:"default:0"`
+}
+
+type SigningKey struct {
+	gorm.Model
+	Kid    string `gorm:"unique;not null"`
+	Secret string `gorm:"not null"`
+}
+
+type RefreshToken struct {
+	gorm.Model
+	Username  string `gorm:"index;not null"`
+	Hash      string `gorm:"unique;not null"`
+	Family    string `gorm:"index;not null"`
+	ExpiresAt int64  `gorm:"not null"`
+	Used      bool   `gorm:"default:false"`
+}
+
+type Session struct {
+	gorm.Model
+	Sid       string `gorm:"unique;not null"`
+	Username  string `gorm:"index;not null"`
+	Device    string
+	IP        string
+	UserAgent string
+	LastSeen  time.Time
+}
+
+type ClientKey struct {
+	gorm.Model
+	Name    string `gorm:"unique;not null"`
+	Secret  string `gorm:"not null"`
+	Revoked bool   `gorm:"default:false"`
+}
+
+type Challenge struct {
+	gorm.Model
+	Value     string `gorm:"unique;not null"`
+	ExpiresAt int64  `gorm:"not null"`
+}
+
+type PasswordReset struct {
+	gorm.Model
+	Username  string `gorm:"index;not null"`
+	Hash      string `gorm:"unique;not null"`
+	ExpiresAt int64  `gorm:"not null"`
+}
+
+type RecoveryCode struct {
+	gorm.Model
+	Username string `gorm:"index;not null"`
+	Salt     string
+	Hash     string `gorm:"unique;not null"`
+}
+
+type KnownDevice struct {
+	gorm.Model
+	Username string `gorm:"index;not null"`
+	Hash     string `gorm:"not null"`
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+type rateLimiter struct {
+	mutex   sync.Mutex
+	buckets map[string]*bucket
+	burst   float64
+	rate    float64
 }
 
 type CustomClaims struct {
 	Username string `json:"username"`
+	Session  string `json:"sid"`
 	jwt.StandardClaims
 }
 
-func initDB() error {
+func newRateLimiter(burst int, period time.Duration) *rateLimiter {
+	return &rateLimiter{
+		buckets: make(map[string]*bucket),
+		burst:   float64(burst),
+		rate:    float64(burst) / period.Seconds(),
+	}
+}
+
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	if len(l.buckets) > 10000 {
+		for k, b := range l.buckets {
+			if b.tokens+now.Sub(b.updated).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, k)
+			}
+		}
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.updated).Seconds()*l.rate)
+	b.updated = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+func tooManyRequests(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, gin.H{"message": "Too many requests"})
+	c.Abort()
+}
+
+func rateLimit(limiter *rateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ok, wait := limiter.allow(c.ClientIP()); !ok {
+			tooManyRequests(c, wait)
+			return
+		}
+		c.Next()
+	}
+}
+
+func initDB() error {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	err = db.AutoMigrate(&User{}, &SigningKey{}, &RefreshToken{}, &Session{}, &ClientKey{}, &Challenge{}, &PasswordReset{}, &KnownDevice{}, &RecoveryCode{}, &WebauthnCredential{}, &WebauthnSession{}, &SrpSession{}, &ServerSecret{}, &LoginFailure{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func addUser(user User) error {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	result := db.Create(&user)
+	if result.Error != nil {
+		log.Println(result.Error)
+		return result.Error
+	}
+
+	return nil
+}
+
+func getUser(username string) (User, error) {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return User{}, err
+	}
+
+	var user User
+	result := db.Where("username = ?", username).First(&user)
+	if result.Error != nil {
+		log.Println(result.Error)
+		return User{}, result.Error
+	}
+
+	return user, nil
+}
+
+func getUserByCode(code string) (User, error) {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return User{}, err
+	}
+
+	var user User
+	result := db.Where("code = ?", code).First(&user)
+	if result.Error != nil {
+		log.Println(result.Error)
+		return User{}, result.Error
+	}
+
+	return user, nil
+}
+
+func setVerified(username string) error {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	result := db.Model(&User{}).Where("username = ?", username).Updates(map[string]interface{}{"verified": true, "code": "", "code_expires_at": 0})
+	if result.Error != nil {
+		log.Println(result.Error)
+		return result.Error
+	}
+
+	return nil
+}
+
+func countFailedLogin(model interface{}, username string) (int, error) {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+
+	var failed int
+	err2 := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(model).Where("username = ?", username).Update("failed_logins", gorm.Expr("failed_logins + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if err := tx.Model(model).Where("username = ?", username).Select("failed_logins").Scan(&failed).Error; err != nil {
+			return err
+		}
+		delay := time.Duration(math.Pow(2, float64(failed-1))) * time.Second
+		if failed >= maxFailedLogins {
+			delay = lockoutDuration
+		}
+		return tx.Model(model).Where("username = ?", username).Update("locked_until", time.Now().Add(delay).Unix()).Error
+	})
+	if err2 != nil {
+		log.Println(err2)
+		return 0, err2
+	}
+
+	return failed, nil
+}
+
+func failedLogin(user User) error {
+	failed, err := countFailedLogin(&User{}, user.Username)
+	if err != nil {
+		return err
+	}
+	if failed == maxFailedLogins {
+		log.Println("Locked account", user.Username)
+		sendLockoutEmail(user.Username)
+	}
+
+	return nil
+}
+
+func resetFailedLogins(user User) error {
+	if user.FailedLogins == 0 && user.LockedUntil == 0 {
+		return nil
+	}
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	result := db.Model(&User{}).Where("username = ?", user.Username).Updates(map[string]interface{}{"failed_logins": 0, "locked_until": 0})
+	if result.Error != nil {
+		log.Println(result.Error)
+		return result.Error
+	}
+
+	return nil
+}
+
+func addPasswordReset(username string) (string, error) {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return "", err
+	}
+
+	secret, err2 := generateRandomKey(16)
+	if err2 != nil {
+		log.Println(err2)
+		return "", err2
+	}
+	token := hex.EncodeToString(secret)
+	result := db.Unscoped().Where("username = ? OR expires_at < ?", username, time.Now().Unix()).Delete(&PasswordReset{})
+	if result.Error != nil {
+		log.Println(result.Error)
+		return "", result.Error
+	}
+	result = db.Create(&PasswordReset{Username: username, Hash: hashToken(token), ExpiresAt: time.Now().Add(resetTTL).Unix()})
+	if result.Error != nil {
+		log.Println(result.Error)
+		return "", result.Error
+	}
+
+	return token, nil
+}
+
+func getPasswordReset(token string) (PasswordReset, error) {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return PasswordReset{}, err
+	}
+
+	var reset PasswordReset
+	result := db.Where("hash = ? AND expires_at >= ?", hashToken(token), time.Now().Unix()).First(&reset)
+	if result.Error != nil {
+		return PasswordReset{}, result.Error
+	}
+
+	return reset, nil
+}
+
+func usePasswordReset(reset PasswordReset) error {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	result := db.Unscoped().Where("id = ?", reset.ID).Delete(&PasswordReset{})
+	if result.Error != nil {
+		log.Println(result.Error)
+		return result.Error
+	}
+	if result.RowsAffected != 1 {
+		return fmt.Errorf("password reset already used")
+	}
+
+	return nil
+}
+
+func deleteKnownDevices(username string) error {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	result := db.Unscoped().Where("username = ?", username).Delete(&KnownDevice{})
+	if result.Error != nil {
+		log.Println(result.Error)
+		return result.Error
+	}
+
+	return nil
+}
+
+func rememberDevice(username string, device string) (bool, error) {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	hash := hashToken(device)
+	var devices []KnownDevice
+	result := db.Where("username = ?", username).Find(&devices)
+	if result.Error != nil {
+		log.Println(result.Error)
+		return false, result.Error
+	}
+	for _, known := range devices {
+		if known.Hash == hash {
+			return false, nil
+		}
+	}
+	result = db.Create(&KnownDevice{Username: username, Hash: hash})
+	if result.Error != nil {
+		log.Println(result.Error)
+		return false, result.Error
+	}
+
+	return len(devices) > 0, nil
+}
+
+func setTotpPending(username string, secret string) error {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	result := db.Model(&User{}).Where("username = ?", username).Update("totp_pending", secret)
+	if result.Error != nil {
+		log.Println(result.Error)
+		return result.Error
+	}
+
+	return nil
+}
+
+func enableTotp(username string, secret string, step int64) error {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	result := db.Model(&User{}).Where("username = ?", username).Updates(map[string]interface{}{"totp": secret, "totp_pending": "", "totp_last_step": step})
+	if result.Error != nil {
+		log.Println(result.Error)
+		return result.Error
+	}
+
+	return nil
+}
+
+func useTotpStep(username string, step int64) error {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	result := db.Model(&User{}).Where("username = ? AND totp_last_step < ?", username, step).Update("totp_last_step", step)
+	if result.Error != nil {
+		log.Println(result.Error)
+		return result.Error
+	}
+	if result.RowsAffected != 1 {
+		return fmt.Errorf("totp code already used")
+	}
+
+	return nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
+
+func recoveryHash(code string, salt string) string {
+	if salt == "" {
+		return hashToken(code)
+	}
+	raw, err := hex.DecodeString(salt)
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(argon2.IDKey([]byte(code), raw, 1, 19*1024, 1, 32))
+}
+
+func setRecoveryCodes(username string) ([]string, error) {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	result := db.Unscoped().Where("username = ?", username).Delete(&RecoveryCode{})
+	if result.Error != nil {
+		log.Println(result.Error)
+		return nil, result.Error
+	}
+	rawSalt, err3 := generateRandomKey(16)
+	if err3 != nil {
+		log.Println(err3)
+		return nil, err3
+	}
+	salt := hex.EncodeToString(rawSalt)
+	var codes []string
+	for i := 0; i < 10; i++ {
+		secret, err2 := generateRandomKey(5)
+		if err2 != nil {
+			log.Println(err2)
+			return nil, err2
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(secret))
+		result = db.Create(&RecoveryCode{Username: username, Salt: salt, Hash: recoveryHash(code, salt)})
+		if result.Error != nil {
+			log.Println(result.Error)
+			return nil, result.Error
+		}
+		codes = append(codes, code[:4]+"-"+code[4:])
+	}
+
+	return codes, nil
+}
+
+func useRecoveryCode(username string, code string) error {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	var salts []string
+	result := db.Model(&RecoveryCode{}).Where("username = ?", username).Distinct().Pluck("coalesce(salt, '')", &salts)
+	if result.Error != nil {
+		log.Println(result.Error)
+		return result.Error
+	}
+	code = normalizeRecoveryCode(code)
+	for _, salt := range salts {
+		result = db.Unscoped().Where("username = ? AND coalesce(salt, '') = ? AND hash = ?", username, salt, recoveryHash(code, salt)).Delete(&RecoveryCode{})
+		if result.Error != nil {
+			log.Println(result.Error)
+			return result.Error
+		}
+		if result.RowsAffected == 1 {
+			log.Println("Recovery code used by", username)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid recovery code")
+}
+
+func countRecoveryCodes(username string) (int64, error) {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+
+	var count int64
+	result := db.Model(&RecoveryCode{}).Where("username = ?", username).Count(&count)
+	if result.Error != nil {
+		log.Println(result.Error)
+		return 0, result.Error
+	}
+
+	return count, nil
+}
+
+func disableTotp(username string) error {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	result := db.Model(&User{}).Where("username = ?", username).Updates(map[string]interface{}{"totp": "", "totp_pending": "", "totp_last_step": 0})
+	if result.Error != nil {
+		log.Println(result.Error)
+		return result.Error
+	}
+	var keys int64
+	result = db.Model(&WebauthnCredential{}).Where("username = ?", username).Count(&keys)
+	if result.Error != nil {
+		log.Println(result.Error)
+		return result.Error
+	}
+	if keys > 0 {
+		return nil
+	}
+
+	return deleteRecoveryCodes(username)
+}
+
+func deleteRecoveryCodes(username string) error {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	result := db.Unscoped().Where("username = ?", username).Delete(&RecoveryCode{})
+	if result.Error != nil {
+		log.Println(result.Error)
+		return result.Error
+	}
+
+	return nil
+}
+
+func validateTotp(secret string, code string, now time.Time) bool {
+	valid, err := totp.ValidateCustom(code, secret, now, totp.ValidateOpts{
+		Period:    30,
+		Skew:      0,
+		Digits:    6,
+		Algorithm: otp.AlgorithmSHA512,
+	})
+	return err == nil && valid
+}
+
+func verifySecondFactor(user User, code string) bool {
+	code = strings.TrimSpace(code)
+	if len(code) == 6 && strings.Trim(code, "0123456789") == "" {
+		if user.Totp == "" {
+			return false
+		}
+		now := time.Now().UTC()
+		if !validateTotp(user.Totp, code, now) {
+			return false
+		}
+		err := useTotpStep(user.Username, now.Unix()/30)
+		if err != nil {
+			log.Println(err)
+			return false
+		}
+		return true
+	}
+	return useRecoveryCode(user.Username, code) == nil
+}
+
+func setVerificationCode(username string) (string, error) {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return "", err
+	}
+
+	code := generateCode()
+	result := db.Model(&User{}).Where("username = ? AND verified = ?", username, false).Updates(map[string]interface{}{"code": hashToken(code), "code_expires_at": time.Now().Add(verifyTTL).Unix()})
+	if result.Error != nil {
+		log.Println(result.Error)
+		return "", result.Error
+	}
+	if result.RowsAffected != 1 {
+		return "", gorm.ErrRecordNotFound
+	}
+
+	return code, nil
+}
+
+func deleteUnverifiedUsers() error {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	result := db.Unscoped().Where("verified = ? AND created_at < ?", false, time.Now().Add(-unverifiedTTL)).Delete(&User{})
+	if result.Error != nil {
+		log.Println(result.Error)
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Println("Deleted", result.RowsAffected, "unverified accounts")
+	}
+
+	return nil
+}
+
+func cleanupUnverifiedUsers() {
+	for {
+		err := deleteUnverifiedUsers()
+		if err != nil {
+			log.Println(err)
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+func deleteUser(username string) error {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	result := db.Unscoped().Where("username = ?", username).Delete(&User{})
+	if result.Error != nil {
+		log.Println(result.Error)
+		return result.Error
+	}
+
+	return nil
+}
+
+func generateCode() string {
+	code := uuid.New()
+	return code.String()
+}
+
+func generateRandomKey(keyLength int) ([]byte, error) {
+	key := make([]byte, keyLength)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func loadSigningKeys() error {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	var keys []SigningKey
+	result := db.Where("created_at > ?", time.Now().Add(-keyRotation-accessTokenTTL)).Order("created_at desc").Find(&keys)
+	if result.Error != nil {
+		log.Println(result.Error)
+		return result.Error
+	}
+	signingKeys = keys
+
+	return nil
+}
+
+func rotateSigningKey() error {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	secret, err2 := generateRandomKey(32)
+	if err2 != nil {
+		log.Println(err2)
+		return err2
+	}
+	key := SigningKey{Kid: generateCode(), Secret: base64.StdEncoding.EncodeToString(secret)}
+	result := db.Create(&key)
+	if result.Error != nil {
+		log.Println(result.Error)
+		return result.Error
+	}
+	result = db.Unscoped().Where("created_at <= ?", time.Now().Add(-keyRotation-accessTokenTTL)).Delete(&SigningKey{})
+	if result.Error != nil {
+		log.Println(result.Error)
+	}
+	log.Println("Rotated signing key", key.Kid)
+
+	return loadSigningKeys()
+}
+
+func currentSigningKey() (SigningKey, error) {
+	signingKeysMutex.Lock()
+	defer signingKeysMutex.Unlock()
+	if len(signingKeys) == 0 || time.Since(signingKeys[0].CreatedAt) >= keyRotation {
+		err := rotateSigningKey()
+		if err != nil {
+			return SigningKey{}, err
+		}
+	}
+	if len(signingKeys) == 0 {
+		return SigningKey{}, fmt.Errorf("no signing key")
+	}
+	return signingKeys[0], nil
+}
+
+func signingKey(kid string) ([]byte, error) {
+	signingKeysMutex.Lock()
+	defer signingKeysMutex.Unlock()
+	for _, key := range signingKeys {
+		if key.Kid == kid {
+			return []byte(key.Secret), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %s", kid)
+}
+
+func generateToken(username string, session string) (string, error) {
+	key, err := currentSigningKey()
+	if err != nil {
+		return "", err
+	}
+	claims := CustomClaims{
+		Username: username,
+		Session:  session,
+		StandardClaims: jwt.StandardClaims{
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Add(accessTokenTTL).Unix(),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = key.Kid
+	return token.SignedString([]byte(key.Secret))
+}
+
+func addChallenge() (string, error) {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return "", err
+	}
+
+	value, err2 := generateRandomKey(32)
+	if err2 != nil {
+		log.Println(err2)
+		return "", err2
+	}
+	challenge := Challenge{Value: hex.EncodeToString(value), ExpiresAt: time.Now().Add(challengeTTL).Unix()}
+	result := db.Create(&challenge)
+	if result.Error != nil {
+		log.Println(result.Error)
+		return "", result.Error
+	}
+	result = db.Unscoped().Where("expires_at < ?", time.Now().Unix()).Delete(&Challenge{})
+	if result.Error != nil {
+		log.Println(result.Error)
+	}
+
+	return challenge.Value, nil
+}
+
+func useChallenge(value string) error {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	result := db.Unscoped().Where("value = ? AND expires_at >= ?", value, time.Now().Unix()).Delete(&Challenge{})
+	if result.Error != nil {
+		log.Println(result.Error)
+		return result.Error
+	}
+	if result.RowsAffected != 1 {
+		return fmt.Errorf("unknown or expired challenge")
+	}
+
+	return nil
+}
+
+func getClientKey(name string) (ClientKey, error) {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return ClientKey{}, err
+	}
+
+	var key ClientKey
+	result := db.Where("name = ? AND revoked = ?", name, false).First(&key)
+	if result.Error != nil {
+		return ClientKey{}, result.Error
+	}
+
+	return key, nil
+}
+
+func getClientKeys() ([]ClientKey, error) {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	var keys []ClientKey
+	result := db.Order("name").Find(&keys)
+	if result.Error != nil {
+		log.Println(result.Error)
+		return nil, result.Error
+	}
+
+	return keys, nil
+}
+
+func addClientKey(name string, secret string) error {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	result := db.Create(&ClientKey{Name: name, Secret: secret})
+	if result.Error != nil {
+		log.Println(result.Error)
+		return result.Error
+	}
+
+	return nil
+}
+
+func revokeClientKey(name string) error {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	result := db.Model(&ClientKey{}).Where("name = ?", name).Update("revoked", true)
+	if result.Error != nil {
+		log.Println(result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func seedClientKey(name string, secret string) error {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	var count int64
+	result := db.Model(&ClientKey{}).Where("name = ?", name).Count(&count)
+	if result.Error != nil {
+		log.Println(result.Error)
+		return result.Error
+	}
+	if count > 0 {
+		return nil
+	}
+	log.Println("Added client key", name)
+
+	return addClientKey(name, secret)
+}
+
+func clientProof(secret string, method string, path string, timestamp string, challenge string, body []byte) string {
+	hash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join([]string{method, path, timestamp, challenge, hex.EncodeToString(hash[:])}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func addRefreshToken(username string, family string) (string, error) {
 	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
 	if err != nil {
 		log.Println(err)
-		return err
+		return "", err
+	}
+
+	secret, err2 := generateRandomKey(32)
+	if err2 != nil {
+		log.Println(err2)
+		return "", err2
+	}
+	token := hex.EncodeToString(secret)
+	result := db.Create(&RefreshToken{
+		Username:  username,
+		Hash:      hashToken(token),
+		Family:    family,
+		ExpiresAt: time.Now().Add(refreshTokenTTL).Unix(),
+	})
+	if result.Error != nil {
+		log.Println(result.Error)
+		return "", result.Error
+	}
+	result = db.Unscoped().Where("expires_at < ?", time.Now().Unix()).Delete(&RefreshToken{})
+	if result.Error != nil {
+		log.Println(result.Error)
 	}
-	err = db.AutoMigrate(&User{})
+
+	return token, nil
+}
+
+func getRefreshToken(hash string) (RefreshToken, error) {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
 	if err != nil {
 		log.Println(err)
-		return err
+		return RefreshToken{}, err
 	}
 
-	return nil
+	var token RefreshToken
+	result := db.Where("hash = ?", hash).First(&token)
+	if result.Error != nil {
+		log.Println(result.Error)
+		return RefreshToken{}, result.Error
+	}
+
+	return token, nil
 }
 
-func addUser(user User) error {
+func useRefreshToken(token RefreshToken) error {
 	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
 	if err != nil {
 		log.Println(err)
 		return err
 	}
 
-	result := db.Create(&user)
+	result := db.Model(&RefreshToken{}).Where("id = ? AND used = ?", token.ID, false).Update("used", true)
 	if result.Error != nil {
 		log.Println(result.Error)
 		return result.Error
 	}
+	if result.RowsAffected != 1 {
+		return fmt.Errorf("refresh token already used")
+	}
 
 	return nil
 }
 
-func updateUser(user User) error {
+func addSession(session Session) error {
 	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
 	if err != nil {
 		log.Println(err)
 		return err
 	}
 
-	result := db.Save(&user)
+	result := db.Create(&session)
 	if result.Error != nil {
 		log.Println(result.Error)
 		return result.Error
 	}
+	result = db.Unscoped().Where("last_seen < ?", time.Now().Add(-refreshTokenTTL)).Delete(&Session{})
+	if result.Error != nil {
+		log.Println(result.Error)
+	}
 
 	return nil
 }
 
-func getUser(username string) (User, error) {
+func getSession(sid string) (Session, error) {
 	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
 	if err != nil {
 		log.Println(err)
-		return User{}, err
+		return Session{}, err
 	}
 
-	var user User
-	result := db.Where("username = ?", username).First(&user)
+	var session Session
+	result := db.Where("sid = ?", sid).First(&session)
 	if result.Error != nil {
-		log.Println(result.Error)
-		return User{}, result.Error
+		return Session{}, result.Error
 	}
 
-	return user, nil
+	return session, nil
 }
 
-func getUserByCode(code string) (User, error) {
+func getSessions(username string) ([]Session, error) {
 	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
 	if err != nil {
 		log.Println(err)
-		return User{}, err
+		return nil, err
 	}
 
-	var user User
-	result := db.Where("code = ?", code).First(&user)
+	var sessions []Session
+	result := db.Where("username = ?", username).Order("last_seen desc").Find(&sessions)
 	if result.Error != nil {
 		log.Println(result.Error)
-		return User{}, result.Error
+		return nil, result.Error
 	}
 
-	return user, nil
+	return sessions, nil
 }
 
-func setVerified(username string) error {
+func touchSession(session Session, ip string) error {
 	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
 	if err != nil {
 		log.Println(err)
 		return err
 	}
 
-	result := db.Model(&User{}).Where("username = ?", username).Update("verified", true)
+	result := db.Model(&session).Updates(map[string]interface{}{"last_seen": time.Now(), "ip": ip})
 	if result.Error != nil {
 		log.Println(result.Error)
 		return result.Error
@@ -147,30 +1118,43 @@ func setVerified(username string) error {
 	return nil
 }
 
-func setTotp(username string, totp string) error {
+func revokeSession(username string, sid string) error {
 	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
 	if err != nil {
 		log.Println(err)
 		return err
 	}
 
-	result := db.Model(&User{}).Where("username = ?", username).Update("totp", totp)
+	result := db.Unscoped().Where("username = ? AND family = ?", username, sid).Delete(&RefreshToken{})
+	if result.Error != nil {
+		log.Println(result.Error)
+		return result.Error
+	}
+	result = db.Unscoped().Where("username = ? AND sid = ?", username, sid).Delete(&Session{})
 	if result.Error != nil {
 		log.Println(result.Error)
 		return result.Error
 	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
 
 	return nil
 }
 
-func deleteUser(username string) error {
+func revokeSessions(username string, keep string) error {
 	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
 	if err != nil {
 		log.Println(err)
 		return err
 	}
 
-	result := db.Unscoped().Where("username = ?", username).Delete(&User{})
+	result := db.Unscoped().Where("username = ? AND family <> ?", username, keep).Delete(&RefreshToken{})
+	if result.Error != nil {
+		log.Println(result.Error)
+		return result.Error
+	}
+	result = db.Unscoped().Where("username = ? AND sid <> ?", username, keep).Delete(&Session{})
 	if result.Error != nil {
 		log.Println(result.Error)
 		return result.Error
@@ -179,29 +1163,16 @@ func deleteUser(username string) error {
 	return nil
 }
 
-func generateCode() string {
-	code := uuid.New()
-	return code.String()
-}
-
-func generateRandomKey(keyLength int) ([]byte, error) {
-	key := make([]byte, keyLength)
-	_, err := rand.Read(key)
+func issueTokens(username string, family string) (string, string, error) {
+	token, err := generateToken(username, family)
 	if err != nil {
-		return nil, err
+		return "", "", err
 	}
-	return key, nil
-}
-
-func generateToken(username string) (string, error) {
-	claims := CustomClaims{
-		Username: username,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(time.Hour * 2).Unix(),
-		},
+	refresh, err2 := addRefreshToken(username, family)
+	if err2 != nil {
+		return "", "", err2
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(secretKey)
+	return token, refresh, nil
 }
 
 func CheckPasswordHash(password, hash string) bool {
@@ -209,6 +1180,61 @@ func CheckPasswordHash(password, hash string) bool {
 	return err == nil
 }
 
+func clientAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client := c.GetHeader("X-Auth-Client")
+		challenge := c.GetHeader("X-Auth-Challenge")
+		timestamp := c.GetHeader("X-Auth-Timestamp")
+		hash := c.GetHeader("X-Auth-Hash")
+		if client == "" || challenge == "" || timestamp == "" || hash == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+			c.Abort()
+			return
+		}
+
+		unix, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil || math.Abs(time.Since(time.Unix(unix, 0)).Seconds()) > challengeTTL.Seconds() {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+			c.Abort()
+			return
+		}
+
+		key, err2 := getClientKey(client)
+		if err2 != nil {
+			log.Println("Unknown or revoked client", client)
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+			c.Abort()
+			return
+		}
+
+		body, err3 := c.GetRawData()
+		if err3 != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		expected := clientProof(key.Secret, c.Request.Method, c.Request.URL.Path, timestamp, challenge, body)
+		if !hmac.Equal([]byte(expected), []byte(hash)) {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+			c.Abort()
+			return
+		}
+
+		err4 := useChallenge(challenge)
+		if err4 != nil {
+			log.Println(err4)
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+			c.Abort()
+			return
+		}
+
+		c.Set("client", client)
+		c.Next()
+	}
+}
+
 func authMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tokenString := c.GetHeader("Authorization")
@@ -218,8 +1244,14 @@ func authMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		tokenString = strings.TrimPrefix(tokenString, "Bearer ")
+
 		token, err := jwt.ParseWithClaims(tokenString, &CustomClaims{}, func(token *jwt.Token) (interface{}, error) {
-			return secretKey, nil
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+			}
+			kid, _ := token.Header["kid"].(string)
+			return signingKey(kid)
 		})
 
 		if err != nil {
@@ -229,7 +1261,20 @@ func authMiddleware() gin.HandlerFunc {
 		}
 
 		if claims, ok := token.Claims.(*CustomClaims); ok && token.Valid {
+			session, err2 := getSession(claims.Session)
+			if err2 != nil || session.Username != claims.Username {
+				c.JSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized"})
+				c.Abort()
+				return
+			}
+			if time.Since(session.LastSeen) > time.Minute || session.IP != c.ClientIP() {
+				err3 := touchSession(session, c.ClientIP())
+				if err3 != nil {
+					log.Println(err3)
+				}
+			}
 			c.Set("username", claims.Username)
+			c.Set("session", claims.Session)
 			c.Next()
 		} else {
 			c.JSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized"})
@@ -238,159 +1283,282 @@ func authMiddleware() gin.HandlerFunc {
 	}
 }
 
-func loginHandler(c *gin.Context) {
-	if c.Request.Method == "GET" {
-		challenge, err := generateRandomKey(32)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
-			return
-		}
-		c.JSON(http.StatusOK, gin.H{"challenge": hex.EncodeToString(challenge)})
+func challengeHandler(c *gin.Context) {
+	challenge, err := addChallenge()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
 		return
 	}
+	c.JSON(http.StatusOK, gin.H{"challenge": challenge, "expires_in": int(challengeTTL.Seconds())})
+}
+
+type loginRequest struct {
+	Username string          `json:"username"`
+	Password string          `json:"password"`
+	Totp     string          `json:"totp"`
+	Webauthn json.RawMessage `json:"webauthn"`
+	Device   string          `json:"device"`
+	Salt     string          `json:"salt"`
+	Verifier string          `json:"verifier"`
+	Session  string          `json:"session"`
+	Proof    string          `json:"proof"`
+}
+
+func loginHandler(c *gin.Context) {
+	var data loginRequest
 
-	chall := c.GetHeader("X-Auth-Challenge")
-	hash := c.GetHeader("X-Auth-Hash")
-	if hash == "" {
+	if err := c.ShouldBindJSON(&data); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
 		return
 	}
 
-	expectedHash := sha256.Sum256([]byte(chall + passwordDesktop))
-	expectedHashString := hex.EncodeToString(expectedHash[:])
-	expectedHash2 := sha256.Sum256([]byte(chall + passwordIOS))
-	expectedHashString2 := hex.EncodeToString(expectedHash2[:])
+	data.Username = strings.ToLower(data.Username)
 
-	if bytes.Equal([]byte(expectedHashString), []byte(hash)) || bytes.Equal([]byte(expectedHashString2), []byte(hash)) {
-		var data struct {
-			Username string `json:"username"`
-			Password string `json:"password"`
-			Totp     string `json:"totp"`
-		}
+	if !validateEmail(data.Username) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		return
+	}
 
-		if err := c.ShouldBindJSON(&data); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
-			return
-		}
+	if ok, wait := accountLimiter.allow(data.Username); !ok {
+		tooManyRequests(c, wait)
+		return
+	}
 
-		data.Username = strings.ToLower(data.Username)
+	user, err2 := getUser(data.Username)
+	if err2 != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Invalid credentials"})
+		return
+	}
 
-		if !validateEmail(data.Username) {
-			c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
-			return
-		}
+	if !user.Verified {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Invalid credentials"})
+		return
+	}
 
-		user, err2 := getUser(data.Username)
-		if err2 != nil {
-			c.JSON(http.StatusNotFound, gin.H{"message": "Invalid credentials"})
-			return
-		}
+	if user.Verifier != "" {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Invalid credentials"})
+		return
+	}
 
-		if !user.Verified {
-			c.JSON(http.StatusNotFound, gin.H{"message": "Invalid credentials"})
-			return
-		}
+	if wait := time.Until(time.Unix(user.LockedUntil, 0)); wait > 0 {
+		tooManyRequests(c, wait)
+		return
+	}
 
-		if user.Totp != "" {
-			valid, err := totp.ValidateCustom(data.Totp, user.Totp, time.Now().UTC(), totp.ValidateOpts{
-				Period:    30,
-				Skew:      0,
-				Digits:    6,
-				Algorithm: otp.AlgorithmSHA512,
-			})
-			if err != nil {
-				c.JSON(http.StatusMethodNotAllowed, gin.H{"message": "Invalid credentials"})
-				return
-			}
-			if !valid {
-				c.JSON(http.StatusMethodNotAllowed, gin.H{"message": "Invalid credentials"})
-				return
-			}
+	if !CheckPasswordHash(data.Password, user.Password) {
+		err4 := failedLogin(user)
+		if err4 != nil {
+			log.Println(err4)
 		}
+		c.JSON(http.StatusNotFound, gin.H{"message": "Invalid credentials"})
+		return
+	}
 
-		match := CheckPasswordHash(data.Password, user.Password)
-		if !match {
-			c.JSON(http.StatusNotFound, gin.H{"message": "Invalid credentials"})
-			return
+	keys, ok := askSecondFactor(c, user, data)
+	if !ok {
+		return
+	}
+
+	if !secondFactor(user, keys, data) {
+		err5 := failedLogin(user)
+		if err5 != nil {
+			log.Println(err5)
 		}
+		c.JSON(http.StatusNotFound, gin.H{"message": "Invalid credentials"})
+		return
+	}
 
-		token, err3 := generateToken(data.Username)
+	if data.Verifier != "" && validVerifier(data.Salt, data.Verifier) {
+		err3 := setCredentials(user.Username, "", data.Salt, data.Verifier)
 		if err3 != nil {
-			c.JSON(http.StatusNotFound, gin.H{"message": "Invalid credentials"})
-			return
+			log.Println(err3)
+		} else {
+			log.Println("Upgraded", user.Username, "to SRP")
 		}
+	}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Logged in", "token": token})
-	} else {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
-		return
+	startSession(c, user, data.Device, gin.H{})
+}
+
+func askSecondFactor(c *gin.Context, user User, data loginRequest) ([]WebauthnCredential, bool) {
+	keys, err := getWebauthnCredentials(user.Username)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Invalid credentials"})
+		return nil, false
 	}
+
+	if user.Totp == "" && len(keys) == 0 {
+		return keys, true
+	}
+
+	if data.Totp == "" && len(data.Webauthn) == 0 {
+		response := gin.H{"message": "Invalid credentials", "totp": user.Totp != ""}
+		if len(keys) > 0 {
+			assertion, err2 := beginWebauthnLogin(user, keys)
+			if err2 != nil {
+				c.JSON(http.StatusNotFound, gin.H{"message": "Invalid credentials"})
+				return nil, false
+			}
+			response["webauthn"] = assertion
+		}
+		c.JSON(http.StatusMethodNotAllowed, response)
+		return nil, false
+	}
+
+	return keys, true
 }
 
-func registerHandler(c *gin.Context) {
-	if c.Request.Method == "GET" {
-		challenge, err := generateRandomKey(32)
+func secondFactor(user User, keys []WebauthnCredential, data loginRequest) bool {
+	if user.Totp == "" && len(keys) == 0 {
+		return true
+	}
+
+	if len(data.Webauthn) > 0 && len(keys) > 0 {
+		err := finishWebauthnLogin(user, keys, data.Webauthn)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
-			return
+			log.Println(err)
 		}
-		c.JSON(http.StatusOK, gin.H{"challenge": hex.EncodeToString(challenge)})
+		return err == nil
+	}
+	return data.Totp != "" && verifySecondFactor(user, data.Totp)
+}
+
+func startSession(c *gin.Context, user User, device string, response gin.H) {
+	err := resetFailedLogins(user)
+	if err != nil {
+		log.Println(err)
+	}
+
+	if device == "" {
+		device = c.Request.UserAgent()
+	}
+	session := Session{
+		Sid:       generateCode(),
+		Username:  user.Username,
+		Device:    device,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		LastSeen:  time.Now(),
+	}
+	err2 := addSession(session)
+	if err2 != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Invalid credentials"})
 		return
 	}
 
-	chall := c.GetHeader("X-Auth-Challenge")
-	hash := c.GetHeader("X-Auth-Hash")
-	if hash == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+	newDevice, err3 := rememberDevice(user.Username, session.Device+"\n"+session.UserAgent)
+	if err3 != nil {
+		log.Println(err3)
+	}
+	if newDevice {
+		sendLoginEmail(user.Username, session)
+	}
+
+	token, refresh, err4 := issueTokens(user.Username, session.Sid)
+	if err4 != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Invalid credentials"})
 		return
 	}
 
-	expectedHash := sha256.Sum256([]byte(chall + passwordDesktop))
-	expectedHashString := hex.EncodeToString(expectedHash[:])
-	expectedHash2 := sha256.Sum256([]byte(chall + passwordIOS))
-	expectedHashString2 := hex.EncodeToString(expectedHash2[:])
+	response["message"] = "Logged in"
+	response["token"] = token
+	response["refresh_token"] = refresh
+	response["expires_in"] = int(accessTokenTTL.Seconds())
+	c.JSON(http.StatusOK, response)
+}
+
+func registerHandler(c *gin.Context) {
+	var data User
 
-	if bytes.Equal([]byte(expectedHashString), []byte(hash)) || bytes.Equal([]byte(expectedHashString2), []byte(hash)) {
-		var data User
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		return
+	}
 
-		if err := c.ShouldBindJSON(&data); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
-			return
-		}
+	data.Username = strings.ToLower(data.Username)
 
-		data.Username = strings.ToLower(data.Username)
+	if !validateEmail(data.Username) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		return
+	}
 
-		if !validateEmail(data.Username) {
-			c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
-			return
-		}
+	if !validVerifier(data.Salt, data.Verifier) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		return
+	}
 
-		if !isPasswordSecure(data.Password) {
-			c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
-			return
-		}
+	if ok, wait := accountLimiter.allow("register:" + data.Username); !ok {
+		tooManyRequests(c, wait)
+		return
+	}
 
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(data.Password), 14)
-		if err != nil {
-			c.AbortWithStatus(http.StatusNotFound)
-			return
-		}
+	data.Password = ""
+	code := generateCode()
+	data.Verified = false
+	data.Code = hashToken(code)
+	data.CodeExpiresAt = time.Now().Add(verifyTTL).Unix()
 
-		data.Password = string(hashedPassword)
-		data.Verified = false
-		data.Code = generateCode()
+	err2 := addUser(data)
+	if err2 != nil {
+		c.JSON(http.StatusCreated, gin.H{"message": "Check your email for verification"})
+		return
+	}
+	sendEmail(data.Username, code)
+	c.JSON(http.StatusCreated, gin.H{"message": "Check your email for verification"})
+}
 
-		err2 := addUser(data)
+func refreshHandler(c *gin.Context) {
+	var data struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.ShouldBindJSON(&data); err != nil || data.RefreshToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		return
+	}
+	token, err := getRefreshToken(hashToken(data.RefreshToken))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized"})
+		return
+	}
+	if token.Used {
+		log.Println("Refresh token reused, revoking session", token.Family)
+		err2 := revokeSession(token.Username, token.Family)
 		if err2 != nil {
-			c.JSON(http.StatusCreated, gin.H{"message": "Check your email for verification"})
-			return
+			log.Println(err2)
 		}
-		sendEmail(data.Username, data.Code)
-		c.JSON(http.StatusCreated, gin.H{"message": "Check your email for verification"})
-	} else {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized"})
+		return
+	}
+	if time.Now().Unix() > token.ExpiresAt {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized"})
+		return
+	}
+	user, err3 := getUser(token.Username)
+	if err3 != nil || !user.Verified {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized"})
+		return
+	}
+	session, err6 := getSession(token.Family)
+	if err6 != nil || session.Username != token.Username {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized"})
+		return
+	}
+	err4 := useRefreshToken(token)
+	if err4 != nil {
+		log.Println(err4)
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized"})
+		return
+	}
+	access, refresh, err5 := issueTokens(token.Username, token.Family)
+	if err5 != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized"})
 		return
 	}
+	err7 := touchSession(session, c.ClientIP())
+	if err7 != nil {
+		log.Println(err7)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Token refreshed", "token": access, "refresh_token": refresh, "expires_in": int(accessTokenTTL.Seconds())})
 }
 
 func verifyHandler(c *gin.Context) {
@@ -399,15 +1567,19 @@ func verifyHandler(c *gin.Context) {
 		c.HTML(http.StatusBadRequest, "verified.html", gin.H{"message": "Invalid query parameter"})
 		return
 	}
-	user, err := getUserByCode(code)
+	user, err := getUserByCode(hashToken(code))
 	if err != nil {
-		c.HTML(http.StatusNotFound, "verified.html", gin.H{"message": "Account already verified"})
+		c.HTML(http.StatusNotFound, "verified.html", gin.H{"message": "Invalid or already used verification link"})
 		return
 	}
 	if user.Verified {
 		c.HTML(http.StatusNotFound, "verified.html", gin.H{"message": "Account already verified"})
 		return
 	}
+	if time.Now().Unix() > user.CodeExpiresAt {
+		c.HTML(http.StatusGone, "verified.html", gin.H{"message": "Verification link expired, request a new one in the app"})
+		return
+	}
 	err2 := setVerified(user.Username)
 	if err2 != nil {
 		c.HTML(http.StatusNotFound, "verified.html", gin.H{"message": "Account already verified"})
@@ -416,6 +1588,35 @@ func verifyHandler(c *gin.Context) {
 	c.HTML(http.StatusOK, "verified.html", gin.H{"message": "Account verified"})
 }
 
+func resendHandler(c *gin.Context) {
+	var data struct {
+		Username string `json:"username"`
+	}
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		return
+	}
+	data.Username = strings.ToLower(data.Username)
+	if !validateEmail(data.Username) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		return
+	}
+	if ok, wait := accountLimiter.allow("resend:" + data.Username); !ok {
+		tooManyRequests(c, wait)
+		return
+	}
+	user, err := getUser(data.Username)
+	if err == nil && !user.Verified {
+		code, err2 := setVerificationCode(user.Username)
+		if err2 != nil {
+			log.Println(err2)
+		} else {
+			sendEmail(user.Username, code)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "If the account exists and is not verified, a new verification email has been sent"})
+}
+
 func otpGenerateHandler(c *gin.Context) {
 	username := c.GetString("username")
 	user, err3 := getUser(username)
@@ -427,6 +1628,23 @@ func otpGenerateHandler(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to generate QR code"})
 		return
 	}
+	if user.Totp != "" {
+		var data struct {
+			Code string `json:"code"`
+		}
+		if err4 := c.ShouldBindJSON(&data); err4 != nil {
+			c.JSON(http.StatusMethodNotAllowed, gin.H{"message": "Invalid credentials"})
+			return
+		}
+		if ok, wait := accountLimiter.allow("otp:" + username); !ok {
+			tooManyRequests(c, wait)
+			return
+		}
+		if !verifySecondFactor(user, data.Code) {
+			c.JSON(http.StatusMethodNotAllowed, gin.H{"message": "Invalid credentials"})
+			return
+		}
+	}
 	key, err := totp.Generate(totp.GenerateOpts{
 		Issuer:      "Finalpass",
 		AccountName: username,
@@ -439,13 +1657,53 @@ func otpGenerateHandler(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to generate QR code"})
 		return
 	}
-	err2 := setTotp(username, key.Secret())
+	err2 := setTotpPending(username, key.Secret())
 	if err2 != nil {
 		log.Println(err2)
 		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to generate QR code"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "Generated QR code", "qr": key.String()})
+	c.JSON(http.StatusOK, gin.H{"message": "Generated QR code, confirm it with a code from your authenticator app", "qr": key.String()})
+}
+
+func otpConfirmHandler(c *gin.Context) {
+	username := c.GetString("username")
+	user, err3 := getUser(username)
+	if err3 != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to enable OTP"})
+		return
+	}
+	if !user.Verified || user.TotpPending == "" {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to enable OTP"})
+		return
+	}
+	var data struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		return
+	}
+	if ok, wait := accountLimiter.allow("otp:" + username); !ok {
+		tooManyRequests(c, wait)
+		return
+	}
+	now := time.Now().UTC()
+	if !validateTotp(user.TotpPending, strings.TrimSpace(data.Code), now) {
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"message": "Invalid code"})
+		return
+	}
+	err := enableTotp(username, user.TotpPending, now.Unix()/30)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to enable OTP"})
+		return
+	}
+	codes, err2 := setRecoveryCodes(username)
+	if err2 != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to create recovery codes"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "OTP enabled", "recovery_codes": codes})
 }
 
 func otpRemoveHandler(c *gin.Context) {
@@ -459,13 +1717,41 @@ func otpRemoveHandler(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to remove OTP"})
 		return
 	}
-	err2 := setTotp(username, "")
+	var data struct {
+		Code    string `json:"code"`
+		Session string `json:"session"`
+		Proof   string `json:"proof"`
+	}
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		return
+	}
+	if ok, wait := accountLimiter.allow("otp:" + username); !ok {
+		tooManyRequests(c, wait)
+		return
+	}
+	response := gin.H{"message": "OTP removed"}
+	if user.Totp != "" {
+		verified := data.Code != "" && verifySecondFactor(user, data.Code)
+		if !verified {
+			proof, ok := confirmPassword(user, data.Session, data.Proof)
+			if ok {
+				response["proof"] = proof
+			}
+			verified = ok
+		}
+		if !verified {
+			c.JSON(http.StatusMethodNotAllowed, gin.H{"message": "Invalid credentials"})
+			return
+		}
+	}
+	err2 := disableTotp(username)
 	if err2 != nil {
 		log.Println(err2)
 		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to remove OTP"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "OTP removed"})
+	c.JSON(http.StatusOK, response)
 }
 
 func passwordHandler(c *gin.Context) {
@@ -481,29 +1767,132 @@ func passwordHandler(c *gin.Context) {
 	}
 	var data struct {
 		CurrentPassword string `json:"currentpassword"`
-		NewPassword     string `json:"newpassword"`
+		Session         string `json:"session"`
+		Proof           string `json:"proof"`
+		Salt            string `json:"salt"`
+		Verifier        string `json:"verifier"`
 	}
 	if err := c.ShouldBindJSON(&data); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
 		return
 	}
-	match := CheckPasswordHash(data.CurrentPassword, user.Password)
-	if !match {
-		c.JSON(http.StatusNotFound, gin.H{"message": "Invalid credentials"})
+	if !validVerifier(data.Salt, data.Verifier) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
 		return
 	}
-	hashedPassword, err4 := bcrypt.GenerateFromPassword([]byte(data.NewPassword), 14)
-	if err4 != nil {
-		c.AbortWithStatus(http.StatusNotFound)
+	response := gin.H{"message": "Password changed, all other sessions have been logged out"}
+	if user.Verifier != "" {
+		proof, err6 := useSrpSession(user, "password", data.Session, data.Proof)
+		if err6 != nil {
+			log.Println(err6)
+			c.JSON(http.StatusNotFound, gin.H{"message": "Invalid credentials"})
+			return
+		}
+		response["proof"] = proof
+	} else if !CheckPasswordHash(data.CurrentPassword, user.Password) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Invalid credentials"})
 		return
 	}
-	user.Password = string(hashedPassword)
-	err2 := updateUser(user)
+	err2 := setCredentials(username, "", data.Salt, data.Verifier)
 	if err2 != nil {
 		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to change password"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "Password changed"})
+	err5 := revokeSessions(username, c.GetString("session"))
+	if err5 != nil {
+		log.Println(err5)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func forgotHandler(c *gin.Context) {
+	var data struct {
+		Username string `json:"username"`
+	}
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		return
+	}
+	data.Username = strings.ToLower(data.Username)
+	if !validateEmail(data.Username) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		return
+	}
+	if ok, wait := accountLimiter.allow("forgot:" + data.Username); !ok {
+		tooManyRequests(c, wait)
+		return
+	}
+	user, err := getUser(data.Username)
+	if err == nil && user.Verified {
+		token, err2 := addPasswordReset(user.Username)
+		if err2 != nil {
+			log.Println(err2)
+		} else {
+			sendResetEmail(user.Username, token)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "If the account exists, a reset code has been sent to your email"})
+}
+
+func resetHandler(c *gin.Context) {
+	var data struct {
+		Token    string          `json:"token"`
+		Totp     string          `json:"totp"`
+		Webauthn json.RawMessage `json:"webauthn"`
+		Salt     string          `json:"salt"`
+		Verifier string          `json:"verifier"`
+	}
+	if err := c.ShouldBindJSON(&data); err != nil || data.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		return
+	}
+	if !validVerifier(data.Salt, data.Verifier) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		return
+	}
+	reset, err := getPasswordReset(data.Token)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Invalid or expired reset code"})
+		return
+	}
+	if ok, wait := accountLimiter.allow("reset:" + reset.Username); !ok {
+		tooManyRequests(c, wait)
+		return
+	}
+	user, err2 := getUser(reset.Username)
+	if err2 != nil || !user.Verified {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Invalid or expired reset code"})
+		return
+	}
+	factor := loginRequest{Totp: data.Totp, Webauthn: data.Webauthn}
+	keys, ok := askSecondFactor(c, user, factor)
+	if !ok {
+		return
+	}
+	if !secondFactor(user, keys, factor) {
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"message": "Invalid credentials"})
+		return
+	}
+	err3 := usePasswordReset(reset)
+	if err3 != nil {
+		log.Println(err3)
+		c.JSON(http.StatusNotFound, gin.H{"message": "Invalid or expired reset code"})
+		return
+	}
+	err5 := setCredentials(user.Username, "", data.Salt, data.Verifier)
+	if err5 != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to reset password"})
+		return
+	}
+	err7 := resetFailedLogins(user)
+	if err7 != nil {
+		log.Println(err7)
+	}
+	err6 := revokeSessions(user.Username, "")
+	if err6 != nil {
+		log.Println(err6)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset, all sessions have been logged out"})
 }
 
 func settingsHandler(c *gin.Context) {
@@ -517,7 +1906,15 @@ func settingsHandler(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to get user"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "Account found", "verified": user.Verified, "totp": user.Totp != ""})
+	codes, err := countRecoveryCodes(username)
+	if err != nil {
+		log.Println(err)
+	}
+	keys, err2 := getWebauthnCredentials(username)
+	if err2 != nil {
+		log.Println(err2)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Account found", "verified": user.Verified, "totp": user.Totp != "", "recovery_codes": codes, "webauthn": len(keys)})
 }
 
 func saveHandler(c *gin.Context) {
@@ -546,8 +1943,7 @@ func saveHandler(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"message": "Invalid request body"})
 		return
 	}
-	maxFileSize := int64(10 * 1024 * 1024)
-	if file[0].Size > maxFileSize {
+	if file[0].Size > maxUploadSize {
 		c.JSON(http.StatusMethodNotAllowed, gin.H{"message": "Max file size exceeded"})
 		return
 	}
@@ -592,100 +1988,249 @@ func terminateHandler(c *gin.Context) {
 	if err != nil {
 		log.Println(err)
 	}
+	err8 := os.RemoveAll(attachmentDir(username))
+	if err8 != nil {
+		log.Println(err8)
+	}
+	err7 := deleteWebauthnCredentials(username)
+	if err7 != nil {
+		log.Println(err7)
+	}
+	err6 := disableTotp(username)
+	if err6 != nil {
+		log.Println(err6)
+	}
 	err2 := deleteUser(username)
 	if err2 != nil {
 		log.Println(err2)
 	}
+	err4 := revokeSessions(username, "")
+	if err4 != nil {
+		log.Println(err4)
+	}
+	err5 := deleteKnownDevices(username)
+	if err5 != nil {
+		log.Println(err5)
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Account terminated"})
 }
 
-func validateEmail(email string) bool {
-	emailRegex := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
-	return emailRegex.MatchString(email)
-}
-
-func sendEmail(toEmail string, code string) {
-	password := emailPassword
-	to := []string{
-		toEmail,
+func sessionsHandler(c *gin.Context) {
+	username := c.GetString("username")
+	user, err3 := getUser(username)
+	if err3 != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to get user"})
+		return
+	}
+	if !user.Verified {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to get user"})
+		return
 	}
-	smtpHost := "smtp.gmail.com"
-	smtpPort := "587"
-	message := []byte(fmt.Sprintf("Subject: Finalpass Email Verification\n\nThis email was sent by Finalpass\n\nClick this link to verify your account:\n\n%s/verify?code=%s\n\nIf you did not request this, please ignore this email.", url, code))
-	auth := smtp.PlainAuth("", email, password, smtpHost)
-	err := smtp.SendMail(smtpHost+":"+smtpPort, auth, email, to, message)
+	sessions, err := getSessions(username)
 	if err != nil {
-		log.Println(err)
+		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to get sessions"})
 		return
 	}
-	log.Println("Email Sent Successfully!")
+	list := []gin.H{}
+	for _, session := range sessions {
+		list = append(list, gin.H{
+			"id":         session.Sid,
+			"device":     session.Device,
+			"ip":         session.IP,
+			"user_agent": session.UserAgent,
+			"created_at": session.CreatedAt.Format("2006-01-02 15:04:05"),
+			"last_seen":  session.LastSeen.Format("2006-01-02 15:04:05"),
+			"current":    session.Sid == c.GetString("session"),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Sessions found", "sessions": list})
 }
 
-func isPasswordSecure(password string) bool {
-	if len(password) < 8 {
-		return false
+func revokeSessionHandler(c *gin.Context) {
+	username := c.GetString("username")
+	err := revokeSession(username, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Session not found"})
+		return
 	}
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
 
-	hasUppercase := false
-	for _, character := range password {
-		if character >= 'A' && character <= 'Z' {
-			hasUppercase = true
-			break
-		}
-	}
-	if !hasUppercase {
-		return false
+func logoutHandler(c *gin.Context) {
+	username := c.GetString("username")
+	err := revokeSession(username, c.GetString("session"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Session not found"})
+		return
 	}
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
 
-	hasDigit := false
-	for _, character := range password {
-		if character >= '0' && character <= '9' {
-			hasDigit = true
-			break
-		}
+func validateEmail(email string) bool {
+	emailRegex := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+	return emailRegex.MatchString(email)
+}
+
+func sendEmail(toEmail string, code string) {
+	sendMail(toEmail, "Finalpass Email Verification", "verify", map[string]interface{}{"url": url, "code": code})
+}
+
+func sendLockoutEmail(toEmail string) {
+	sendMail(toEmail, "Finalpass Account Locked", "lockout", map[string]interface{}{"attempts": maxFailedLogins, "minutes": int(lockoutDuration.Minutes())})
+}
+
+func sendResetEmail(toEmail string, token string) {
+	sendMail(toEmail, "Finalpass Password Reset", "reset", map[string]interface{}{"code": token, "minutes": int(resetTTL.Minutes())})
+}
+
+func sendLoginEmail(toEmail string, session Session) {
+	sendMail(toEmail, "Finalpass New Device Login", "login", map[string]interface{}{"device": session.Device, "ip": session.IP, "time": session.LastSeen.Format("2006-01-02 15:04:05")})
+}
+
+func clientsCommand(args []string) error {
+	usage := fmt.Errorf("usage: %s clients list | add <name> | revoke <name>", os.Args[0])
+	if len(args) < 2 || args[0] != "clients" {
+		return usage
 	}
-	if !hasDigit {
-		return false
+	err := initDB()
+	if err != nil {
+		return err
 	}
-
-	hasSpecialCharacter := false
-	for _, character := range password {
-		if (character >= '!' && character <= '/') ||
-			(character >= ':' && character <= '@') ||
-			(character >= '[' && character <= '`') ||
-			(character >= '{' && character <= '~') {
-			hasSpecialCharacter = true
-			break
+	switch {
+	case args[1] == "list" && len(args) == 2:
+		keys, err2 := getClientKeys()
+		if err2 != nil {
+			return err2
+		}
+		for _, key := range keys {
+			state := "active"
+			if key.Revoked {
+				state = "revoked"
+			}
+			fmt.Printf("%s\t%s\t%s\n", key.Name, state, key.CreatedAt.Format("2006-01-02 15:04:05"))
+		}
+	case args[1] == "add" && len(args) == 3:
+		secret, err2 := generateRandomKey(32)
+		if err2 != nil {
+			return err2
+		}
+		err3 := addClientKey(args[2], hex.EncodeToString(secret))
+		if err3 != nil {
+			return err3
+		}
+		fmt.Println(hex.EncodeToString(secret))
+	case args[1] == "revoke" && len(args) == 3:
+		err2 := revokeClientKey(args[2])
+		if err2 != nil {
+			return fmt.Errorf("client %s: %w", args[2], err2)
 		}
+	default:
+		return usage
 	}
-	return hasSpecialCharacter
+	return nil
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		err := clientsCommand(os.Args[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	err := godotenv.Load("config.env")
 	if err != nil {
 		log.Fatal("Error loading config.env file")
 	}
-	passwordDesktop = os.Getenv("PASSWORD_DESKTOP")
-	if passwordDesktop == "" {
-		log.Fatal("PASSWORD_DESKTOP environment variable is not set")
+	mailer, err4 := newMailer()
+	if err4 != nil {
+		log.Fatal(err4)
 	}
-	passwordIOS = os.Getenv("PASSWORD_IOS")
-	if passwordIOS == "" {
-		log.Fatal("PASSWORD_IOS environment variable is not set")
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = os.Getenv("EMAIL")
 	}
-	email = os.Getenv("EMAIL")
-	if email == "" {
-		log.Fatal("EMAIL environment variable is not set")
+	if from == "" {
+		log.Fatal("MAIL_FROM or EMAIL environment variable is not set")
 	}
-	emailPassword = os.Getenv("EMAIL_PASSWORD")
-	if emailPassword == "" {
-		log.Fatal("EMAIL_PASSWORD environment variable is not set")
+	err5 := loadMailTemplates("mail")
+	if err5 != nil {
+		log.Fatal("Error loading mail templates: ", err5)
 	}
+	mailQueue = newMailQueue(mailer, from, 5, 30*time.Second)
 	url = os.Getenv("URL")
 	if url == "" {
 		log.Fatal("URL environment variable is not set")
 	}
+	rp, err6 := newRelyingParty()
+	if err6 != nil {
+		log.Fatal("Error configuring WebAuthn: ", err6)
+	}
+	relyingParty = rp
+	if size := os.Getenv("MAX_UPLOAD_MB"); size != "" {
+		mb, err := strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			log.Fatal("MAX_UPLOAD_MB environment variable is not a number")
+		}
+		maxUploadSize = mb * 1024 * 1024
+	}
+	if size := os.Getenv("MAX_ATTACHMENT_MB"); size != "" {
+		mb, err := strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			log.Fatal("MAX_ATTACHMENT_MB environment variable is not a number")
+		}
+		maxAttachmentSize = mb * 1024 * 1024
+	}
+	if minutes := os.Getenv("ACCESS_TOKEN_MINUTES"); minutes != "" {
+		n, err := strconv.Atoi(minutes)
+		if err != nil || n <= 0 {
+			log.Fatal("ACCESS_TOKEN_MINUTES environment variable is not a number")
+		}
+		accessTokenTTL = time.Duration(n) * time.Minute
+	}
+	if days := os.Getenv("REFRESH_TOKEN_DAYS"); days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			log.Fatal("REFRESH_TOKEN_DAYS environment variable is not a number")
+		}
+		refreshTokenTTL = time.Duration(n) * 24 * time.Hour
+	}
+	if days := os.Getenv("JWT_ROTATE_DAYS"); days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			log.Fatal("JWT_ROTATE_DAYS environment variable is not a number")
+		}
+		keyRotation = time.Duration(n) * 24 * time.Hour
+	}
+	if attempts := os.Getenv("LOGIN_MAX_ATTEMPTS"); attempts != "" {
+		n, err := strconv.Atoi(attempts)
+		if err != nil || n <= 0 {
+			log.Fatal("LOGIN_MAX_ATTEMPTS environment variable is not a number")
+		}
+		maxFailedLogins = n
+	}
+	if minutes := os.Getenv("LOGIN_LOCKOUT_MINUTES"); minutes != "" {
+		n, err := strconv.Atoi(minutes)
+		if err != nil || n <= 0 {
+			log.Fatal("LOGIN_LOCKOUT_MINUTES environment variable is not a number")
+		}
+		lockoutDuration = time.Duration(n) * time.Minute
+	}
+	if hours := os.Getenv("VERIFY_CODE_HOURS"); hours != "" {
+		n, err := strconv.Atoi(hours)
+		if err != nil || n <= 0 {
+			log.Fatal("VERIFY_CODE_HOURS environment variable is not a number")
+		}
+		verifyTTL = time.Duration(n) * time.Hour
+	}
+	if days := os.Getenv("UNVERIFIED_DAYS"); days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			log.Fatal("UNVERIFIED_DAYS environment variable is not a number")
+		}
+		unverifiedTTL = time.Duration(n) * 24 * time.Hour
+	}
 
 	init := initDB()
 	if init != nil {
@@ -693,44 +2238,82 @@ func main() {
 		return
 	}
 
-	jwtKey, err := generateRandomKey(32)
-	if err != nil {
-		log.Println("Error generating jwtKey:", err)
+	err3 := loadSigningKeys()
+	if err3 != nil {
+		log.Println("Error loading signing keys:", err3)
 		return
 	}
 
-	encodedKey := base64.StdEncoding.EncodeToString(jwtKey)
+	for name, variable := range map[string]string{"desktop": "PASSWORD_DESKTOP", "ios": "PASSWORD_IOS"} {
+		if secret := os.Getenv(variable); secret != "" {
+			err4 := seedClientKey(name, secret)
+			if err4 != nil {
+				log.Println("Error adding client key:", err4)
+				return
+			}
+		}
+	}
+
+	go cleanupUnverifiedUsers()
+
+	router := newRouter()
+	err2 := router.RunTLS(":3000", "fullchain.pem", "privkey.pem")
+	if err2 != nil {
+		log.Println("ListenAndServe: ", err2)
+		router.Run(":3000")
+	}
 
-	secretKey = []byte(encodedKey)
+}
 
+func newRouter() *gin.Engine {
 	router := gin.Default()
 
 	router.LoadHTMLGlob("templates/*")
 
 	router.Use(cors.Default())
 
-	router.POST("/login", loginHandler)
-	router.GET("/login", loginHandler)
-	router.POST("/register", registerHandler)
-	router.GET("/register", registerHandler)
+	router.POST("/login", rateLimit(ipLimiter), clientAuth(), loginHandler)
+	router.GET("/login", rateLimit(challengeLimiter), challengeHandler)
+	router.POST("/login/begin", rateLimit(ipLimiter), clientAuth(), srpBeginHandler)
+	router.GET("/login/begin", rateLimit(challengeLimiter), challengeHandler)
+	router.POST("/login/finish", rateLimit(ipLimiter), clientAuth(), srpFinishHandler)
+	router.GET("/login/finish", rateLimit(challengeLimiter), challengeHandler)
+	router.POST("/register", rateLimit(ipLimiter), clientAuth(), registerHandler)
+	router.GET("/register", rateLimit(challengeLimiter), challengeHandler)
 	router.GET("/verify", verifyHandler)
+	router.POST("/verify/resend", rateLimit(ipLimiter), clientAuth(), resendHandler)
+	router.GET("/verify/resend", rateLimit(challengeLimiter), challengeHandler)
+	router.POST("/password/forgot", rateLimit(ipLimiter), clientAuth(), forgotHandler)
+	router.GET("/password/forgot", rateLimit(challengeLimiter), challengeHandler)
+	router.POST("/password/reset", rateLimit(ipLimiter), clientAuth(), resetHandler)
+	router.GET("/password/reset", rateLimit(challengeLimiter), challengeHandler)
+	router.POST("/token/refresh", rateLimit(ipLimiter), refreshHandler)
 
 	auth := router.Group("/")
 	auth.Use(authMiddleware())
 	{
 		auth.GET("/user/settings", settingsHandler)
 		auth.POST("/otp/generate", otpGenerateHandler)
+		auth.POST("/otp/confirm", otpConfirmHandler)
 		auth.POST("/otp/remove", otpRemoveHandler)
+		auth.POST("/webauthn/register/begin", webauthnRegisterBeginHandler)
+		auth.POST("/webauthn/register/finish", webauthnRegisterFinishHandler)
+		auth.GET("/webauthn/credentials", webauthnCredentialsHandler)
+		auth.DELETE("/webauthn/credentials/:id", webauthnRemoveHandler)
+		auth.POST("/user/password/begin", passwordBeginHandler)
+		auth.POST("/user/confirm/begin", confirmBeginHandler)
 		auth.POST("/user/password", passwordHandler)
 		auth.POST("/user/save", saveHandler)
 		auth.GET("/user/sync", syncHandler)
+		auth.GET("/user/attachments", attachmentsHandler)
+		auth.POST("/user/attachments/:hash", attachmentSaveHandler)
+		auth.GET("/user/attachments/:hash", attachmentSyncHandler)
+		auth.DELETE("/user/attachments/:hash", attachmentRemoveHandler)
 		auth.POST("/user/terminate", terminateHandler)
+		auth.GET("/sessions", sessionsHandler)
+		auth.DELETE("/sessions/:id", revokeSessionHandler)
+		auth.POST("/logout", logoutHandler)
 	}
 
-	err2 := router.RunTLS(":3000", "fullchain.pem", "privkey.pem")
-	if err2 != nil {
-		log.Println("ListenAndServe: ", err2)
-		router.Run(":3000")
-	}
-
+	return router
 }
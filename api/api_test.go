@@ -0,0 +1,34 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestFailedLoginCountsConcurrentFailures(t *testing.T) {
+	s := startTestServer(t)
+	s.createUser(testUser, testPassword)
+	user, err := getUser(testUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := failedLogin(user); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	user, err = getUser(testUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.FailedLogins != 4 {
+		t.Errorf("failed logins after 4 concurrent failures = %d", user.FailedLogins)
+	}
+}
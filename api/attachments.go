@@ -0,0 +1,144 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var attachmentHash = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+func attachmentDir(username string) string {
+	return "files/" + username + ".attachments"
+}
+
+func attachmentsUsed(username string, except string) int64 {
+	entries, err := os.ReadDir(attachmentDir(username))
+	if err != nil {
+		return 0
+	}
+	var used int64
+	for _, entry := range entries {
+		if entry.Name() == except || !attachmentHash.MatchString(entry.Name()) {
+			continue
+		}
+		if info, err2 := entry.Info(); err2 == nil {
+			used += info.Size()
+		}
+	}
+	return used
+}
+
+func attachmentUser(c *gin.Context) (string, bool) {
+	username := c.GetString("username")
+	user, err := getUser(username)
+	if err != nil || !user.Verified {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to get user"})
+		return "", false
+	}
+	return username, true
+}
+
+func attachmentsHandler(c *gin.Context) {
+	username, ok := attachmentUser(c)
+	if !ok {
+		return
+	}
+	hashes := []string{}
+	entries, err := os.ReadDir(attachmentDir(username))
+	if err != nil && !os.IsNotExist(err) {
+		log.Println(err)
+		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to list attachments"})
+		return
+	}
+	for _, entry := range entries {
+		if attachmentHash.MatchString(entry.Name()) {
+			hashes = append(hashes, entry.Name())
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Attachments", "attachments": hashes})
+}
+
+func attachmentSaveHandler(c *gin.Context) {
+	content := c.GetHeader("Content-Type")
+	if !strings.Contains(content, "multipart/form-data; boundary=") {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		return
+	}
+	username, ok := attachmentUser(c)
+	if !ok {
+		return
+	}
+	hash := c.Param("hash")
+	if !attachmentHash.MatchString(hash) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid attachment"})
+		return
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Invalid request body"})
+		return
+	}
+	if attachmentsUsed(username, hash)+file.Size > maxAttachmentSize {
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"message": "Max file size exceeded"})
+		return
+	}
+	if err2 := os.MkdirAll(attachmentDir(username), 0700); err2 != nil {
+		log.Println(err2)
+		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to save attachment"})
+		return
+	}
+	path := filepath.Join(attachmentDir(username), hash)
+	if err3 := c.SaveUploadedFile(file, path+".tmp"); err3 != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Invalid request body"})
+		return
+	}
+	if err4 := os.Rename(path+".tmp", path); err4 != nil {
+		log.Println(err4)
+		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to save attachment"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Attachment saved"})
+}
+
+func attachmentSyncHandler(c *gin.Context) {
+	username, ok := attachmentUser(c)
+	if !ok {
+		return
+	}
+	hash := c.Param("hash")
+	if !attachmentHash.MatchString(hash) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid attachment"})
+		return
+	}
+	path := filepath.Join(attachmentDir(username), hash)
+	if _, err := os.Stat(path); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Attachment not found"})
+		return
+	}
+	c.File(path)
+}
+
+func attachmentRemoveHandler(c *gin.Context) {
+	username, ok := attachmentUser(c)
+	if !ok {
+		return
+	}
+	hash := c.Param("hash")
+	if !attachmentHash.MatchString(hash) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid attachment"})
+		return
+	}
+	err := os.Remove(filepath.Join(attachmentDir(username), hash))
+	if err != nil && !os.IsNotExist(err) {
+		log.Println(err)
+		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to remove attachment"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Attachment removed"})
+}
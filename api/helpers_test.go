@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+)
+
+const (
+	testUser         = "alice@example.com"
+	testPassword     = "Correct horse 1!"
+	testClientSecret = "test-client-secret"
+)
+
+type srpTestClient struct {
+	secret *big.Int
+	public *big.Int
+	m2     []byte
+}
+
+func testVerifier(password string) (string, string) {
+	raw := make([]byte, 16)
+	rand.Read(raw)
+	salt := hex.EncodeToString(raw)
+	v := new(big.Int).Exp(srpG, srpX(password, salt), srpN)
+	return salt, hex.EncodeToString(srpPad(v))
+}
+
+func newSrpTestClient() *srpTestClient {
+	raw := make([]byte, 32)
+	rand.Read(raw)
+	a := new(big.Int).SetBytes(raw)
+	return &srpTestClient{secret: a, public: new(big.Int).Exp(srpG, a, srpN)}
+}
+
+func (c *srpTestClient) A() string {
+	return hex.EncodeToString(srpPad(c.public))
+}
+
+func (c *srpTestClient) proof(username string, password string, salt string, server string) (string, error) {
+	B, err := parseSrpInt(server)
+	if err != nil {
+		return "", err
+	}
+	u := new(big.Int).SetBytes(srpHash(srpPad(c.public), srpPad(B)))
+	x := srpX(password, salt)
+	base := new(big.Int).Exp(srpG, x, srpN)
+	base.Mul(base, srpK)
+	base.Sub(B, base)
+	base.Mod(base, srpN)
+	exponent := new(big.Int).Mul(u, x)
+	exponent.Add(exponent, c.secret)
+	S := new(big.Int).Exp(base, exponent, srpN)
+	m1, m2 := srpProofs(strings.ToLower(username), salt, c.public, B, S)
+	c.m2 = m2
+	return hex.EncodeToString(m1), nil
+}
+
+func (c *srpTestClient) verify(proof string) bool {
+	return c.m2 != nil && hmac.Equal([]byte(hex.EncodeToString(c.m2)), []byte(strings.ToLower(proof)))
+}
+
+type reply struct {
+	status int
+	data   map[string]json.RawMessage
+}
+
+func (r reply) message() string {
+	var message string
+	json.Unmarshal(r.data["message"], &message)
+	return message
+}
+
+func (r reply) decode(key string, value interface{}) error {
+	raw, ok := r.data[key]
+	if !ok {
+		return fmt.Errorf("%q missing in %d %s", key, r.status, r.message())
+	}
+	return json.Unmarshal(raw, value)
+}
+
+type testServer struct {
+	t    *testing.T
+	base string
+}
+
+func startTestServer(t *testing.T) *testServer {
+	t.Helper()
+	log.SetOutput(io.Discard)
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+	savedFile, savedURL, savedParty, savedQueue := file, url, relyingParty, mailQueue
+	savedLimiters := []*rateLimiter{ipLimiter, accountLimiter, challengeLimiter}
+	t.Cleanup(func() {
+		file, url, relyingParty, mailQueue = savedFile, savedURL, savedParty, savedQueue
+		ipLimiter, accountLimiter, challengeLimiter = savedLimiters[0], savedLimiters[1], savedLimiters[2]
+		log.SetOutput(os.Stderr)
+		gin.DefaultWriter = os.Stdout
+	})
+
+	file = filepath.Join(t.TempDir(), "auth.db")
+	ipLimiter = newRateLimiter(1000, time.Minute)
+	accountLimiter = newRateLimiter(1000, time.Minute)
+	challengeLimiter = newRateLimiter(1000, time.Minute)
+	if err := initDB(); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadSigningKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if err := seedClientKey("desktop", testClientSecret); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadMailTemplates("mail"); err != nil {
+		t.Fatal(err)
+	}
+	mailQueue = newMailQueue(FileMailer{}, "noreply@example.com", 0, time.Millisecond)
+
+	server := httptest.NewUnstartedServer(newRouter())
+	url = "http://localhost:" + strconv.Itoa(server.Listener.Addr().(*net.TCPAddr).Port)
+	rp, err := newRelyingParty()
+	if err != nil {
+		t.Fatal(err)
+	}
+	relyingParty = rp
+	server.Start()
+	t.Cleanup(server.Close)
+	return &testServer{t: t, base: url}
+}
+
+func (s *testServer) do(method string, path string, body interface{}, token string) reply {
+	s.t.Helper()
+	var data []byte
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		data = encoded
+	}
+	var challenge string
+	if token == "" && method == http.MethodPost {
+		r := s.do(http.MethodGet, path, nil, "")
+		if err := r.decode("challenge", &challenge); err != nil {
+			s.t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, s.base+path, bytes.NewReader(data))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if challenge != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Auth-Client", "desktop")
+		req.Header.Set("X-Auth-Challenge", challenge)
+		req.Header.Set("X-Auth-Timestamp", timestamp)
+		req.Header.Set("X-Auth-Hash", clientProof(testClientSecret, method, req.URL.Path, timestamp, challenge, data))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	r := reply{status: resp.StatusCode, data: map[string]json.RawMessage{}}
+	json.Unmarshal(raw, &r.data)
+	return r
+}
+
+func (s *testServer) createUser(username string, password string) {
+	s.t.Helper()
+	salt, verifier := testVerifier(password)
+	r := s.do(http.MethodPost, "/register", map[string]string{"username": username, "salt": salt, "verifier": verifier}, "")
+	if r.status != http.StatusCreated {
+		s.t.Fatalf("register: %d %s", r.status, r.message())
+	}
+	if err := setVerified(username); err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+func (s *testServer) login(username string, password string, request map[string]interface{}) reply {
+	s.t.Helper()
+	client := newSrpTestClient()
+	r := s.do(http.MethodPost, "/login/begin", map[string]string{"username": username, "a": client.A()}, "")
+	if r.status != http.StatusOK || r.data["legacy"] != nil {
+		return r
+	}
+	var session, salt, server string
+	r.decode("session", &session)
+	r.decode("salt", &salt)
+	r.decode("b", &server)
+	proof, err := client.proof(username, password, salt, server)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	if request == nil {
+		request = map[string]interface{}{}
+	}
+	request["username"] = username
+	request["session"] = session
+	request["proof"] = proof
+	r = s.do(http.MethodPost, "/login/finish", request, "")
+	if r.status == http.StatusOK {
+		var m2 string
+		r.decode("proof", &m2)
+		if !client.verify(m2) {
+			s.t.Fatal("server proof does not match")
+		}
+	}
+	return r
+}
+
+func (s *testServer) unlock(username string) {
+	s.t.Helper()
+	user, err := getUser(username)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	if user.FailedLogins == 0 {
+		s.t.Errorf("failed login of %s was not counted", username)
+	}
+	if err := resetFailedLogins(user); err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+func (s *testServer) token(r reply) string {
+	s.t.Helper()
+	var token string
+	if r.status != http.StatusOK || r.decode("token", &token) != nil {
+		s.t.Fatalf("login: %d %s", r.status, r.message())
+	}
+	return token
+}
+
+func (s *testServer) registerKey(token string, name string, authenticator *softAuthenticator) []string {
+	s.t.Helper()
+	r := s.do(http.MethodPost, "/webauthn/register/begin", map[string]string{"name": name}, token)
+	var creation protocol.CredentialCreation
+	if err := r.decode("options", &creation); err != nil {
+		s.t.Fatal(err)
+	}
+	response, err := authenticator.Create(creation.Response)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	r = s.do(http.MethodPost, "/webauthn/register/finish", json.RawMessage(response), token)
+	if r.status != http.StatusOK {
+		s.t.Fatalf("register key: %d %s", r.status, r.message())
+	}
+	var codes []string
+	r.decode("recovery_codes", &codes)
+	return codes
+}
+
+func (s *testServer) assertion(r reply, authenticator *softAuthenticator) json.RawMessage {
+	s.t.Helper()
+	var assertion protocol.CredentialAssertion
+	if err := r.decode("webauthn", &assertion); err != nil {
+		s.t.Fatal(err)
+	}
+	response, err := authenticator.Get(assertion.Response)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return json.RawMessage(response)
+}
+
+func (s *testServer) confirm(token string, username string, password string) (map[string]string, *srpTestClient) {
+	s.t.Helper()
+	client := newSrpTestClient()
+	r := s.do(http.MethodPost, "/user/confirm/begin", map[string]string{"a": client.A()}, token)
+	var session, salt, server string
+	if err := r.decode("session", &session); err != nil {
+		s.t.Fatal(err)
+	}
+	r.decode("salt", &salt)
+	r.decode("b", &server)
+	proof, err := client.proof(username, password, salt, server)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return map[string]string{"session": session, "proof": proof}, client
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+type Mail struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+type Mailer interface {
+	Send(from string, mail Mail) error
+}
+
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	TLS      string
+	Timeout  time.Duration
+}
+
+type FileMailer struct {
+	Dir string
+}
+
+type mailJob struct {
+	mail    Mail
+	attempt int
+}
+
+type MailQueue struct {
+	mailer  Mailer
+	from    string
+	jobs    chan mailJob
+	retries int
+	backoff time.Duration
+}
+
+var smtpTimeout = 30 * time.Second
+
+var mailQueue *MailQueue
+var textTemplates *template.Template
+var htmlTemplates *htmltemplate.Template
+
+func (m SMTPMailer) Send(from string, mail Mail) error {
+	message, err := buildMessage(from, mail)
+	if err != nil {
+		return err
+	}
+	timeout := m.Timeout
+	if timeout == 0 {
+		timeout = smtpTimeout
+	}
+	address := net.JoinHostPort(m.Host, m.Port)
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	if m.TLS == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, &tls.Config{ServerName: m.Host})
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+	if err2 := conn.SetDeadline(time.Now().Add(timeout)); err2 != nil {
+		conn.Close()
+		return err2
+	}
+	client, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if m.TLS == "starttls" {
+		err3 := client.StartTLS(&tls.Config{ServerName: m.Host})
+		if err3 != nil {
+			return err3
+		}
+	}
+	if m.Username != "" {
+		err4 := client.Auth(smtp.PlainAuth("", m.Username, m.Password, m.Host))
+		if err4 != nil {
+			return err4
+		}
+	}
+	err5 := client.Mail(envelope(from))
+	if err5 != nil {
+		return err5
+	}
+	err6 := client.Rcpt(mail.To)
+	if err6 != nil {
+		return err6
+	}
+	writer, err7 := client.Data()
+	if err7 != nil {
+		return err7
+	}
+	_, err8 := writer.Write(message)
+	if err8 != nil {
+		return err8
+	}
+	err9 := writer.Close()
+	if err9 != nil {
+		return err9
+	}
+	return client.Quit()
+}
+
+func (m FileMailer) Send(from string, mail Mail) error {
+	message, err := buildMessage(from, mail)
+	if err != nil {
+		return err
+	}
+	if m.Dir == "" {
+		log.Printf("Mail to %s: %s\n%s", mail.To, mail.Subject, mail.Text)
+		return nil
+	}
+	err2 := os.MkdirAll(m.Dir, 0700)
+	if err2 != nil {
+		return err2
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405.000000000"), strings.ReplaceAll(mail.To, "/", "_"))
+	return os.WriteFile(filepath.Join(m.Dir, name), message, 0600)
+}
+
+func encodePart(writer *multipart.Writer, contentType string, body string) error {
+	part, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType + "; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	encoder := quotedprintable.NewWriter(part)
+	_, err2 := encoder.Write([]byte(body))
+	if err2 != nil {
+		return err2
+	}
+	return encoder.Close()
+}
+
+func envelope(from string) string {
+	address, err := netmail.ParseAddress(from)
+	if err != nil {
+		return from
+	}
+	return address.Address
+}
+
+func buildMessage(from string, mail Mail) ([]byte, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	err := encodePart(writer, "text/plain", mail.Text)
+	if err != nil {
+		return nil, err
+	}
+	if mail.HTML != "" {
+		err2 := encodePart(writer, "text/html", mail.HTML)
+		if err2 != nil {
+			return nil, err2
+		}
+	}
+	err3 := writer.Close()
+	if err3 != nil {
+		return nil, err3
+	}
+
+	domain := "localhost"
+	if sender := envelope(from); strings.Contains(sender, "@") {
+		domain = sender[strings.LastIndex(sender, "@")+1:]
+	}
+	var message bytes.Buffer
+	headers := [][2]string{
+		{"From", from},
+		{"To", mail.To},
+		{"Subject", mime.QEncoding.Encode("utf-8", mail.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", fmt.Sprintf("<%s@%s>", generateCode(), domain)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + writer.Boundary()},
+	}
+	for _, header := range headers {
+		fmt.Fprintf(&message, "%s: %s\r\n", header[0], header[1])
+	}
+	message.WriteString("\r\n")
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
+
+func loadMailTemplates(dir string) error {
+	var err error
+	textTemplates, err = template.ParseGlob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		return err
+	}
+	htmlTemplates, err = htmltemplate.ParseGlob(filepath.Join(dir, "*.html"))
+	return err
+}
+
+func renderMail(to string, subject string, name string, data map[string]interface{}) (Mail, error) {
+	var text bytes.Buffer
+	err := textTemplates.ExecuteTemplate(&text, name+".txt", data)
+	if err != nil {
+		return Mail{}, err
+	}
+	var html bytes.Buffer
+	err2 := htmlTemplates.ExecuteTemplate(&html, name+".html", data)
+	if err2 != nil {
+		return Mail{}, err2
+	}
+	return Mail{To: to, Subject: subject, Text: text.String(), HTML: html.String()}, nil
+}
+
+func newMailQueue(mailer Mailer, from string, retries int, backoff time.Duration) *MailQueue {
+	q := &MailQueue{mailer: mailer, from: from, jobs: make(chan mailJob, 100), retries: retries, backoff: backoff}
+	go q.run()
+	return q
+}
+
+func (q *MailQueue) Enqueue(mail Mail) {
+	q.enqueue(mailJob{mail: mail})
+}
+
+func (q *MailQueue) enqueue(job mailJob) bool {
+	select {
+	case q.jobs <- job:
+		return true
+	default:
+		log.Println("Mail queue full, dropping mail to", job.mail.To)
+		return false
+	}
+}
+
+func (q *MailQueue) run() {
+	for job := range q.jobs {
+		err := q.mailer.Send(q.from, job.mail)
+		if err == nil {
+			log.Println("Email sent to", job.mail.To)
+			continue
+		}
+		log.Println(err)
+		if job.attempt >= q.retries {
+			log.Println("Giving up on mail to", job.mail.To)
+			continue
+		}
+		job.attempt++
+		delay := q.backoff * time.Duration(1<<(job.attempt-1))
+		log.Printf("Retrying mail to %s in %s", job.mail.To, delay)
+		retry := job
+		time.AfterFunc(delay, func() {
+			q.enqueue(retry)
+		})
+	}
+}
+
+func sendMail(to string, subject string, name string, data map[string]interface{}) {
+	mail, err := renderMail(to, subject, name, data)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	mailQueue.Enqueue(mail)
+}
+
+func newMailer() (Mailer, error) {
+	switch driver := os.Getenv("MAIL_DRIVER"); driver {
+	case "", "smtp":
+		mailer := SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			TLS:      os.Getenv("SMTP_TLS"),
+		}
+		if mailer.Host == "" && mailer.Username == "" {
+			mailer.Username = os.Getenv("EMAIL")
+			mailer.Password = os.Getenv("EMAIL_PASSWORD")
+		}
+		if mailer.Host == "" {
+			mailer.Host = "smtp.gmail.com"
+		}
+		if mailer.Port == "" {
+			mailer.Port = "587"
+		}
+		if mailer.TLS == "" {
+			mailer.TLS = "starttls"
+		}
+		if mailer.TLS != "starttls" && mailer.TLS != "tls" && mailer.TLS != "none" {
+			return nil, fmt.Errorf("SMTP_TLS must be starttls, tls or none")
+		}
+		return mailer, nil
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "mail-out"
+		}
+		return FileMailer{Dir: dir}, nil
+	case "log":
+		return FileMailer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_DRIVER %s", driver)
+	}
+}
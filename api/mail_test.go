@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net"
+	netmail "net/mail"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type smtpMessage struct {
+	from string
+	to   []string
+	data string
+}
+
+type smtpServer struct {
+	host     string
+	port     string
+	messages chan smtpMessage
+	mu       sync.Mutex
+	reject   int
+}
+
+func startSMTPServer(t *testing.T, reject int) *smtpServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	s := &smtpServer{host: host, port: port, messages: make(chan smtpMessage, 10), reject: reject}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		io.WriteString(conn, line+"\r\n")
+	}
+	reply("220 localhost ESMTP test")
+	var message smtpMessage
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			message = smtpMessage{from: strings.Trim(strings.TrimSpace(line)[10:], "<>")}
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			s.mu.Lock()
+			rejected := s.reject > 0
+			if rejected {
+				s.reject--
+			}
+			s.mu.Unlock()
+			if rejected {
+				reply("451 Try again later")
+				continue
+			}
+			message.to = append(message.to, strings.Trim(strings.TrimSpace(line)[8:], "<>"))
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				dataLine, err2 := reader.ReadString('\n')
+				if err2 != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(dataLine, "."))
+			}
+			message.data = data.String()
+			s.messages <- message
+			reply("250 OK")
+		case command == "RSET", command == "NOOP":
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func (s *smtpServer) mailer() SMTPMailer {
+	return SMTPMailer{Host: s.host, Port: s.port, TLS: "none"}
+}
+
+func (s *smtpServer) wait(t *testing.T, timeout time.Duration) smtpMessage {
+	t.Helper()
+	select {
+	case message := <-s.messages:
+		return message
+	case <-time.After(timeout):
+		t.Fatal("no mail was delivered")
+	}
+	return smtpMessage{}
+}
+
+func quiet(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	server := startSMTPServer(t, 0)
+	mail := Mail{To: "alice@example.com", Subject: "Grüße", Text: "Hello Alice", HTML: "<p>Hello Alice</p>"}
+	if err := server.mailer().Send("Finalpass <noreply@example.com>", mail); err != nil {
+		t.Fatal(err)
+	}
+	received := server.wait(t, time.Second)
+	if received.from != "noreply@example.com" {
+		t.Errorf("envelope from = %q", received.from)
+	}
+	if len(received.to) != 1 || received.to[0] != "alice@example.com" {
+		t.Errorf("envelope to = %v", received.to)
+	}
+
+	message, err := netmail.ReadMessage(strings.NewReader(received.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject"))
+	if err != nil || subject != "Grüße" {
+		t.Errorf("Subject = %q %v", subject, err)
+	}
+	if !strings.HasSuffix(message.Header.Get("Message-ID"), "@example.com>") {
+		t.Errorf("Message-ID = %q", message.Header.Get("Message-ID"))
+	}
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q %v", mediaType, err)
+	}
+	parts := map[string]string{}
+	reader := multipart.NewReader(message.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(part)
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		parts[contentType] = string(body)
+	}
+	if parts["text/plain"] != mail.Text || parts["text/html"] != mail.HTML {
+		t.Errorf("parts = %v", parts)
+	}
+}
+
+func TestSMTPMailerRejected(t *testing.T) {
+	server := startSMTPServer(t, 1)
+	err := server.mailer().Send("noreply@example.com", Mail{To: "alice@example.com", Subject: "Hi", Text: "Hi"})
+	if err == nil || !strings.Contains(err.Error(), "451") {
+		t.Fatalf("Send = %v, want the 451 from the server", err)
+	}
+}
+
+func TestSMTPMailerServerStopsResponding(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	mailer := SMTPMailer{Host: host, Port: port, TLS: "none", Timeout: 200 * time.Millisecond}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- mailer.Send("noreply@example.com", Mail{To: "alice@example.com", Subject: "Hi", Text: "Hi"})
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Send to a silent server succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Send to a silent server did not time out")
+	}
+}
+
+func TestMailQueueRetries(t *testing.T) {
+	quiet(t)
+	server := startSMTPServer(t, 2)
+	queue := newMailQueue(server.mailer(), "noreply@example.com", 3, 10*time.Millisecond)
+	queue.Enqueue(Mail{To: "bob@example.com", Subject: "Retry", Text: "Hi Bob"})
+	received := server.wait(t, 5*time.Second)
+	if len(received.to) != 1 || received.to[0] != "bob@example.com" {
+		t.Errorf("envelope to = %v", received.to)
+	}
+}
+
+func TestMailQueueGivesUp(t *testing.T) {
+	quiet(t)
+	server := startSMTPServer(t, 3)
+	queue := newMailQueue(server.mailer(), "noreply@example.com", 2, 10*time.Millisecond)
+	queue.Enqueue(Mail{To: "carol@example.com", Subject: "Lost", Text: "Hi Carol"})
+	select {
+	case message := <-server.messages:
+		t.Fatalf("mail was delivered after the retries ran out: %v", message.to)
+	case <-time.After(500 * time.Millisecond):
+	}
+	server.mu.Lock()
+	left := server.reject
+	server.mu.Unlock()
+	if left != 0 {
+		t.Errorf("server saw %d attempts, want 3", 3-left)
+	}
+}
+
+func TestMailQueueRetryIntoFullQueue(t *testing.T) {
+	quiet(t)
+	queue := &MailQueue{jobs: make(chan mailJob, 1)}
+	queue.Enqueue(Mail{To: "dave@example.com"})
+	done := make(chan bool)
+	go func() {
+		done <- queue.enqueue(mailJob{mail: Mail{To: "erin@example.com"}, attempt: 1})
+	}()
+	select {
+	case queued := <-done:
+		if queued {
+			t.Fatal("retry was queued beyond the capacity")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("retry blocks on a full queue")
+	}
+	if job := <-queue.jobs; job.mail.To != "dave@example.com" {
+		t.Errorf("queued job = %v", job.mail.To)
+	}
+}
+
+func TestRenderMail(t *testing.T) {
+	if err := loadMailTemplates("mail"); err != nil {
+		t.Fatal(err)
+	}
+	mail, err := renderMail("alice@example.com", "Finalpass Password Reset", "reset", map[string]interface{}{"code": "c0ffee", "minutes": 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(mail.Text, "c0ffee") || !strings.Contains(mail.HTML, "c0ffee") {
+		t.Errorf("reset mail does not contain the code: %q %q", mail.Text, mail.HTML)
+	}
+}
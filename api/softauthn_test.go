@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+const (
+	flagUserPresent  = 0x01
+	flagAttestedData = 0x40
+)
+
+type softAuthenticator struct {
+	RPID         string
+	Origin       string
+	CredentialID []byte
+	PrivateKey   *ecdsa.PrivateKey
+	UserHandle   []byte
+	SignCount    uint32
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+func (a *softAuthenticator) clientDataJSON(ceremony string, challenge protocol.URLEncodedBase64) ([]byte, error) {
+	return json.Marshal(clientData{Type: ceremony, Challenge: challenge.String(), Origin: a.Origin})
+}
+
+func (a *softAuthenticator) authenticatorData(flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.RPID))
+	data := append([]byte{}, rpIDHash[:]...)
+	data = append(data, flags)
+	return binary.BigEndian.AppendUint32(data, a.SignCount)
+}
+
+func (a *softAuthenticator) Create(options protocol.PublicKeyCredentialCreationOptions) ([]byte, error) {
+	supported := false
+	for _, parameter := range options.Parameters {
+		if parameter.Algorithm == webauthncose.AlgES256 {
+			supported = true
+		}
+	}
+	if !supported {
+		return nil, errors.New("relying party does not accept ES256 keys")
+	}
+	if options.RelyingParty.ID != "" {
+		a.RPID = options.RelyingParty.ID
+	}
+	handle, ok := options.User.ID.(string)
+	if !ok {
+		return nil, errors.New("missing user handle")
+	}
+	userHandle, err := base64.RawURLEncoding.DecodeString(handle)
+	if err != nil {
+		return nil, fmt.Errorf("user handle: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	credentialID := make([]byte, 32)
+	if _, err := rand.Read(credentialID); err != nil {
+		return nil, err
+	}
+	a.CredentialID = credentialID
+	a.PrivateKey = key
+	a.UserHandle = userHandle
+	a.SignCount = 0
+
+	publicKey, err := cbor.Marshal(map[int]interface{}{
+		1:  2,
+		3:  int(webauthncose.AlgES256),
+		-1: 1,
+		-2: key.PublicKey.X.FillBytes(make([]byte, 32)),
+		-3: key.PublicKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	authData := a.authenticatorData(flagUserPresent | flagAttestedData)
+	authData = append(authData, make([]byte, 16)...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(credentialID)))
+	authData = append(authData, credentialID...)
+	authData = append(authData, publicKey...)
+	attestation, err := cbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authData,
+	})
+	if err != nil {
+		return nil, err
+	}
+	clientDataJSON, err := a.clientDataJSON("webauthn.create", options.Challenge)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{
+		"id":    base64.RawURLEncoding.EncodeToString(credentialID),
+		"rawId": protocol.URLEncodedBase64(credentialID),
+		"type":  "public-key",
+		"response": map[string]interface{}{
+			"clientDataJSON":    protocol.URLEncodedBase64(clientDataJSON),
+			"attestationObject": protocol.URLEncodedBase64(attestation),
+		},
+	})
+}
+
+func (a *softAuthenticator) Get(options protocol.PublicKeyCredentialRequestOptions) ([]byte, error) {
+	if options.RelyingPartyID != "" && options.RelyingPartyID != a.RPID {
+		return nil, fmt.Errorf("credential belongs to %s, not %s", a.RPID, options.RelyingPartyID)
+	}
+	allowed := len(options.AllowedCredentials) == 0
+	for _, credential := range options.AllowedCredentials {
+		if bytes.Equal(credential.CredentialID, a.CredentialID) {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return nil, errors.New("no matching credential")
+	}
+
+	a.SignCount++
+	authData := a.authenticatorData(flagUserPresent)
+	clientDataJSON, err := a.clientDataJSON("webauthn.get", options.Challenge)
+	if err != nil {
+		return nil, err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.PrivateKey, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{
+		"id":    base64.RawURLEncoding.EncodeToString(a.CredentialID),
+		"rawId": protocol.URLEncodedBase64(a.CredentialID),
+		"type":  "public-key",
+		"response": map[string]interface{}{
+			"clientDataJSON":    protocol.URLEncodedBase64(clientDataJSON),
+			"authenticatorData": protocol.URLEncodedBase64(authData),
+			"signature":         protocol.URLEncodedBase64(signature),
+			"userHandle":        protocol.URLEncodedBase64(a.UserHandle),
+		},
+	})
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/argon2"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type SrpSession struct {
+	gorm.Model
+	Sid       string `gorm:"unique;not null"`
+	Username  string `gorm:"index;not null"`
+	Purpose   string `gorm:"not null"`
+	Client    string `gorm:"not null"`
+	Server    string `gorm:"not null"`
+	Secret    string `gorm:"not null"`
+	ExpiresAt int64  `gorm:"not null"`
+}
+
+type ServerSecret struct {
+	gorm.Model
+	Name   string `gorm:"unique;not null"`
+	Secret string `gorm:"not null"`
+}
+
+type LoginFailure struct {
+	gorm.Model
+	Username     string `gorm:"unique;not null"`
+	FailedLogins int    `gorm:"default:0"`
+	LockedUntil  int64  `gorm:"default:0"`
+}
+
+var srpN, _ = new(big.Int).SetString("AC6BDB41324A9A9BF166DE5E1389582FAF72B6651987EE07FC3192943DB56050A37329CBB4A099ED8193E0757767A13DD52312AB4B03310DCD7F48A9DA04FD50E8083969EDB767B0CF6095179A163AB3661A05FBD5FAAAE82918A9962F0B93B855F97993EC975EEAA80D740ADBF4FF747359D041D5C33EA71D281E446B14773BCA97B43A23FB801676BD207A436C6481F1D2B9078717461A5B9D32E688F87748544523B524B0D57D5EA77A2775D2ECFA032CFBDBF52FB3786160279004E57AE6AF874E7303CE53299CCC041C7BC308D82A5698F3A8D0C38271AE35F8E9DBFBB694B5C803D89F7AE435DE236D525F54759B65E372FCD68EF20FA7111F9E4AFF73", 16)
+var srpG = big.NewInt(2)
+var srpK = new(big.Int).SetBytes(srpHash(srpN.Bytes(), srpPad(srpG)))
+var srpTTL time.Duration = 2 * time.Minute
+
+func srpHash(parts ...[]byte) []byte {
+	hash := sha256.New()
+	for _, part := range parts {
+		hash.Write(part)
+	}
+	return hash.Sum(nil)
+}
+
+func srpPad(n *big.Int) []byte {
+	return n.FillBytes(make([]byte, len(srpN.Bytes())))
+}
+
+func parseSrpInt(value string) (*big.Int, error) {
+	raw, err := hex.DecodeString(value)
+	if err != nil || len(raw) == 0 {
+		return nil, fmt.Errorf("invalid srp value")
+	}
+	n := new(big.Int).SetBytes(raw)
+	if n.Sign() <= 0 || n.Cmp(srpN) >= 0 {
+		return nil, fmt.Errorf("invalid srp value")
+	}
+	return n, nil
+}
+
+func validVerifier(salt string, verifier string) bool {
+	raw, err := hex.DecodeString(salt)
+	if err != nil || len(raw) < 16 || len(raw) > 64 {
+		return false
+	}
+	_, err2 := parseSrpInt(verifier)
+	return err2 == nil
+}
+
+func srpX(password string, salt string) *big.Int {
+	rawSalt, _ := hex.DecodeString(salt)
+	key := argon2.IDKey([]byte(password), rawSalt, 3, 64*1024, 4, 32)
+	return new(big.Int).SetBytes(srpHash(rawSalt, key))
+}
+
+func srpProofs(username string, salt string, A *big.Int, B *big.Int, S *big.Int) ([]byte, []byte) {
+	rawSalt, _ := hex.DecodeString(salt)
+	key := srpHash(srpPad(S))
+	hashN := srpHash(srpN.Bytes())
+	hashG := srpHash(srpPad(srpG))
+	for i := range hashN {
+		hashN[i] ^= hashG[i]
+	}
+	m1 := srpHash(hashN, srpHash([]byte(username)), rawSalt, srpPad(A), srpPad(B), key)
+	m2 := srpHash(srpPad(A), m1, key)
+	return m1, m2
+}
+
+func getServerSecret(name string) ([]byte, error) {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	secret, err2 := generateRandomKey(32)
+	if err2 != nil {
+		log.Println(err2)
+		return nil, err2
+	}
+	var stored ServerSecret
+	result := db.Where(ServerSecret{Name: name}).Attrs(ServerSecret{Secret: hex.EncodeToString(secret)}).FirstOrCreate(&stored)
+	if result.Error != nil {
+		log.Println(result.Error)
+		return nil, result.Error
+	}
+
+	return hex.DecodeString(stored.Secret)
+}
+
+func fakeSrpSession(username string) (string, string, error) {
+	secret, err := getServerSecret("srp")
+	if err != nil {
+		return "", "", err
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(username))
+	salt := hex.EncodeToString(mac.Sum(nil)[:16])
+
+	b, err2 := generateRandomKey(32)
+	if err2 != nil {
+		log.Println(err2)
+		return "", "", err2
+	}
+	B := new(big.Int).Exp(srpG, new(big.Int).SetBytes(b), srpN)
+	return salt, hex.EncodeToString(srpPad(B)), nil
+}
+
+func getLoginFailure(username string) (LoginFailure, error) {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return LoginFailure{}, err
+	}
+
+	var failure LoginFailure
+	result := db.Where("username = ?", username).First(&failure)
+	if result.Error != nil {
+		return LoginFailure{}, result.Error
+	}
+
+	return failure, nil
+}
+
+func failedUnknownLogin(username string) error {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&LoginFailure{Username: username})
+	if result.Error != nil {
+		log.Println(result.Error)
+		return result.Error
+	}
+	_, err2 := countFailedLogin(&LoginFailure{}, username)
+	return err2
+}
+
+func setCredentials(username string, password string, salt string, verifier string) error {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	result := db.Model(&User{}).Where("username = ?", username).Updates(map[string]interface{}{"password": password, "salt": salt, "verifier": verifier})
+	if result.Error != nil {
+		log.Println(result.Error)
+		return result.Error
+	}
+
+	return nil
+}
+
+func addSrpSession(user User, purpose string, client string) (SrpSession, error) {
+	A, err := parseSrpInt(client)
+	if err != nil {
+		return SrpSession{}, err
+	}
+	v, err2 := parseSrpInt(user.Verifier)
+	if err2 != nil {
+		return SrpSession{}, err2
+	}
+	secret, err3 := generateRandomKey(32)
+	if err3 != nil {
+		log.Println(err3)
+		return SrpSession{}, err3
+	}
+	b := new(big.Int).SetBytes(secret)
+	B := new(big.Int).Mul(srpK, v)
+	B.Add(B, new(big.Int).Exp(srpG, b, srpN))
+	B.Mod(B, srpN)
+
+	db, err4 := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err4 != nil {
+		log.Println(err4)
+		return SrpSession{}, err4
+	}
+	session := SrpSession{
+		Sid:       generateCode(),
+		Username:  user.Username,
+		Purpose:   purpose,
+		Client:    hex.EncodeToString(srpPad(A)),
+		Server:    hex.EncodeToString(srpPad(B)),
+		Secret:    hex.EncodeToString(secret),
+		ExpiresAt: time.Now().Add(srpTTL).Unix(),
+	}
+	result := db.Create(&session)
+	if result.Error != nil {
+		log.Println(result.Error)
+		return SrpSession{}, result.Error
+	}
+	result = db.Unscoped().Where("expires_at < ?", time.Now().Unix()).Delete(&SrpSession{})
+	if result.Error != nil {
+		log.Println(result.Error)
+	}
+
+	return session, nil
+}
+
+func findSrpSession(user User, purpose string, sid string, proof string) (SrpSession, string, error) {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return SrpSession{}, "", err
+	}
+
+	var session SrpSession
+	result := db.Where("sid = ? AND username = ? AND purpose = ? AND expires_at >= ?", sid, user.Username, purpose, time.Now().Unix()).First(&session)
+	if result.Error != nil {
+		log.Println(result.Error)
+		return SrpSession{}, "", result.Error
+	}
+
+	A, err2 := parseSrpInt(session.Client)
+	if err2 != nil {
+		return SrpSession{}, "", err2
+	}
+	B, err3 := parseSrpInt(session.Server)
+	if err3 != nil {
+		return SrpSession{}, "", err3
+	}
+	v, err4 := parseSrpInt(user.Verifier)
+	if err4 != nil {
+		return SrpSession{}, "", err4
+	}
+	secret, err5 := hex.DecodeString(session.Secret)
+	if err5 != nil {
+		return SrpSession{}, "", err5
+	}
+	u := new(big.Int).SetBytes(srpHash(srpPad(A), srpPad(B)))
+	if u.Sign() == 0 {
+		return SrpSession{}, "", fmt.Errorf("invalid srp session")
+	}
+	S := new(big.Int).Exp(v, u, srpN)
+	S.Mul(S, A)
+	S.Exp(S, new(big.Int).SetBytes(secret), srpN)
+	m1, m2 := srpProofs(user.Username, user.Salt, A, B, S)
+	if !hmac.Equal([]byte(hex.EncodeToString(m1)), []byte(strings.ToLower(proof))) {
+		if err6 := deleteSrpSession(session); err6 != nil {
+			log.Println(err6)
+		}
+		return SrpSession{}, "", fmt.Errorf("wrong srp proof for %s", user.Username)
+	}
+
+	return session, hex.EncodeToString(m2), nil
+}
+
+func deleteSrpSession(session SrpSession) error {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	result := db.Unscoped().Where("id = ?", session.ID).Delete(&SrpSession{})
+	if result.Error != nil {
+		log.Println(result.Error)
+		return result.Error
+	}
+	if result.RowsAffected != 1 {
+		return fmt.Errorf("srp session already used")
+	}
+
+	return nil
+}
+
+func useSrpSession(user User, purpose string, sid string, proof string) (string, error) {
+	session, m2, err := findSrpSession(user, purpose, sid, proof)
+	if err != nil {
+		return "", err
+	}
+	if err2 := deleteSrpSession(session); err2 != nil {
+		return "", err2
+	}
+	return m2, nil
+}
+
+func confirmPassword(user User, sid string, proof string) (string, bool) {
+	if sid == "" || proof == "" {
+		return "", false
+	}
+	m2, err := useSrpSession(user, "confirm", sid, proof)
+	if err != nil {
+		log.Println(err)
+		return "", false
+	}
+	return m2, true
+}
+
+func srpBeginHandler(c *gin.Context) {
+	var data struct {
+		Username string `json:"username"`
+		A        string `json:"a"`
+	}
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		return
+	}
+	data.Username = strings.ToLower(data.Username)
+	if !validateEmail(data.Username) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		return
+	}
+	if _, err := parseSrpInt(data.A); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		return
+	}
+	user, err2 := getUser(data.Username)
+	if err2 == nil && user.Verified && user.Verifier == "" {
+		if wait := time.Until(time.Unix(user.LockedUntil, 0)); wait > 0 {
+			tooManyRequests(c, wait)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Password required", "legacy": true})
+		return
+	}
+	if err2 != nil || !user.Verified {
+		failure, _ := getLoginFailure(data.Username)
+		if wait := time.Until(time.Unix(failure.LockedUntil, 0)); wait > 0 {
+			tooManyRequests(c, wait)
+			return
+		}
+		salt, server, err3 := fakeSrpSession(data.Username)
+		if err3 != nil {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Invalid credentials"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Send the proof to /login/finish", "session": generateCode(), "salt": salt, "b": server})
+		return
+	}
+	if wait := time.Until(time.Unix(user.LockedUntil, 0)); wait > 0 {
+		tooManyRequests(c, wait)
+		return
+	}
+	session, err4 := addSrpSession(user, "login", data.A)
+	if err4 != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Send the proof to /login/finish", "session": session.Sid, "salt": user.Salt, "b": session.Server})
+}
+
+func srpFinishHandler(c *gin.Context) {
+	var data loginRequest
+	if err := c.ShouldBindJSON(&data); err != nil || data.Session == "" || data.Proof == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		return
+	}
+	data.Username = strings.ToLower(data.Username)
+	if !validateEmail(data.Username) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		return
+	}
+	if ok, wait := accountLimiter.allow(data.Username); !ok {
+		tooManyRequests(c, wait)
+		return
+	}
+	user, err := getUser(data.Username)
+	if err != nil || !user.Verified || user.Verifier == "" {
+		failure, _ := getLoginFailure(data.Username)
+		if wait := time.Until(time.Unix(failure.LockedUntil, 0)); wait > 0 {
+			tooManyRequests(c, wait)
+			return
+		}
+		if err2 := failedUnknownLogin(data.Username); err2 != nil {
+			log.Println(err2)
+		}
+		c.JSON(http.StatusNotFound, gin.H{"message": "Invalid credentials"})
+		return
+	}
+	if wait := time.Until(time.Unix(user.LockedUntil, 0)); wait > 0 {
+		tooManyRequests(c, wait)
+		return
+	}
+	session, proof, err3 := findSrpSession(user, "login", data.Session, data.Proof)
+	if err3 != nil {
+		log.Println(err3)
+		err4 := failedLogin(user)
+		if err4 != nil {
+			log.Println(err4)
+		}
+		c.JSON(http.StatusNotFound, gin.H{"message": "Invalid credentials"})
+		return
+	}
+	keys, ok := askSecondFactor(c, user, data)
+	if !ok {
+		return
+	}
+	if !secondFactor(user, keys, data) {
+		if err5 := deleteSrpSession(session); err5 != nil {
+			log.Println(err5)
+		}
+		err6 := failedLogin(user)
+		if err6 != nil {
+			log.Println(err6)
+		}
+		c.JSON(http.StatusNotFound, gin.H{"message": "Invalid credentials"})
+		return
+	}
+	if err7 := deleteSrpSession(session); err7 != nil {
+		log.Println(err7)
+		c.JSON(http.StatusNotFound, gin.H{"message": "Invalid credentials"})
+		return
+	}
+	startSession(c, user, data.Device, gin.H{"proof": proof})
+}
+
+func srpUserBegin(c *gin.Context, purpose string, failure string, next string) {
+	username := c.GetString("username")
+	user, err := getUser(username)
+	if err != nil || !user.Verified {
+		c.JSON(http.StatusNotFound, gin.H{"message": failure})
+		return
+	}
+	var data struct {
+		A string `json:"a"`
+	}
+	if err2 := c.ShouldBindJSON(&data); err2 != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		return
+	}
+	if user.Verifier == "" {
+		c.JSON(http.StatusOK, gin.H{"message": "Password required", "legacy": true})
+		return
+	}
+	session, err3 := addSrpSession(user, purpose, data.A)
+	if err3 != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Send the proof to " + next, "session": session.Sid, "salt": user.Salt, "b": session.Server})
+}
+
+func passwordBeginHandler(c *gin.Context) {
+	srpUserBegin(c, "password", "Failed to change password", "/user/password")
+}
+
+func confirmBeginHandler(c *gin.Context) {
+	srpUserBegin(c, "confirm", "Invalid credentials", "the request it confirms")
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestRegisterNeedsVerifier(t *testing.T) {
+	s := startTestServer(t)
+	r := s.do(http.MethodPost, "/register", map[string]string{"username": testUser, "password": testPassword}, "")
+	if r.status != http.StatusBadRequest {
+		t.Errorf("register with a password = %d %s", r.status, r.message())
+	}
+	if _, err := getUser(testUser); err == nil {
+		t.Error("register with a password created the account")
+	}
+}
+
+func TestLoginBeginHidesAccounts(t *testing.T) {
+	s, authenticator, _, _ := newKeyUser(t)
+	salt, verifier := testVerifier(testPassword)
+	if err := addUser(User{Username: "unverified@example.com", Salt: salt, Verifier: verifier}); err != nil {
+		t.Fatal(err)
+	}
+
+	begin := func(username string) (string, string) {
+		t.Helper()
+		r := s.do(http.MethodPost, "/login/begin", map[string]string{"username": username, "a": newSrpTestClient().A()}, "")
+		var session, salt, server string
+		if r.status != http.StatusOK || r.decode("session", &session) != nil || r.decode("salt", &salt) != nil || r.decode("b", &server) != nil {
+			t.Fatalf("login/begin for %s = %d %v", username, r.status, r.data)
+		}
+		if r.data["legacy"] != nil {
+			t.Errorf("login/begin for %s reports a legacy account", username)
+		}
+		if len(salt) != 32 || len(server) != 512 || session == "" {
+			t.Errorf("login/begin for %s: salt %q, b of %d digits", username, salt, len(server))
+		}
+		return salt, server
+	}
+	begin(testUser)
+	for _, username := range []string{"nobody@example.com", "unverified@example.com"} {
+		salt, server := begin(username)
+		again, other := begin(username)
+		if salt != again {
+			t.Errorf("fake salt for %s changes between requests", username)
+		}
+		if server == other {
+			t.Errorf("fake b for %s repeats", username)
+		}
+	}
+	nobody, _ := begin("nobody@example.com")
+	unverified, _ := begin("unverified@example.com")
+	if nobody == unverified {
+		t.Error("fake salts of different accounts are equal")
+	}
+
+	sessions := func() int64 {
+		t.Helper()
+		db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var count int64
+		db.Model(&WebauthnSession{}).Count(&count)
+		return count
+	}
+	for _, username := range []string{testUser, "nobody@example.com", "unverified@example.com"} {
+		r := s.login(username, "Wrong horse 1!", nil)
+		if r.status != http.StatusNotFound || r.data["totp"] != nil || r.data["webauthn"] != nil {
+			t.Errorf("login/finish with a wrong proof for %s = %d %v", username, r.status, r.data)
+		}
+		r = s.do(http.MethodPost, "/login/begin", map[string]string{"username": username, "a": newSrpTestClient().A()}, "")
+		if r.status != http.StatusTooManyRequests {
+			t.Errorf("login/begin for %s after a failed login = %d, want 429", username, r.status)
+		}
+		r = s.login(username, testPassword, nil)
+		if r.status != http.StatusTooManyRequests {
+			t.Errorf("login/finish for %s after a failed login = %d, want 429", username, r.status)
+		}
+	}
+	if count := sessions(); count != 0 {
+		t.Errorf("failed logins stored %d webauthn sessions", count)
+	}
+	s.unlock(testUser)
+	r := s.login(testUser, testPassword, nil)
+	if r.status != http.StatusMethodNotAllowed || r.data["webauthn"] == nil {
+		t.Fatalf("login with the right password = %d %s", r.status, r.message())
+	}
+	s.assertion(r, authenticator)
+}
+
+func TestLoginLegacyAccount(t *testing.T) {
+	s := startTestServer(t)
+	s.createUser(testUser, testPassword)
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := addUser(User{Username: "legacy@example.com", Password: string(hash), Verified: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := s.login("legacy@example.com", testPassword, nil)
+	if r.status != http.StatusOK || r.data["legacy"] == nil {
+		t.Fatalf("login/begin for a legacy account = %d %v", r.status, r.data)
+	}
+	salt, verifier := testVerifier(testPassword)
+	r = s.do(http.MethodPost, "/login", map[string]string{"username": "legacy@example.com", "password": testPassword, "salt": salt, "verifier": verifier}, "")
+	s.token(r)
+	s.token(s.login("legacy@example.com", testPassword, nil))
+
+	r = s.do(http.MethodPost, "/login", map[string]string{"username": testUser, "password": testPassword}, "")
+	if r.status != http.StatusNotFound {
+		t.Errorf("password login for an SRP account = %d %s", r.status, r.message())
+	}
+}
+
+func TestRemoveKeyWithPasswordProof(t *testing.T) {
+	s, _, token, _ := newKeyUser(t)
+	path := keyPath(t, s, token)
+
+	r := s.do(http.MethodDelete, path, map[string]string{"password": testPassword}, token)
+	if r.status != http.StatusMethodNotAllowed {
+		t.Errorf("removing the key with the plaintext password = %d %s", r.status, r.message())
+	}
+	request, _ := s.confirm(token, testUser, "Wrong horse 1!")
+	r = s.do(http.MethodDelete, path, request, token)
+	if r.status != http.StatusMethodNotAllowed {
+		t.Errorf("removing the key with a wrong password proof = %d %s", r.status, r.message())
+	}
+
+	request, client := s.confirm(token, testUser, testPassword)
+	r = s.do(http.MethodDelete, path, request, token)
+	if r.status != http.StatusOK {
+		t.Fatalf("removing the key with a password proof = %d %s", r.status, r.message())
+	}
+	var proof string
+	if r.decode("proof", &proof) != nil || !client.verify(proof) {
+		t.Error("server proof does not match")
+	}
+}
@@ -0,0 +1,543 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type WebauthnCredential struct {
+	gorm.Model
+	Username     string `gorm:"index;not null"`
+	Name         string `gorm:"not null"`
+	CredentialID string `gorm:"unique;not null"`
+	Data         string `gorm:"not null"`
+	LastUsed     time.Time
+}
+
+type WebauthnSession struct {
+	gorm.Model
+	Challenge string `gorm:"unique;not null"`
+	Username  string `gorm:"index;not null"`
+	Purpose   string `gorm:"not null"`
+	Name      string
+	Data      string `gorm:"not null"`
+	ExpiresAt int64  `gorm:"not null"`
+}
+
+type webauthnUser struct {
+	user        User
+	credentials []webauthn.Credential
+}
+
+var relyingParty *webauthn.WebAuthn
+var webauthnTTL time.Duration = 5 * time.Minute
+
+func (u webauthnUser) WebAuthnID() []byte {
+	return []byte(u.user.WebauthnID)
+}
+
+func (u webauthnUser) WebAuthnName() string {
+	return u.user.Username
+}
+
+func (u webauthnUser) WebAuthnDisplayName() string {
+	return u.user.Username
+}
+
+func (u webauthnUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (u webauthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+func newRelyingParty() (*webauthn.WebAuthn, error) {
+	base, err := neturl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+	id := os.Getenv("WEBAUTHN_RP_ID")
+	if id == "" {
+		id = base.Hostname()
+	}
+	origins := []string{base.Scheme + "://" + base.Host}
+	if list := os.Getenv("WEBAUTHN_RP_ORIGINS"); list != "" {
+		origins = strings.Split(list, ",")
+	}
+	return webauthn.New(&webauthn.Config{
+		RPID:          id,
+		RPDisplayName: "Finalpass",
+		RPOrigins:     origins,
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: webauthnTTL, TimeoutUVD: webauthnTTL},
+			Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: webauthnTTL, TimeoutUVD: webauthnTTL},
+		},
+	})
+}
+
+func setWebauthnID(username string) (string, error) {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return "", err
+	}
+
+	value, err2 := generateRandomKey(32)
+	if err2 != nil {
+		log.Println(err2)
+		return "", err2
+	}
+	id := hex.EncodeToString(value)
+	result := db.Model(&User{}).Where("username = ? AND (webauthn_id IS NULL OR webauthn_id = ?)", username, "").Update("webauthn_id", id)
+	if result.Error != nil {
+		log.Println(result.Error)
+		return "", result.Error
+	}
+	if result.RowsAffected != 1 {
+		user, err3 := getUser(username)
+		if err3 != nil {
+			return "", err3
+		}
+		if user.WebauthnID == "" {
+			return "", gorm.ErrRecordNotFound
+		}
+		return user.WebauthnID, nil
+	}
+
+	return id, nil
+}
+
+func getWebauthnCredentials(username string) ([]WebauthnCredential, error) {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	var credentials []WebauthnCredential
+	result := db.Where("username = ?", username).Order("created_at").Find(&credentials)
+	if result.Error != nil {
+		log.Println(result.Error)
+		return nil, result.Error
+	}
+
+	return credentials, nil
+}
+
+func addWebauthnCredential(username string, name string, credential *webauthn.Credential) (WebauthnCredential, error) {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return WebauthnCredential{}, err
+	}
+
+	data, err2 := json.Marshal(credential)
+	if err2 != nil {
+		log.Println(err2)
+		return WebauthnCredential{}, err2
+	}
+	stored := WebauthnCredential{
+		Username:     username,
+		Name:         name,
+		CredentialID: base64.RawURLEncoding.EncodeToString(credential.ID),
+		Data:         string(data),
+	}
+	result := db.Create(&stored)
+	if result.Error != nil {
+		log.Println(result.Error)
+		return WebauthnCredential{}, result.Error
+	}
+
+	return stored, nil
+}
+
+func updateWebauthnCredential(credential *webauthn.Credential) error {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	data, err2 := json.Marshal(credential)
+	if err2 != nil {
+		log.Println(err2)
+		return err2
+	}
+	result := db.Model(&WebauthnCredential{}).Where("credential_id = ?", base64.RawURLEncoding.EncodeToString(credential.ID)).Updates(map[string]interface{}{"data": string(data), "last_used": time.Now()})
+	if result.Error != nil {
+		log.Println(result.Error)
+		return result.Error
+	}
+
+	return nil
+}
+
+func deleteWebauthnCredential(username string, id uint) error {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	result := db.Unscoped().Where("username = ? AND id = ?", username, id).Delete(&WebauthnCredential{})
+	if result.Error != nil {
+		log.Println(result.Error)
+		return result.Error
+	}
+	if result.RowsAffected != 1 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func deleteWebauthnCredentials(username string) error {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	result := db.Unscoped().Where("username = ?", username).Delete(&WebauthnCredential{})
+	if result.Error != nil {
+		log.Println(result.Error)
+		return result.Error
+	}
+	result = db.Unscoped().Where("username = ?", username).Delete(&WebauthnSession{})
+	if result.Error != nil {
+		log.Println(result.Error)
+		return result.Error
+	}
+
+	return nil
+}
+
+func addWebauthnSession(username string, purpose string, name string, session *webauthn.SessionData) error {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	data, err2 := json.Marshal(session)
+	if err2 != nil {
+		log.Println(err2)
+		return err2
+	}
+	result := db.Create(&WebauthnSession{
+		Challenge: session.Challenge,
+		Username:  username,
+		Purpose:   purpose,
+		Name:      name,
+		Data:      string(data),
+		ExpiresAt: time.Now().Add(webauthnTTL).Unix(),
+	})
+	if result.Error != nil {
+		log.Println(result.Error)
+		return result.Error
+	}
+	result = db.Unscoped().Where("expires_at < ?", time.Now().Unix()).Delete(&WebauthnSession{})
+	if result.Error != nil {
+		log.Println(result.Error)
+	}
+
+	return nil
+}
+
+func useWebauthnSession(username string, purpose string, challenge string) (WebauthnSession, webauthn.SessionData, error) {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return WebauthnSession{}, webauthn.SessionData{}, err
+	}
+
+	var stored WebauthnSession
+	result := db.Where("challenge = ? AND username = ? AND purpose = ? AND expires_at >= ?", challenge, username, purpose, time.Now().Unix()).First(&stored)
+	if result.Error != nil {
+		log.Println(result.Error)
+		return WebauthnSession{}, webauthn.SessionData{}, result.Error
+	}
+	result = db.Unscoped().Where("id = ?", stored.ID).Delete(&WebauthnSession{})
+	if result.Error != nil {
+		log.Println(result.Error)
+		return WebauthnSession{}, webauthn.SessionData{}, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return WebauthnSession{}, webauthn.SessionData{}, fmt.Errorf("webauthn challenge already used")
+	}
+	var session webauthn.SessionData
+	err2 := json.Unmarshal([]byte(stored.Data), &session)
+	if err2 != nil {
+		log.Println(err2)
+		return WebauthnSession{}, webauthn.SessionData{}, err2
+	}
+
+	return stored, session, nil
+}
+
+func newWebauthnUser(user User, stored []WebauthnCredential) (webauthnUser, error) {
+	u := webauthnUser{user: user}
+	for _, credential := range stored {
+		var decoded webauthn.Credential
+		err := json.Unmarshal([]byte(credential.Data), &decoded)
+		if err != nil {
+			log.Println(err)
+			return webauthnUser{}, err
+		}
+		u.credentials = append(u.credentials, decoded)
+	}
+	return u, nil
+}
+
+func beginWebauthnLogin(user User, stored []WebauthnCredential) (*protocol.CredentialAssertion, error) {
+	u, err := newWebauthnUser(user, stored)
+	if err != nil {
+		return nil, err
+	}
+	assertion, session, err2 := relyingParty.BeginLogin(u)
+	if err2 != nil {
+		log.Println(err2)
+		return nil, err2
+	}
+	err3 := addWebauthnSession(user.Username, "login", "", session)
+	if err3 != nil {
+		return nil, err3
+	}
+	return assertion, nil
+}
+
+func finishWebauthnLogin(user User, stored []WebauthnCredential, response []byte) error {
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(response))
+	if err != nil {
+		return err
+	}
+	_, session, err2 := useWebauthnSession(user.Username, "login", parsed.Response.CollectedClientData.Challenge)
+	if err2 != nil {
+		return err2
+	}
+	u, err3 := newWebauthnUser(user, stored)
+	if err3 != nil {
+		return err3
+	}
+	credential, err4 := relyingParty.ValidateLogin(u, session, parsed)
+	if err4 != nil {
+		return err4
+	}
+	if credential.Authenticator.CloneWarning {
+		return fmt.Errorf("signature counter of security key for %s did not increase", user.Username)
+	}
+	return updateWebauthnCredential(credential)
+}
+
+func webauthnRegisterBeginHandler(c *gin.Context) {
+	username := c.GetString("username")
+	user, err3 := getUser(username)
+	if err3 != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to add security key"})
+		return
+	}
+	if !user.Verified {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to add security key"})
+		return
+	}
+	var data struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		return
+	}
+	data.Name = strings.TrimSpace(data.Name)
+	if data.Name == "" || len(data.Name) > 64 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		return
+	}
+	stored, err := getWebauthnCredentials(username)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to add security key"})
+		return
+	}
+	for _, credential := range stored {
+		if credential.Name == data.Name {
+			c.JSON(http.StatusConflict, gin.H{"message": "A security key with this name already exists"})
+			return
+		}
+	}
+	if user.WebauthnID == "" {
+		id, err2 := setWebauthnID(username)
+		if err2 != nil {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Failed to add security key"})
+			return
+		}
+		user.WebauthnID = id
+	}
+	u, err4 := newWebauthnUser(user, stored)
+	if err4 != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to add security key"})
+		return
+	}
+	var exclusions []protocol.CredentialDescriptor
+	for _, credential := range u.credentials {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+	creation, session, err5 := relyingParty.BeginRegistration(u, webauthn.WithExclusions(exclusions))
+	if err5 != nil {
+		log.Println(err5)
+		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to add security key"})
+		return
+	}
+	err6 := addWebauthnSession(username, "register", data.Name, session)
+	if err6 != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to add security key"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Confirm with your security key", "options": creation})
+}
+
+func webauthnRegisterFinishHandler(c *gin.Context) {
+	username := c.GetString("username")
+	user, err3 := getUser(username)
+	if err3 != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to add security key"})
+		return
+	}
+	if !user.Verified || user.WebauthnID == "" {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to add security key"})
+		return
+	}
+	parsed, err := protocol.ParseCredentialCreationResponseBody(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		return
+	}
+	pending, session, err2 := useWebauthnSession(username, "register", parsed.Response.CollectedClientData.Challenge)
+	if err2 != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Invalid or expired registration"})
+		return
+	}
+	stored, err4 := getWebauthnCredentials(username)
+	if err4 != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to add security key"})
+		return
+	}
+	u, err5 := newWebauthnUser(user, stored)
+	if err5 != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to add security key"})
+		return
+	}
+	credential, err6 := relyingParty.CreateCredential(u, session, parsed)
+	if err6 != nil {
+		log.Println(err6)
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"message": "Invalid security key response"})
+		return
+	}
+	added, err7 := addWebauthnCredential(username, pending.Name, credential)
+	if err7 != nil {
+		c.JSON(http.StatusConflict, gin.H{"message": "Security key already registered"})
+		return
+	}
+	response := gin.H{"message": "Security key added", "id": added.ID}
+	codes, err8 := countRecoveryCodes(username)
+	if err8 == nil && codes == 0 {
+		recovery, err9 := setRecoveryCodes(username)
+		if err9 != nil {
+			log.Println(err9)
+		} else {
+			response["recovery_codes"] = recovery
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func webauthnCredentialsHandler(c *gin.Context) {
+	username := c.GetString("username")
+	stored, err := getWebauthnCredentials(username)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to get security keys"})
+		return
+	}
+	list := []gin.H{}
+	for _, credential := range stored {
+		lastUsed := ""
+		if !credential.LastUsed.IsZero() {
+			lastUsed = credential.LastUsed.Format("2006-01-02 15:04:05")
+		}
+		list = append(list, gin.H{
+			"id":         credential.ID,
+			"name":       credential.Name,
+			"created_at": credential.CreatedAt.Format("2006-01-02 15:04:05"),
+			"last_used":  lastUsed,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Security keys found", "credentials": list})
+}
+
+func webauthnRemoveHandler(c *gin.Context) {
+	username := c.GetString("username")
+	user, err3 := getUser(username)
+	if err3 != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Failed to remove security key"})
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Security key not found"})
+		return
+	}
+	var data struct {
+		Code    string `json:"code"`
+		Session string `json:"session"`
+		Proof   string `json:"proof"`
+	}
+	if err2 := c.ShouldBindJSON(&data); err2 != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request body"})
+		return
+	}
+	if ok, wait := accountLimiter.allow("webauthn:" + username); !ok {
+		tooManyRequests(c, wait)
+		return
+	}
+	response := gin.H{"message": "Security key removed"}
+	verified := data.Code != "" && verifySecondFactor(user, data.Code)
+	if !verified {
+		proof, ok := confirmPassword(user, data.Session, data.Proof)
+		if ok {
+			response["proof"] = proof
+		}
+		verified = ok
+	}
+	if !verified {
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"message": "Invalid credentials"})
+		return
+	}
+	err4 := deleteWebauthnCredential(username, uint(id))
+	if err4 != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Security key not found"})
+		return
+	}
+	stored, err5 := getWebauthnCredentials(username)
+	if err5 == nil && len(stored) == 0 && user.Totp == "" {
+		err6 := deleteRecoveryCodes(username)
+		if err6 != nil {
+			log.Println(err6)
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
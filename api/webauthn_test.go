@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+func newKeyUser(t *testing.T) (*testServer, *softAuthenticator, string, []string) {
+	t.Helper()
+	s := startTestServer(t)
+	s.createUser(testUser, testPassword)
+	token := s.token(s.login(testUser, testPassword, nil))
+	authenticator := &softAuthenticator{Origin: s.base}
+	codes := s.registerKey(token, "Test key", authenticator)
+	if len(codes) != 10 {
+		t.Fatalf("registering the first key returned %d recovery codes", len(codes))
+	}
+	return s, authenticator, token, codes
+}
+
+func keyPath(t *testing.T, s *testServer, token string) string {
+	t.Helper()
+	r := s.do(http.MethodGet, "/webauthn/credentials", nil, token)
+	var keys []struct {
+		ID   uint   `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := r.decode("credentials", &keys); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].Name != "Test key" {
+		t.Fatalf("credentials = %+v", keys)
+	}
+	return "/webauthn/credentials/" + strconv.FormatUint(uint64(keys[0].ID), 10)
+}
+
+func emptySecretCode(t *testing.T) string {
+	t.Helper()
+	code, err := totp.GenerateCodeCustom("", time.Now().UTC(), totp.ValidateOpts{Period: 30, Digits: 6, Algorithm: otp.AlgorithmSHA512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestWebauthnLogin(t *testing.T) {
+	s, authenticator, _, _ := newKeyUser(t)
+
+	r := s.login(testUser, testPassword, nil)
+	if r.status != http.StatusMethodNotAllowed || r.data["webauthn"] == nil {
+		t.Fatalf("login without a second factor = %d %s", r.status, r.message())
+	}
+	response := s.assertion(r, authenticator)
+	s.token(s.login(testUser, testPassword, map[string]interface{}{"webauthn": response}))
+
+	r = s.login(testUser, testPassword, map[string]interface{}{"webauthn": response})
+	if r.status != http.StatusNotFound {
+		t.Errorf("replayed assertion = %d %s", r.status, r.message())
+	}
+	s.unlock(testUser)
+
+	other := *authenticator
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.PrivateKey = key
+	r = s.login(testUser, testPassword, nil)
+	r = s.login(testUser, testPassword, map[string]interface{}{"webauthn": s.assertion(r, &other)})
+	if r.status != http.StatusNotFound {
+		t.Errorf("assertion signed by another key = %d %s", r.status, r.message())
+	}
+	s.unlock(testUser)
+
+	r = s.login(testUser, "Wrong horse 1!", nil)
+	if r.status != http.StatusNotFound || r.data["webauthn"] != nil {
+		t.Errorf("wrong password without a second factor = %d %v", r.status, r.data)
+	}
+	s.unlock(testUser)
+}
+
+func TestWebauthnLoginKeepsSrpSession(t *testing.T) {
+	s, authenticator, _, _ := newKeyUser(t)
+
+	client := newSrpTestClient()
+	r := s.do(http.MethodPost, "/login/begin", map[string]string{"username": testUser, "a": client.A()}, "")
+	var session, salt, server string
+	r.decode("session", &session)
+	r.decode("salt", &salt)
+	r.decode("b", &server)
+	proof, err := client.proof(testUser, testPassword, salt, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := map[string]interface{}{"username": testUser, "session": session, "proof": proof}
+	r = s.do(http.MethodPost, "/login/finish", request, "")
+	if r.status != http.StatusMethodNotAllowed {
+		t.Fatalf("login without a second factor = %d %s", r.status, r.message())
+	}
+	request["webauthn"] = s.assertion(r, authenticator)
+	r = s.do(http.MethodPost, "/login/finish", request, "")
+	var m2 string
+	if r.decode("proof", &m2) != nil || !client.verify(m2) {
+		t.Fatalf("second factor in the same srp session = %d %s", r.status, r.message())
+	}
+	s.token(r)
+
+	r = s.do(http.MethodPost, "/login/finish", request, "")
+	if r.status != http.StatusNotFound {
+		t.Errorf("reused srp session = %d %s", r.status, r.message())
+	}
+}
+
+func TestEmptyTotpSecretIsNotAFactor(t *testing.T) {
+	s, _, token, _ := newKeyUser(t)
+	code := emptySecretCode(t)
+
+	r := s.login(testUser, testPassword, map[string]interface{}{"totp": code})
+	if r.status != http.StatusNotFound {
+		t.Errorf("login with a code for the empty secret = %d %s", r.status, r.message())
+	}
+	s.unlock(testUser)
+
+	r = s.do(http.MethodDelete, keyPath(t, s, token), map[string]string{"code": code}, token)
+	if r.status != http.StatusMethodNotAllowed {
+		t.Errorf("removing the key with a code for the empty secret = %d %s", r.status, r.message())
+	}
+
+	reset, err := addPasswordReset(testUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt, verifier := testVerifier("Another horse 2!")
+	r = s.do(http.MethodPost, "/password/reset", map[string]string{"token": reset, "salt": salt, "verifier": verifier, "totp": code}, "")
+	if r.status != http.StatusMethodNotAllowed {
+		t.Errorf("reset with a code for the empty secret = %d %s", r.status, r.message())
+	}
+}
+
+func TestWebauthnRemove(t *testing.T) {
+	s, _, token, codes := newKeyUser(t)
+	path := keyPath(t, s, token)
+
+	r := s.do(http.MethodDelete, path, map[string]string{"code": "0000-0000-0000"}, token)
+	if r.status != http.StatusMethodNotAllowed {
+		t.Errorf("removing the key with a wrong code = %d %s", r.status, r.message())
+	}
+	r = s.do(http.MethodDelete, path, map[string]string{"code": codes[0]}, token)
+	if r.status != http.StatusOK {
+		t.Fatalf("removing the key with a recovery code = %d %s", r.status, r.message())
+	}
+
+	r = s.do(http.MethodGet, "/webauthn/credentials", nil, token)
+	var keys []interface{}
+	if err := r.decode("credentials", &keys); err != nil || len(keys) != 0 {
+		t.Errorf("credentials after removal = %v %v", keys, err)
+	}
+	left, err := countRecoveryCodes(testUser)
+	if err != nil || left != 0 {
+		t.Errorf("%d recovery codes left without a second factor", left)
+	}
+	s.token(s.login(testUser, testPassword, nil))
+}
@@ -0,0 +1,448 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"desktop/controller"
+	"desktop/unlock"
+)
+
+const Version = 1
+
+const (
+	codeParse          = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternal       = -32603
+	codeLocked         = 1
+	codeWrongPassword  = 2
+	codeNotFound       = 3
+	codeAmbiguous      = 4
+	codeExists         = 5
+	codeDenied         = 6
+)
+
+var ErrLocked = errors.New("vault is locked")
+var ErrDenied = errors.New("peer is not allowed to use the agent")
+var ErrVersion = errors.New("agent protocol version mismatch")
+
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type VersionResult struct {
+	Version int `json:"version"`
+	PID     int `json:"pid"`
+}
+
+type UnlockParams struct {
+	File     string `json:"file"`
+	Password string `json:"password"`
+	Timeout  int64  `json:"timeout,omitempty"`
+}
+
+type FileParams struct {
+	File string `json:"file"`
+}
+
+type PathParams struct {
+	File  string `json:"file"`
+	Path  string `json:"path"`
+	Field string `json:"field,omitempty"`
+}
+
+type KeyResult struct {
+	Password string `json:"password"`
+}
+
+type ValueResult struct {
+	Value string `json:"value"`
+}
+
+type Status struct {
+	File    string `json:"file"`
+	Expires string `json:"expires"`
+}
+
+type session struct {
+	password string
+	timeout  time.Duration
+	expires  time.Time
+}
+
+type Server struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+	timeout  time.Duration
+	uid      int
+	idle     chan struct{}
+}
+
+type handler func(s *Server, params json.RawMessage) (interface{}, error)
+
+var methods = map[string]handler{
+	"agent.version":   (*Server).version,
+	"vault.status":    (*Server).status,
+	"vault.unlock":    (*Server).unlock,
+	"vault.lock":      (*Server).lock,
+	"vault.key":       (*Server).key,
+	"vault.databases": (*Server).databases,
+	"vault.entry":     (*Server).entry,
+	"vault.field":     (*Server).field,
+	"vault.reference": (*Server).reference,
+}
+
+func DefaultSocket() string {
+	if socket := os.Getenv("FINALPASS_AGENT_SOCKET"); socket != "" {
+		return socket
+	}
+	return filepath.Join(unlock.RuntimeDir(), "finalpass-agent.sock")
+}
+
+func NewServer(timeout time.Duration) *Server {
+	return &Server{sessions: map[string]*session{}, timeout: timeout, uid: os.Getuid()}
+}
+
+func (s *Server) Serve(listener net.Listener) error {
+	go s.expire()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.idle != nil {
+				select {
+				case <-s.idle:
+					return nil
+				default:
+				}
+			}
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) stopWhenIdle(listener net.Listener, after time.Duration) {
+	started := time.Now()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.mu.Lock()
+		empty := len(s.sessions) == 0
+		s.mu.Unlock()
+		if empty && time.Since(started) > after {
+			log.Println("Nothing unlocked, stop agent")
+			close(s.idle)
+			listener.Close()
+			return
+		}
+	}
+}
+
+func RunDaemon(socket string, timeout time.Duration) error {
+	signal.Ignore(syscall.SIGHUP)
+	listener, err := unlock.Listen(socket)
+	if err != nil {
+		return err
+	}
+	server := NewServer(timeout)
+	server.idle = make(chan struct{})
+	go server.stopWhenIdle(listener, 30*time.Second)
+	defer os.Remove(socket)
+	return server.Serve(listener)
+}
+
+func (s *Server) LockAll() {
+	s.mu.Lock()
+	s.sessions = map[string]*session{}
+	s.mu.Unlock()
+}
+
+func (s *Server) expire() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.mu.Lock()
+		now := time.Now()
+		for file, session := range s.sessions {
+			if now.After(session.expires) {
+				log.Println("Lock " + file + " after timeout")
+				delete(s.sessions, file)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	uid, pid, err := peerCredentials(conn)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	encoder := json.NewEncoder(conn)
+	if uid != s.uid {
+		log.Println(fmt.Sprintf("refuse connection from uid %d pid %d", uid, pid))
+		encoder.Encode(Response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &Error{Code: codeDenied, Message: ErrDenied.Error()}})
+		return
+	}
+	decoder := json.NewDecoder(conn)
+	for {
+		var req Request
+		if err := decoder.Decode(&req); err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				encoder.Encode(Response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &Error{Code: codeParse, Message: err.Error()}})
+			}
+			return
+		}
+		if err := encoder.Encode(s.call(req)); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+}
+
+func (s *Server) call(req Request) Response {
+	res := Response{JSONRPC: "2.0", ID: req.ID}
+	if res.ID == nil {
+		res.ID = json.RawMessage("null")
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		res.Error = &Error{Code: codeInvalidRequest, Message: "invalid request"}
+		return res
+	}
+	method, ok := methods[req.Method]
+	if !ok {
+		res.Error = &Error{Code: codeMethodNotFound, Message: fmt.Sprintf("unknown method %s", req.Method)}
+		return res
+	}
+	result, err := method(s, req.Params)
+	if err != nil {
+		res.Error = toError(err)
+		return res
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		res.Error = &Error{Code: codeInternal, Message: err.Error()}
+		return res
+	}
+	res.Result = data
+	return res
+}
+
+type paramsError struct {
+	err error
+}
+
+func (e paramsError) Error() string {
+	return e.err.Error()
+}
+
+func decode(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 {
+		return paramsError{errors.New("missing params")}
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return paramsError{err}
+	}
+	return nil
+}
+
+func toError(err error) *Error {
+	var params paramsError
+	code := codeInternal
+	switch {
+	case errors.As(err, &params):
+		code = codeInvalidParams
+	case errors.Is(err, ErrLocked):
+		code = codeLocked
+	case errors.Is(err, controller.ErrWrongPassword):
+		code = codeWrongPassword
+	case errors.Is(err, controller.ErrNotFound):
+		code = codeNotFound
+	case errors.Is(err, controller.ErrAmbiguous):
+		code = codeAmbiguous
+	case errors.Is(err, controller.ErrExists):
+		code = codeExists
+	}
+	return &Error{Code: code, Message: err.Error()}
+}
+
+func (s *Server) password(file string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[file]
+	if !ok || time.Now().After(session.expires) {
+		return "", ErrLocked
+	}
+	session.expires = time.Now().Add(session.timeout)
+	return session.password, nil
+}
+
+func (s *Server) resolver(file string) (*controller.Resolver, error) {
+	password, err := s.password(file)
+	if err != nil {
+		return nil, err
+	}
+	return controller.NewResolver(file, password)
+}
+
+func (s *Server) version(params json.RawMessage) (interface{}, error) {
+	return VersionResult{Version: Version, PID: os.Getpid()}, nil
+}
+
+func (s *Server) status(params json.RawMessage) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := []Status{}
+	for file, session := range s.sessions {
+		statuses = append(statuses, Status{File: file, Expires: session.expires.Format("2006-01-02 15:04:05")})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].File < statuses[j].File
+	})
+	return statuses, nil
+}
+
+func (s *Server) unlock(params json.RawMessage) (interface{}, error) {
+	var p UnlockParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	if p.File == "" || p.Password == "" {
+		return nil, paramsError{errors.New("file and password are required")}
+	}
+	if !controller.CheckFileExist(p.File) {
+		return nil, fmt.Errorf("vault %s: %w", p.File, controller.ErrNotFound)
+	}
+	if _, err := controller.GetAllDatabases(p.File, p.Password); err != nil {
+		return nil, err
+	}
+	timeout := s.timeout
+	if p.Timeout > 0 {
+		timeout = time.Duration(p.Timeout) * time.Second
+	}
+	s.mu.Lock()
+	s.sessions[p.File] = &session{password: p.Password, timeout: timeout, expires: time.Now().Add(timeout)}
+	s.mu.Unlock()
+	log.Println("Unlock " + p.File)
+	return Status{File: p.File, Expires: time.Now().Add(timeout).Format("2006-01-02 15:04:05")}, nil
+}
+
+func (s *Server) lock(params json.RawMessage) (interface{}, error) {
+	var p FileParams
+	if len(params) > 0 {
+		if err := decode(params, &p); err != nil {
+			return nil, err
+		}
+	}
+	if p.File == "" {
+		s.LockAll()
+		log.Println("Lock all vaults")
+		return true, nil
+	}
+	s.mu.Lock()
+	delete(s.sessions, p.File)
+	s.mu.Unlock()
+	log.Println("Lock " + p.File)
+	return true, nil
+}
+
+func (s *Server) key(params json.RawMessage) (interface{}, error) {
+	var p FileParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	password, err := s.password(p.File)
+	if err != nil {
+		return nil, err
+	}
+	return KeyResult{Password: password}, nil
+}
+
+func (s *Server) databases(params json.RawMessage) (interface{}, error) {
+	var p FileParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	resolver, err := s.resolver(p.File)
+	if err != nil {
+		return nil, err
+	}
+	return resolver.Databases(), nil
+}
+
+func (s *Server) entry(params json.RawMessage) (interface{}, error) {
+	var p PathParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	resolver, err := s.resolver(p.File)
+	if err != nil {
+		return nil, err
+	}
+	return resolver.Entry(p.Path)
+}
+
+func (s *Server) field(params json.RawMessage) (interface{}, error) {
+	var p PathParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Field == "" {
+		p.Field = "password"
+	}
+	resolver, err := s.resolver(p.File)
+	if err != nil {
+		return nil, err
+	}
+	value, err := resolver.Field(p.Path, p.Field)
+	if err != nil {
+		return nil, err
+	}
+	return ValueResult{Value: value}, nil
+}
+
+func (s *Server) reference(params json.RawMessage) (interface{}, error) {
+	var p PathParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	resolver, err := s.resolver(p.File)
+	if err != nil {
+		return nil, err
+	}
+	value, err := resolver.Reference(p.Path)
+	if err != nil {
+		return nil, err
+	}
+	return ValueResult{Value: value}, nil
+}
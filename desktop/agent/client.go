@@ -0,0 +1,214 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"desktop/controller"
+	"desktop/models"
+	"desktop/unlock"
+)
+
+type Client struct {
+	mu      sync.Mutex
+	conn    net.Conn
+	encoder *json.Encoder
+	decoder *json.Decoder
+	id      int
+}
+
+type remoteError struct {
+	message string
+	err     error
+}
+
+func (e remoteError) Error() string {
+	return e.message
+}
+
+func (e remoteError) Unwrap() error {
+	return e.err
+}
+
+func Dial(socket string) (*Client, error) {
+	if err := unlock.CheckSocket(socket); err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("unix", socket, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{conn: conn, encoder: json.NewEncoder(conn), decoder: json.NewDecoder(conn)}
+	var version VersionResult
+	if err := c.Call("agent.version", nil, &version); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if version.Version != Version {
+		conn.Close()
+		return nil, fmt.Errorf("agent speaks version %d, want %d: %w", version.Version, Version, ErrVersion)
+	}
+	return c, nil
+}
+
+func Running(socket string) bool {
+	c, err := Dial(socket)
+	if err != nil {
+		return false
+	}
+	c.Close()
+	return true
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) Call(method string, params interface{}, result interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.id++
+	req := Request{JSONRPC: "2.0", ID: json.RawMessage(strconv.Itoa(c.id)), Method: method}
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		req.Params = data
+	}
+	c.conn.SetDeadline(time.Now().Add(30 * time.Second))
+	if err := c.encoder.Encode(req); err != nil {
+		return err
+	}
+	var res Response
+	if err := c.decoder.Decode(&res); err != nil {
+		return err
+	}
+	if res.Error != nil {
+		return fromError(res.Error)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(res.Result, result)
+}
+
+func fromError(e *Error) error {
+	var err error
+	switch e.Code {
+	case codeLocked:
+		err = ErrLocked
+	case codeWrongPassword:
+		err = controller.ErrWrongPassword
+	case codeNotFound:
+		err = controller.ErrNotFound
+	case codeAmbiguous:
+		err = controller.ErrAmbiguous
+	case codeExists:
+		err = controller.ErrExists
+	case codeDenied:
+		err = ErrDenied
+	default:
+		return errors.New(e.Message)
+	}
+	return remoteError{message: e.Message, err: err}
+}
+
+func (c *Client) Unlock(file string, password string, timeout time.Duration) (Status, error) {
+	var status Status
+	err := c.Call("vault.unlock", UnlockParams{File: file, Password: password, Timeout: int64(timeout / time.Second)}, &status)
+	return status, err
+}
+
+func (c *Client) Lock(file string) error {
+	return c.Call("vault.lock", FileParams{File: file}, nil)
+}
+
+func (c *Client) Status() ([]Status, error) {
+	var statuses []Status
+	err := c.Call("vault.status", nil, &statuses)
+	return statuses, err
+}
+
+func (c *Client) Key(file string) (string, error) {
+	var key KeyResult
+	err := c.Call("vault.key", FileParams{File: file}, &key)
+	return key.Password, err
+}
+
+func (c *Client) Databases(file string) ([]models.Database, error) {
+	var databases []models.Database
+	err := c.Call("vault.databases", FileParams{File: file}, &databases)
+	return databases, err
+}
+
+func (c *Client) Entry(file string, path string) (models.Entry, error) {
+	var entry models.Entry
+	err := c.Call("vault.entry", PathParams{File: file, Path: path}, &entry)
+	return entry, err
+}
+
+func (c *Client) Field(file string, path string, field string) (string, error) {
+	var value ValueResult
+	err := c.Call("vault.field", PathParams{File: file, Path: path, Field: field}, &value)
+	return value.Value, err
+}
+
+func (c *Client) Reference(file string, reference string) (string, error) {
+	var value ValueResult
+	err := c.Call("vault.reference", PathParams{File: file, Path: reference}, &value)
+	return value.Value, err
+}
+
+func Key(socket string, file string) (string, error) {
+	c, err := Dial(socket)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+	return c.Key(file)
+}
+
+func Unlock(socket string, file string, password string, timeout time.Duration) error {
+	c, err := Dial(socket)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	_, err = c.Unlock(file, password, timeout)
+	return err
+}
+
+func Lock(socket string, file string) error {
+	c, err := Dial(socket)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return c.Lock(file)
+}
+
+func Remember(socket string, file string, password string, timeout time.Duration) error {
+	if !Running(socket) {
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		cmd := exec.Command(exe, "agent-daemon", "--socket", socket)
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		cmd.Process.Release()
+		for i := 0; i < 40 && !Running(socket); i++ {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	return Unlock(socket, file, password, timeout)
+}
@@ -0,0 +1,32 @@
+//go:build linux
+
+package agent
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+func peerCredentials(conn net.Conn) (int, int, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return -1, -1, errors.New("agent connection is not a unix socket")
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return -1, -1, err
+	}
+	var cred *syscall.Ucred
+	var err2 error
+	err = raw.Control(func(fd uintptr) {
+		cred, err2 = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return -1, -1, err
+	}
+	if err2 != nil {
+		return -1, -1, err2
+	}
+	return int(cred.Uid), int(cred.Pid), nil
+}
@@ -0,0 +1,263 @@
+package browser
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+	"unsafe"
+)
+
+const maxMessage = 1024 * 1024
+
+const pendingTimeout = 10 * time.Minute
+
+var ErrNotPaired = errors.New("browser is not paired")
+var ErrPending = errors.New("pairing is waiting for confirmation in Finalpass")
+var ErrBadSignature = errors.New("message signature does not match")
+
+var nativeEndian binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	x := uint16(1)
+	if (*[2]byte)(unsafe.Pointer(&x))[0] == 0 {
+		nativeEndian = binary.BigEndian
+	}
+}
+
+type Pairing struct {
+	ID        string
+	Name      string
+	Origin    string
+	Key       string
+	Code      string
+	Created   string
+	Confirmed bool
+}
+
+func ReadMessage(r io.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r, nativeEndian, &length); err != nil {
+		return nil, err
+	}
+	if length > maxMessage {
+		return nil, fmt.Errorf("message of %d bytes is too large", length)
+	}
+	message := make([]byte, length)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+func WriteMessage(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(data) > maxMessage {
+		return fmt.Errorf("message of %d bytes is too large for the browser", len(data))
+	}
+	if err := binary.Write(w, nativeEndian, uint32(len(data))); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func Sign(key string, action string, nonce string, payload []byte) (string, error) {
+	secret, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(action + "\n" + nonce + "\n"))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+func Verify(key string, action string, nonce string, payload []byte, signature string) error {
+	expected, err := Sign(key, action, nonce, payload)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+func Code(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	n := binary.BigEndian.Uint32(sum[:4]) % 1000000
+	return fmt.Sprintf("%03d %03d", n/1000, n%1000)
+}
+
+func path() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "finalpass", "browsers.json"), nil
+}
+
+func expired(pairing Pairing) bool {
+	if pairing.Confirmed {
+		return false
+	}
+	created, err := time.ParseInLocation("2006-01-02 15:04:05", pairing.Created, time.Local)
+	return err != nil || time.Since(created) > pendingTimeout
+}
+
+func LoadPairings() (map[string]Pairing, error) {
+	pairings := map[string]Pairing{}
+	file, err := path()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return pairings, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &pairings); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	for id, pairing := range pairings {
+		if expired(pairing) {
+			delete(pairings, id)
+		}
+	}
+	return pairings, nil
+}
+
+func SavePairings(pairings map[string]Pairing) error {
+	file, err := path()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(pairings, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".browsers.json.*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+func random(n int) (string, error) {
+	buffer := make([]byte, n)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buffer), nil
+}
+
+func NewPairing(name string, origin string) (Pairing, error) {
+	pairings, err := LoadPairings()
+	if err != nil {
+		return Pairing{}, err
+	}
+	id, err := random(12)
+	if err != nil {
+		return Pairing{}, err
+	}
+	key, err := random(32)
+	if err != nil {
+		return Pairing{}, err
+	}
+	pairing := Pairing{
+		ID:      id,
+		Name:    name,
+		Origin:  origin,
+		Key:     key,
+		Code:    Code(key),
+		Created: time.Now().Format("2006-01-02 15:04:05"),
+	}
+	pairings[id] = pairing
+	return pairing, SavePairings(pairings)
+}
+
+func Pending() ([]Pairing, error) {
+	pairings, err := LoadPairings()
+	if err != nil {
+		return nil, err
+	}
+	var pending []Pairing
+	for _, pairing := range pairings {
+		if !pairing.Confirmed {
+			pending = append(pending, pairing)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].Created < pending[j].Created
+	})
+	return pending, nil
+}
+
+func Confirm(id string) error {
+	pairings, err := LoadPairings()
+	if err != nil {
+		return err
+	}
+	pairing, ok := pairings[id]
+	if !ok {
+		return ErrNotPaired
+	}
+	pairing.Confirmed = true
+	pairings[id] = pairing
+	return SavePairings(pairings)
+}
+
+func Remove(id string) error {
+	pairings, err := LoadPairings()
+	if err != nil {
+		return err
+	}
+	delete(pairings, id)
+	return SavePairings(pairings)
+}
+
+func Lookup(id string, origin string) (Pairing, error) {
+	pairings, err := LoadPairings()
+	if err != nil {
+		return Pairing{}, err
+	}
+	pairing, ok := pairings[id]
+	if !ok || pairing.Origin != origin {
+		return Pairing{}, ErrNotPaired
+	}
+	if !pairing.Confirmed {
+		return Pairing{}, ErrPending
+	}
+	return pairing, nil
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"desktop/agent"
+	"desktop/controller"
+	"desktop/models"
+	"desktop/unlock"
+
+	"gorm.io/gorm/logger"
+)
+
+const version = "1.0.0"
+
+var errCredentialsNotFound = errors.New("credentials not found in native keychain")
+
+type credentials struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+func main() {
+	if os.Getenv("FINALPASS_VERBOSE") == "" {
+		log.SetOutput(io.Discard)
+		logger.Default = logger.Default.LogMode(logger.Silent)
+	}
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, "Usage: docker-credential-finalpass <store|get|erase|list|version>")
+		os.Exit(1)
+	}
+	var err error
+	switch os.Args[1] {
+	case "store":
+		err = store(os.Stdin)
+	case "get":
+		err = get(os.Stdin, os.Stdout)
+	case "erase":
+		err = erase(os.Stdin)
+	case "list":
+		err = list(os.Stdout)
+	case "version":
+		fmt.Fprintf(os.Stdout, "docker-credential-finalpass %s\n", version)
+	case "agent-daemon":
+		socket := agent.DefaultSocket()
+		if len(os.Args) == 4 && os.Args[2] == "--socket" {
+			socket = os.Args[3]
+		}
+		err = agent.RunDaemon(socket, 15*time.Minute)
+	default:
+		err = fmt.Errorf("unknown credential action %s", os.Args[1])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stdout, err)
+		os.Exit(1)
+	}
+}
+
+func openVault() (string, string, error) {
+	file := os.Getenv("FINALPASS_FILE")
+	if file == "" {
+		file = controller.ReadConfig().Database
+	}
+	if file == "" {
+		return "", "", fmt.Errorf("no vault file, set FINALPASS_FILE")
+	}
+	if !controller.CheckFileExist(file) {
+		return "", "", fmt.Errorf("vault %s not found", file)
+	}
+	key, err := filepath.Abs(file)
+	if err != nil {
+		return "", "", err
+	}
+	if password, err2 := agent.Key(agent.DefaultSocket(), key); err2 == nil {
+		return file, password, nil
+	}
+	ttl := 15 * time.Minute
+	if value := os.Getenv("FINALPASS_CACHE_TIMEOUT"); value != "" {
+		ttl, err = time.ParseDuration(value)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid FINALPASS_CACHE_TIMEOUT %s", value)
+		}
+	}
+	password := os.Getenv("FINALPASS_PASSWORD")
+	if password == "" {
+		password, err = unlock.ReadPassword(fmt.Sprintf("Master password for %s: ", filepath.Base(file)))
+		if err != nil {
+			return "", "", err
+		}
+	}
+	if _, err := controller.GetAllDatabases(file, password); err != nil {
+		return "", "", err
+	}
+	if ttl > 0 {
+		if err := agent.Remember(agent.DefaultSocket(), key, password, ttl); err != nil {
+			log.Println(err)
+		}
+	}
+	return file, password, nil
+}
+
+func dockerGroup(resolver *controller.Resolver) (string, string, error) {
+	group := os.Getenv("FINALPASS_DOCKER_GROUP")
+	if group == "" {
+		databases := resolver.Databases()
+		if len(databases) == 0 {
+			return "", "", fmt.Errorf("vault has no sub database")
+		}
+		return databases[0].Name, "Docker", nil
+	}
+	parts := controller.SplitPath(group)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid FINALPASS_DOCKER_GROUP %s, expected subdb/group", group)
+	}
+	return parts[0], parts[1], nil
+}
+
+func find(resolver *controller.Resolver, serverURL string) ([]models.Entry, error) {
+	d, g, err := dockerGroup(resolver)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := resolver.FindByURL(serverURL)
+	if err != nil {
+		return nil, err
+	}
+	var entries []models.Entry
+	for _, entry := range matches {
+		if entry.Database == d && entry.Group == g && controller.MatchURL(entry.Secret, serverURL) >= controller.ScoreHost {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func readServerURL(r io.Reader) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	serverURL := strings.TrimSpace(string(data))
+	if serverURL == "" {
+		return "", fmt.Errorf("no credentials server URL")
+	}
+	return serverURL, nil
+}
+
+func store(r io.Reader) error {
+	var creds credentials
+	if err := json.NewDecoder(r).Decode(&creds); err != nil {
+		return err
+	}
+	if creds.ServerURL == "" {
+		return fmt.Errorf("no credentials server URL")
+	}
+	if creds.Username == "" {
+		return fmt.Errorf("no credentials username")
+	}
+	file, password, err := openVault()
+	if err != nil {
+		return err
+	}
+	resolver, err := controller.NewResolver(file, password)
+	if err != nil {
+		return err
+	}
+	entries, err := find(resolver, creds.ServerURL)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		secret := entries[0].Secret
+		secret.Username = creds.Username
+		secret.Password = []byte(creds.Secret)
+		_, err = controller.UpdateSecret(file, password, entries[0].Database, entries[0].Group, secret.ID, secret)
+		return err
+	}
+	d, g, err := dockerGroup(resolver)
+	if err != nil {
+		return err
+	}
+	if _, err := controller.FindSecretGroup(resolver.Databases(), d, g); errors.Is(err, controller.ErrNotFound) {
+		if _, err2 := controller.CreateSecretGroup(file, password, d, g); err2 != nil {
+			return err2
+		}
+	}
+	_, err = controller.CreateSecret(file, password, d, g, models.Secret{
+		Type:     models.SecretTypeLogin,
+		Title:    creds.ServerURL,
+		Username: creds.Username,
+		Password: []byte(creds.Secret),
+		URL:      creds.ServerURL,
+	})
+	return err
+}
+
+func get(r io.Reader, w io.Writer) error {
+	serverURL, err := readServerURL(r)
+	if err != nil {
+		return err
+	}
+	file, password, err := openVault()
+	if err != nil {
+		return err
+	}
+	resolver, err := controller.NewResolver(file, password)
+	if err != nil {
+		return err
+	}
+	entries, err := find(resolver, serverURL)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return errCredentialsNotFound
+	}
+	return json.NewEncoder(w).Encode(credentials{
+		ServerURL: serverURL,
+		Username:  entries[0].Secret.Username,
+		Secret:    string(entries[0].Secret.Password),
+	})
+}
+
+func erase(r io.Reader) error {
+	serverURL, err := readServerURL(r)
+	if err != nil {
+		return err
+	}
+	file, password, err := openVault()
+	if err != nil {
+		return err
+	}
+	resolver, err := controller.NewResolver(file, password)
+	if err != nil {
+		return err
+	}
+	entries, err := find(resolver, serverURL)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return errCredentialsNotFound
+	}
+	for _, entry := range entries {
+		if err := controller.DeleteSecret(file, password, entry.Database, entry.Group, entry.Secret.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func list(w io.Writer) error {
+	file, password, err := openVault()
+	if err != nil {
+		return err
+	}
+	resolver, err := controller.NewResolver(file, password)
+	if err != nil {
+		return err
+	}
+	d, g, err := dockerGroup(resolver)
+	if err != nil {
+		return err
+	}
+	result := map[string]string{}
+	group, err := controller.FindSecretGroup(resolver.Databases(), d, g)
+	if err == nil {
+		for _, secret := range group.Secrets {
+			if secret.Type == models.SecretTypeLogin {
+				result[secret.URL] = secret.Username
+			}
+		}
+	}
+	return json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"desktop/agent"
+	"desktop/unlock"
+
+	"gorm.io/gorm/logger"
+)
+
+func main() {
+	socket := flag.String("socket", agent.DefaultSocket(), "unix `socket` to listen on")
+	timeout := flag.Duration("timeout", 15*time.Minute, "lock a vault after it was not used for this long")
+	verbose := flag.Bool("verbose", false, "log requests and database queries")
+	flag.Parse()
+	if flag.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "Usage: finalpass-agent [--socket path] [--timeout 15m] [--verbose]")
+		os.Exit(2)
+	}
+	if *timeout <= 0 {
+		fmt.Fprintln(os.Stderr, "timeout must be positive")
+		os.Exit(2)
+	}
+	if !*verbose {
+		logger.Default = logger.Default.LogMode(logger.Silent)
+		log.SetOutput(io.Discard)
+	}
+	listener, err := unlock.Listen(*socket)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	server := agent.NewServer(*timeout)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGHUP {
+				log.Println("Lock all vaults on SIGHUP")
+				server.LockAll()
+				continue
+			}
+			os.Remove(*socket)
+			os.Exit(0)
+		}
+	}()
+	fmt.Fprintf(os.Stderr, "FINALPASS_AGENT_SOCKET=%s\n", *socket)
+	if err := server.Serve(listener); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"desktop/agent"
+	"desktop/browser"
+	"desktop/controller"
+	"desktop/models"
+	"desktop/security"
+
+	"gorm.io/gorm/logger"
+)
+
+type request struct {
+	Action    string          `json:"action"`
+	Client    string          `json:"client,omitempty"`
+	Nonce     string          `json:"nonce,omitempty"`
+	Timestamp int64           `json:"timestamp,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	MAC       string          `json:"mac,omitempty"`
+}
+
+type response struct {
+	Action  string          `json:"action"`
+	Nonce   string          `json:"nonce,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Code    string          `json:"code,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	MAC     string          `json:"mac,omitempty"`
+}
+
+type login struct {
+	Path     string `json:"path"`
+	Title    string `json:"title"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	URL      string `json:"url"`
+	TOTP     bool   `json:"totp"`
+}
+
+type host struct {
+	origin string
+	seen   map[string]bool
+}
+
+var errLocked = errors.New("locked")
+
+func main() {
+	if os.Getenv("FINALPASS_VERBOSE") == "" {
+		log.SetOutput(io.Discard)
+		logger.Default = logger.Default.LogMode(logger.Silent)
+	}
+	h := &host{origin: callerOrigin(os.Args[1:]), seen: map[string]bool{}}
+	in := bufio.NewReader(os.Stdin)
+	for {
+		message, err := browser.ReadMessage(in)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Println(err)
+			}
+			return
+		}
+		res := h.handle(message)
+		if err := browser.WriteMessage(os.Stdout, res); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+}
+
+func callerOrigin(args []string) string {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "chrome-extension://") {
+			return arg
+		}
+	}
+	if len(args) >= 2 {
+		return "moz-extension://" + args[1]
+	}
+	return ""
+}
+
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, errLocked):
+		return "locked"
+	case errors.Is(err, browser.ErrNotPaired):
+		return "not-paired"
+	case errors.Is(err, browser.ErrPending):
+		return "pairing-pending"
+	case errors.Is(err, browser.ErrBadSignature):
+		return "bad-signature"
+	case errors.Is(err, controller.ErrNotFound):
+		return "not-found"
+	}
+	return "error"
+}
+
+func (h *host) handle(message []byte) response {
+	var req request
+	if err := json.Unmarshal(message, &req); err != nil {
+		return response{Action: "error", Error: err.Error(), Code: "error"}
+	}
+	res := response{Action: req.Action, Nonce: req.Nonce}
+	fail := func(err error) response {
+		log.Println(err)
+		res.Error = err.Error()
+		res.Code = errorCode(err)
+		return res
+	}
+	if req.Action == "pair" {
+		var p struct {
+			Name string `json:"name"`
+		}
+		if len(req.Payload) > 0 {
+			if err := json.Unmarshal(req.Payload, &p); err != nil {
+				return fail(err)
+			}
+		}
+		if h.origin == "" {
+			return fail(fmt.Errorf("host was not started by a browser"))
+		}
+		if p.Name == "" {
+			p.Name = "Browser"
+		}
+		pairing, err := browser.NewPairing(p.Name, h.origin)
+		if err != nil {
+			return fail(err)
+		}
+		res.Payload, _ = json.Marshal(map[string]string{"client": pairing.ID, "key": pairing.Key, "code": pairing.Code})
+		return res
+	}
+	pairing, err := h.authenticate(req)
+	if err != nil {
+		return fail(err)
+	}
+	result, err := h.dispatch(req)
+	if err != nil {
+		res = fail(err)
+	} else {
+		res.Payload, err = json.Marshal(result)
+		if err != nil {
+			return fail(err)
+		}
+	}
+	res.MAC, _ = browser.Sign(pairing.Key, res.Action, res.Nonce, res.Payload)
+	return res
+}
+
+func (h *host) authenticate(req request) (browser.Pairing, error) {
+	pairing, err := browser.Lookup(req.Client, h.origin)
+	if err != nil {
+		return browser.Pairing{}, err
+	}
+	if err := browser.Verify(pairing.Key, req.Action, req.Nonce, req.Payload, req.MAC); err != nil {
+		return browser.Pairing{}, err
+	}
+	if req.Nonce == "" || h.seen[req.Nonce] {
+		return browser.Pairing{}, fmt.Errorf("nonce was already used: %w", browser.ErrBadSignature)
+	}
+	if d := time.Since(time.Unix(req.Timestamp, 0)); d > 2*time.Minute || d < -2*time.Minute {
+		return browser.Pairing{}, fmt.Errorf("message timestamp is out of range: %w", browser.ErrBadSignature)
+	}
+	h.seen[req.Nonce] = true
+	return pairing, nil
+}
+
+func (h *host) dispatch(req request) (interface{}, error) {
+	var p struct {
+		URL      string `json:"url"`
+		Title    string `json:"title"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Path     string `json:"path"`
+	}
+	if len(req.Payload) > 0 {
+		if err := json.Unmarshal(req.Payload, &p); err != nil {
+			return nil, err
+		}
+	}
+	switch req.Action {
+	case "status":
+		file, _, err := openVault()
+		return map[string]interface{}{"locked": errors.Is(err, errLocked), "vault": filepath.Base(file)}, nil
+	case "get-logins":
+		return getLogins(p.URL)
+	case "save-login":
+		return saveLogin(p.URL, p.Title, p.Username, p.Password)
+	case "get-totp":
+		return getTOTP(p.URL, p.Path)
+	}
+	return nil, fmt.Errorf("unknown action %s", req.Action)
+}
+
+func openVault() (string, string, error) {
+	file := os.Getenv("FINALPASS_FILE")
+	if file == "" {
+		file = controller.ReadConfig().Database
+	}
+	if file == "" || !controller.CheckFileExist(file) {
+		return file, "", fmt.Errorf("no vault configured: %w", controller.ErrNotFound)
+	}
+	key, err := filepath.Abs(file)
+	if err != nil {
+		return file, "", err
+	}
+	password, err := agent.Key(agent.DefaultSocket(), key)
+	if err != nil {
+		log.Println(err)
+		return file, "", fmt.Errorf("unlock Finalpass first: %w", errLocked)
+	}
+	return file, password, nil
+}
+
+func origin(value string) (string, string, bool) {
+	if value == "" {
+		return "", "", false
+	}
+	if !strings.Contains(value, "://") {
+		value = "https://" + value
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return "", "", false
+	}
+	return strings.ToLower(u.Scheme), strings.ToLower(u.Host), true
+}
+
+func entryPath(entry models.Entry) string {
+	return fmt.Sprintf("%s/%s/@%d", entry.Database, entry.Group, entry.Secret.ID)
+}
+
+func getLogins(page string) (interface{}, error) {
+	if _, _, ok := origin(page); !ok {
+		return nil, fmt.Errorf("invalid page url %s", page)
+	}
+	file, password, err := openVault()
+	if err != nil {
+		return nil, err
+	}
+	resolver, err := controller.NewResolver(file, password)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := resolver.FindByURL(page)
+	if err != nil {
+		return nil, err
+	}
+	logins := []login{}
+	for _, entry := range entries {
+		logins = append(logins, login{
+			Path:     entryPath(entry),
+			Title:    entry.Secret.Title,
+			Username: entry.Secret.Username,
+			Password: string(entry.Secret.Password),
+			URL:      entry.Secret.URL,
+			TOTP:     controller.DecodeFields(entry.Secret)["totp"] != "",
+		})
+	}
+	return map[string]interface{}{"logins": logins}, nil
+}
+
+func browserGroup(resolver *controller.Resolver, file string, password string) (string, string, error) {
+	group := os.Getenv("FINALPASS_BROWSER_GROUP")
+	if group == "" {
+		databases := resolver.Databases()
+		if len(databases) == 0 {
+			return "", "", fmt.Errorf("vault has no sub database: %w", controller.ErrNotFound)
+		}
+		group = databases[0].Name + "/Browser"
+	}
+	parts := controller.SplitPath(group)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid FINALPASS_BROWSER_GROUP %s, expected subdb/group", group)
+	}
+	_, err := controller.FindSecretGroup(resolver.Databases(), parts[0], parts[1])
+	if errors.Is(err, controller.ErrNotFound) {
+		if _, err2 := controller.FindDatabase(resolver.Databases(), parts[0]); err2 != nil {
+			return "", "", err2
+		}
+		_, err = controller.CreateSecretGroup(file, password, parts[0], parts[1])
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return parts[0], parts[1], nil
+}
+
+func saveLogin(page string, title string, username string, secret string) (interface{}, error) {
+	scheme, host, ok := origin(page)
+	if !ok {
+		return nil, fmt.Errorf("invalid page url %s", page)
+	}
+	if secret == "" {
+		return nil, fmt.Errorf("password is required")
+	}
+	file, password, err := openVault()
+	if err != nil {
+		return nil, err
+	}
+	resolver, err := controller.NewResolver(file, password)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := resolver.FindByURL(page)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.Secret.Username == username {
+			entry.Secret.Password = []byte(secret)
+			if _, err := controller.UpdateSecret(file, password, entry.Database, entry.Group, entry.Secret.ID, entry.Secret); err != nil {
+				return nil, err
+			}
+			return map[string]string{"path": entryPath(entry), "status": "updated"}, nil
+		}
+	}
+	d, g, err := browserGroup(resolver, file, password)
+	if err != nil {
+		return nil, err
+	}
+	if title == "" {
+		title = host
+	}
+	created, err := controller.CreateSecret(file, password, d, g, models.Secret{
+		Type:     models.SecretTypeLogin,
+		Title:    title,
+		Username: username,
+		Password: []byte(secret),
+		URL:      scheme + "://" + host,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"path": entryPath(models.Entry{Database: d, Group: g, Secret: created}), "status": "created"}, nil
+}
+
+func getTOTP(page string, path string) (interface{}, error) {
+	if _, _, ok := origin(page); !ok {
+		return nil, fmt.Errorf("invalid page url %s", page)
+	}
+	file, password, err := openVault()
+	if err != nil {
+		return nil, err
+	}
+	entry, err := controller.GetEntry(file, password, path)
+	if err != nil {
+		return nil, err
+	}
+	if controller.MatchURL(entry.Secret, page) == 0 {
+		return nil, fmt.Errorf("%s does not match %s: %w", path, page, controller.ErrNotFound)
+	}
+	spec := controller.DecodeFields(entry.Secret)["totp"]
+	if spec == "" {
+		return nil, fmt.Errorf("%s has no totp secret: %w", path, controller.ErrNotFound)
+	}
+	code, err := security.GenerateTOTP(spec, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"code": code.Code, "period": code.Period, "remaining": code.Remaining}, nil
+}
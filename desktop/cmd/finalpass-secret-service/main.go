@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"desktop/controller"
+	"desktop/secretservice"
+	"desktop/unlock"
+
+	"github.com/godbus/dbus/v5"
+	"gorm.io/gorm/logger"
+)
+
+func main() {
+	file := flag.String("file", os.Getenv("FINALPASS_FILE"), "vault `file` (default $FINALPASS_FILE or config.json)")
+	group := flag.String("group", "Secret Service", "secret `group` inside each sub database that holds the items")
+	collection := flag.String("collection", "", "sub database used as the default collection (default the first one)")
+	address := flag.String("bus", "", "D-Bus `address` to serve on (default the session bus)")
+	replace := flag.Bool("replace", false, "take over org.freedesktop.secrets from a running provider")
+	verbose := flag.Bool("verbose", false, "log requests and database queries")
+	flag.Parse()
+	if flag.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "Usage: finalpass-secret-service [--file vault.db] [--group name] [--collection subdb] [--bus address] [--replace]")
+		os.Exit(2)
+	}
+	if !*verbose {
+		logger.Default = logger.Default.LogMode(logger.Silent)
+		log.SetOutput(io.Discard)
+	}
+	if *file == "" {
+		*file = controller.ReadConfig().Database
+	}
+	if *file == "" || !controller.CheckFileExist(*file) {
+		fmt.Fprintln(os.Stderr, "no vault file, use --file or set FINALPASS_FILE")
+		os.Exit(2)
+	}
+
+	var conn *dbus.Conn
+	var err error
+	if *address == "" {
+		conn, err = dbus.ConnectSessionBus()
+	} else {
+		conn, err = dbus.Connect(*address)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	ask := func() (string, error) {
+		return unlock.ReadPassword(fmt.Sprintf("Master password for %s: ", filepath.Base(*file)))
+	}
+	service, err := secretservice.New(conn, *file, *group, *collection, ask)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if password := os.Getenv("FINALPASS_PASSWORD"); password != "" {
+		if err := service.Unlock(password); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(3)
+		}
+	}
+	if err := service.Export(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	flags := dbus.NameFlagDoNotQueue
+	if *replace {
+		flags |= dbus.NameFlagReplaceExisting
+	}
+	reply, err := conn.RequestName(secretservice.BusName, flags)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		fmt.Fprintf(os.Stderr, "%s is already provided on this bus, use --replace to take it over\n", secretservice.BusName)
+		os.Exit(1)
+	}
+	log.Println("Serve " + secretservice.BusName)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range signals {
+		if sig == syscall.SIGHUP {
+			service.Lock()
+			continue
+		}
+		return
+	}
+}
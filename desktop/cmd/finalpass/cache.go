@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"desktop/agent"
+	"desktop/controller"
+)
+
+const defaultCacheTimeout = 15 * time.Minute
+
+type durationFlag struct {
+	value time.Duration
+	set   bool
+}
+
+func (d *durationFlag) String() string {
+	if !d.set {
+		return ""
+	}
+	return d.value.String()
+}
+
+func (d *durationFlag) Set(value string) error {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	d.value = duration
+	d.set = true
+	return nil
+}
+
+func init() {
+	commands["unlock"] = command{"unlock [--cache-timeout 15m]", "keep the vault unlocked for a while", cmdUnlock}
+	commands["lock"] = command{"lock", "forget all cached unlocks", cmdLock}
+	commands["agent-daemon"] = command{"agent-daemon", "serve the agent for cached unlocks (started on demand)", cmdAgentDaemon}
+}
+
+func (c *cli) cacheTimeout() (time.Duration, error) {
+	if c.cache.set {
+		return c.cache.value, nil
+	}
+	if value := os.Getenv("FINALPASS_CACHE_TIMEOUT"); value != "" {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, usageError{fmt.Sprintf("invalid FINALPASS_CACHE_TIMEOUT %s", value)}
+		}
+		return duration, nil
+	}
+	return c.defaultCache, nil
+}
+
+func (c *cli) unlock(file string) (string, error) {
+	key, err := filepath.Abs(file)
+	if err != nil {
+		return "", err
+	}
+	if c.password == "" {
+		password, err2 := agent.Key(agent.DefaultSocket(), key)
+		if err2 == nil {
+			c.password = password
+			c.cacheKey = key
+			return password, nil
+		}
+	}
+	ttl, err := c.cacheTimeout()
+	if err != nil {
+		return "", err
+	}
+	if ttl <= 0 {
+		return c.masterPassword()
+	}
+	password, err := c.masterPassword()
+	if err != nil {
+		return "", err
+	}
+	if _, err := controller.GetAllDatabases(file, password); err != nil {
+		return "", err
+	}
+	if err := agent.Remember(agent.DefaultSocket(), key, password, ttl); err != nil {
+		log.Println(err)
+	}
+	return password, nil
+}
+
+func (c *cli) forgetCached(err error) {
+	if c.cacheKey == "" || !errors.Is(err, controller.ErrWrongPassword) {
+		return
+	}
+	if err2 := agent.Lock(agent.DefaultSocket(), c.cacheKey); err2 != nil {
+		log.Println(err2)
+	}
+}
+
+func cmdUnlock(c *cli, args []string) error {
+	fs := c.flags("unlock")
+	positional, err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		return usageError{"unlock takes no arguments"}
+	}
+	c.defaultCache = defaultCacheTimeout
+	ttl, err := c.cacheTimeout()
+	if err != nil {
+		return err
+	}
+	if ttl <= 0 {
+		return usageError{"cache timeout must be positive"}
+	}
+	file, _, err := c.openVault()
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(map[string]string{"file": file, "timeout": ttl.String()})
+	}
+	fmt.Fprintf(c.stdout, "Unlocked %s for %s\n", file, ttl)
+	return nil
+}
+
+func cmdLock(c *cli, args []string) error {
+	fs := c.flags("lock")
+	positional, err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		return usageError{"lock takes no arguments"}
+	}
+	if agent.Running(agent.DefaultSocket()) {
+		if err := agent.Lock(agent.DefaultSocket(), ""); err != nil {
+			return err
+		}
+	}
+	if !c.json {
+		fmt.Fprintln(c.stdout, "Locked")
+	}
+	return nil
+}
+
+func cmdAgentDaemon(c *cli, args []string) error {
+	fs := c.flags("agent-daemon")
+	socket := fs.String("socket", agent.DefaultSocket(), "unix `socket` to listen on")
+	positional, err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		return usageError{"agent-daemon takes no arguments"}
+	}
+	return agent.RunDaemon(*socket, defaultCacheTimeout)
+}
@@ -0,0 +1,860 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"desktop/controller"
+	"desktop/models"
+	"desktop/security"
+)
+
+const mask = "********"
+
+type secretJSON struct {
+	ID       int               `json:"id"`
+	Path     string            `json:"path"`
+	Type     string            `json:"type"`
+	Title    string            `json:"title"`
+	Username string            `json:"username,omitempty"`
+	Password string            `json:"password,omitempty"`
+	URL      string            `json:"url,omitempty"`
+	Notes    string            `json:"notes,omitempty"`
+	Note     string            `json:"note,omitempty"`
+	Fields   map[string]string `json:"fields,omitempty"`
+	Created  string            `json:"created_at"`
+	Updated  string            `json:"updated_at"`
+}
+
+type multiFlag []string
+
+func (m *multiFlag) String() string {
+	return strings.Join(*m, ",")
+}
+
+func (m *multiFlag) Set(value string) error {
+	*m = append(*m, value)
+	return nil
+}
+
+type secretOptions struct {
+	kind          string
+	title         string
+	username      string
+	urls          multiFlag
+	match         string
+	notes         string
+	note          string
+	set           multiFlag
+	generate      int
+	passwordStdin bool
+	totp          string
+}
+
+func init() {
+	commands["init"] = command{"init [--name subdb]", "create a new vault", cmdInit}
+	commands["ls"] = command{"ls [subdb[/group]]", "list sub databases, groups or secrets", cmdList}
+	commands["show"] = command{"show [--reveal] <path>", "show a secret", cmdShow}
+	commands["get"] = command{"get <path> [--field password]", "print a single field of a secret", cmdGet}
+	commands["add"] = command{"add [options] <path>", "add a secret", cmdAdd}
+	commands["edit"] = command{"edit [options] <path>", "change a secret", cmdEdit}
+	commands["rm"] = command{"rm <path>", "delete a secret", cmdRemove}
+	commands["mv"] = command{"mv <path> <subdb/group[/title]>", "move or rename a secret", cmdMove}
+	commands["find"] = command{"find <url>", "list logins matching a url, best match first", cmdFind}
+	commands["totp"] = command{"totp <path>", "print the current TOTP code of a login", cmdTOTP}
+	commands["generate"] = command{"generate [--length 20]", "print a generated password", cmdGenerate}
+	commands["passwd"] = command{"passwd", "change the master password", cmdPasswd}
+	commands["help"] = command{"help", "show this help", cmdHelp}
+}
+
+func cmdHelp(c *cli, args []string) error {
+	c.usage()
+	return nil
+}
+
+func cmdInit(c *cli, args []string) error {
+	fs := c.flags("init")
+	name := fs.String("name", "", "name of the first sub database (default the file name)")
+	positional, err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 1 {
+		return usageError{"init takes at most one vault file"}
+	}
+	if len(positional) == 1 {
+		c.file = positional[0]
+	}
+	file, err := c.vault()
+	if err != nil {
+		return err
+	}
+	if controller.CheckFileExist(file) {
+		return fmt.Errorf("vault %s: %w", file, controller.ErrExists)
+	}
+	password, err2 := c.newPassword("Master password", "FINALPASS_PASSWORD")
+	if err2 != nil {
+		return err2
+	}
+	if *name == "" {
+		base := filepath.Base(file)
+		*name = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	if err := controller.InitDB(file, password); err != nil {
+		return err
+	}
+	if err := controller.CreateDatabaseAndSecretGroupIfNotExist(file, password, *name); err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(map[string]string{"file": file, "database": *name})
+	}
+	fmt.Fprintf(c.stdout, "Created vault %s with sub database %s\n", file, *name)
+	return nil
+}
+
+func cmdList(c *cli, args []string) error {
+	fs := c.flags("ls")
+	positional, err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 1 {
+		return usageError{"ls takes at most one path"}
+	}
+	path := ""
+	if len(positional) == 1 {
+		path = positional[0]
+	}
+	parts, err := splitPath(path, 0, 2)
+	if err != nil {
+		return err
+	}
+	file, password, err := c.openVault()
+	if err != nil {
+		return err
+	}
+	databases, err := controller.GetAllDatabases(file, password)
+	if err != nil {
+		return err
+	}
+	switch len(parts) {
+	case 0:
+		var names []string
+		for _, database := range databases {
+			names = append(names, database.Name)
+		}
+		return c.printNames(names)
+	case 1:
+		database, err2 := controller.FindDatabase(databases, parts[0])
+		if err2 != nil {
+			return err2
+		}
+		var names []string
+		for _, group := range database.SecretGroups {
+			names = append(names, group.Name)
+		}
+		return c.printNames(names)
+	}
+	group, err3 := controller.FindSecretGroup(databases, parts[0], parts[1])
+	if err3 != nil {
+		return err3
+	}
+	secrets := group.Secrets
+	sort.SliceStable(secrets, func(i, j int) bool {
+		return strings.ToLower(secrets[i].Title) < strings.ToLower(secrets[j].Title)
+	})
+	if c.json {
+		list := []secretJSON{}
+		for _, secret := range secrets {
+			list = append(list, secretJSON{
+				ID:       secret.ID,
+				Path:     fmt.Sprintf("%s/%s/%s", parts[0], parts[1], secret.Title),
+				Type:     secret.Type,
+				Title:    secret.Title,
+				Username: secret.Username,
+				URL:      secret.URL,
+				Created:  secret.Created_at,
+				Updated:  secret.Updated_at,
+			})
+		}
+		return c.printJSON(list)
+	}
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTITLE\tTYPE\tUSERNAME\tURL")
+	for _, secret := range secrets {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", secret.ID, secret.Title, secret.Type, secret.Username, secret.URL)
+	}
+	return w.Flush()
+}
+
+func (c *cli) printNames(names []string) error {
+	if c.json {
+		if names == nil {
+			names = []string{}
+		}
+		return c.printJSON(names)
+	}
+	for _, name := range names {
+		fmt.Fprintln(c.stdout, name)
+	}
+	return nil
+}
+
+func cmdShow(c *cli, args []string) error {
+	fs := c.flags("show")
+	reveal := fs.Bool("reveal", false, "show passwords and hidden fields")
+	positional, err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError{"show takes exactly one path"}
+	}
+	if _, err := splitPath(positional[0], 3, 3); err != nil {
+		return err
+	}
+	file, password, err := c.openVault()
+	if err != nil {
+		return err
+	}
+	entry, err := controller.GetEntry(file, password, positional[0])
+	if err != nil {
+		return err
+	}
+	secret := entry.Secret
+	hidden := map[string]bool{"password": true, "totp": true}
+	extra := controller.DecodeFields(secret)
+	for _, field := range models.SecretFields[secret.Type] {
+		hidden[field.Name] = field.Hidden
+	}
+	value := func(name string) string {
+		v, _ := controller.SecretField(secret, name)
+		if hidden[name] && !*reveal && v != "" {
+			return mask
+		}
+		return v
+	}
+	if c.json {
+		result := secretJSON{
+			ID:       secret.ID,
+			Path:     fmt.Sprintf("%s/%s/%s", entry.Database, entry.Group, secret.Title),
+			Type:     secret.Type,
+			Title:    secret.Title,
+			Username: secret.Username,
+			Password: value("password"),
+			URL:      secret.URL,
+			Notes:    secret.Description,
+			Note:     string(secret.Note),
+			Created:  secret.Created_at,
+			Updated:  secret.Updated_at,
+		}
+		if fields, ok := models.SecretFields[secret.Type]; ok {
+			result.Password = ""
+			result.Fields = map[string]string{}
+			for _, field := range fields {
+				result.Fields[field.Name] = value(field.Name)
+			}
+		} else {
+			for _, name := range []string{"urls", "match", "totp"} {
+				if _, ok := extra[name]; ok {
+					if result.Fields == nil {
+						result.Fields = map[string]string{}
+					}
+					result.Fields[name] = value(name)
+				}
+			}
+		}
+		return c.printJSON(result)
+	}
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "path:\t%s/%s/%s\n", entry.Database, entry.Group, secret.Title)
+	fmt.Fprintf(w, "id:\t%d\n", secret.ID)
+	fmt.Fprintf(w, "type:\t%s\n", secret.Type)
+	for _, name := range controller.SecretFieldNames(secret) {
+		if name == "title" || name == "note" {
+			continue
+		}
+		fmt.Fprintf(w, "%s:\t%s\n", name, strings.ReplaceAll(value(name), "\n", " "))
+	}
+	fmt.Fprintf(w, "created_at:\t%s\n", secret.Created_at)
+	fmt.Fprintf(w, "updated_at:\t%s\n", secret.Updated_at)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if secret.Type == models.SecretTypeNote {
+		fmt.Fprintln(c.stdout)
+		fmt.Fprintln(c.stdout, string(secret.Note))
+	}
+	return nil
+}
+
+func cmdGet(c *cli, args []string) error {
+	fs := c.flags("get")
+	field := fs.String("field", "", "`name` of the field to print (default password, or note for secure notes)")
+	positional, err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError{"get takes exactly one path"}
+	}
+	if _, err := splitPath(positional[0], 3, 3); err != nil {
+		return err
+	}
+	file, password, err := c.openVault()
+	if err != nil {
+		return err
+	}
+	entry, err := controller.GetEntry(file, password, positional[0])
+	if err != nil {
+		return err
+	}
+	name := *field
+	if name == "" {
+		name = "password"
+		if entry.Secret.Type == models.SecretTypeNote {
+			name = "note"
+		}
+	}
+	value, err := controller.SecretField(entry.Secret, name)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(map[string]string{"path": positional[0], "field": name, "value": value})
+	}
+	fmt.Fprintln(c.stdout, value)
+	return nil
+}
+
+func (c *cli) secretFlags(name string, options *secretOptions) *flag.FlagSet {
+	fs := c.flags(name)
+	fs.StringVar(&options.kind, "type", models.SecretTypeLogin, "secret `type`: login, note, card, identity, bank or ssh")
+	fs.StringVar(&options.title, "title", "", "new `title`")
+	fs.StringVar(&options.username, "username", "", "`username` of a login")
+	fs.Var(&options.urls, "url", "`url` of a login, repeat it to match more urls or androidapp:// ids")
+	fs.StringVar(&options.match, "match", "", "url match `mode`: domain, host, startswith, regex or never")
+	fs.StringVar(&options.notes, "notes", "", "free text `notes`")
+	fs.StringVar(&options.note, "note", "", "`text` of a secure note, - reads it from stdin")
+	fs.Var(&options.set, "set", "set a typed field as `name=value`, value @file reads it from a file")
+	fs.IntVar(&options.generate, "generate", 0, "generate a password of `length` characters")
+	fs.BoolVar(&options.passwordStdin, "password-stdin", false, "read the password from stdin")
+	fs.StringVar(&options.totp, "totp", "", "TOTP `secret` of a login as otpauth:// URI or base32, empty removes it")
+	return fs
+}
+
+func (c *cli) buildSecret(secret models.Secret, options *secretOptions, set map[string]bool) (models.Secret, error) {
+	if set["type"] {
+		_, structured := models.SecretFields[options.kind]
+		if !structured && options.kind != models.SecretTypeLogin && options.kind != models.SecretTypeNote {
+			return models.Secret{}, usageError{fmt.Sprintf("unknown secret type %s", options.kind)}
+		}
+		secret.Type = options.kind
+	}
+	if set["title"] {
+		secret.Title = options.title
+	}
+	if set["username"] {
+		secret.Username = options.username
+	}
+	if set["url"] {
+		secret.URL = ""
+		if len(options.urls) > 0 {
+			secret.URL = options.urls[0]
+		}
+	}
+	if set["notes"] {
+		secret.Description = options.notes
+	}
+	if set["note"] {
+		note := options.note
+		if note == "-" {
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return models.Secret{}, err
+			}
+			note = string(data)
+		}
+		secret.Note = []byte(note)
+	}
+	if secret.Type == models.SecretTypeNote && len(secret.Note) == 0 {
+		return models.Secret{}, usageError{"a secure note needs --note"}
+	}
+	fields, structured := models.SecretFields[secret.Type]
+	if len(options.set) > 0 && !structured {
+		return models.Secret{}, usageError{fmt.Sprintf("%s secrets have no typed fields", secret.Type)}
+	}
+	if structured {
+		values := controller.DecodeFields(secret)
+		for _, assignment := range options.set {
+			name, value, ok := strings.Cut(assignment, "=")
+			if !ok {
+				return models.Secret{}, usageError{fmt.Sprintf("invalid --set %s, expected name=value", assignment)}
+			}
+			known := false
+			for _, field := range fields {
+				known = known || field.Name == name
+			}
+			if !known || name == "public_key" || name == "fingerprint" {
+				return models.Secret{}, usageError{fmt.Sprintf("unknown field %s for %s secrets", name, secret.Type)}
+			}
+			if strings.HasPrefix(value, "@") {
+				data, err := os.ReadFile(value[1:])
+				if err != nil {
+					return models.Secret{}, err
+				}
+				value = string(data)
+			}
+			if value == "" {
+				delete(values, name)
+			} else {
+				values[name] = value
+			}
+		}
+		if secret.Type == models.SecretTypeSSH {
+			if values["private_key"] == "" {
+				return models.Secret{}, usageError{"an ssh secret needs --set private_key=@file"}
+			}
+			public, fingerprint, err := security.SSHPublicKey(values["private_key"], values["passphrase"], secret.Title)
+			if err != nil {
+				return models.Secret{}, fmt.Errorf("invalid private key: %w", err)
+			}
+			values["public_key"] = public
+			values["fingerprint"] = fingerprint
+			secret.Username = fingerprint
+		} else {
+			secret.Username = values[fields[0].Name]
+		}
+		if err := controller.ValidateFields(secret.Type, values); err != nil {
+			return models.Secret{}, usageError{err.Error()}
+		}
+		secret.Fields = controller.EncodeFields(values)
+	}
+	login := secret.Type == models.SecretTypeLogin || secret.Type == ""
+	if set["totp"] && !login {
+		return models.Secret{}, usageError{fmt.Sprintf("%s secrets have no totp secret", secret.Type)}
+	}
+	if (set["match"] || len(options.urls) > 1) && !login {
+		return models.Secret{}, usageError{fmt.Sprintf("%s secrets have only one url", secret.Type)}
+	}
+	if login && (set["totp"] || set["url"] || set["match"]) {
+		values := controller.DecodeFields(secret)
+		if set["totp"] {
+			if options.totp != "" && !security.ValidateTOTP(options.totp) {
+				return models.Secret{}, usageError{"invalid --totp, expected an otpauth:// URI or a base32 secret"}
+			}
+			values["totp"] = options.totp
+		}
+		if set["url"] {
+			values["urls"] = ""
+			if len(options.urls) > 1 {
+				values["urls"] = strings.Join(options.urls[1:], "\n")
+			}
+		}
+		if set["match"] {
+			values["match"] = options.match
+		}
+		for name, value := range values {
+			if value == "" {
+				delete(values, name)
+			}
+		}
+		secret.Fields = nil
+		if len(values) > 0 {
+			secret.Fields = controller.EncodeFields(values)
+		}
+	}
+	if login && (set["url"] || set["match"]) {
+		if err := controller.ValidateMatch(controller.SecretMatch(secret), controller.SecretURLs(secret)); err != nil {
+			return models.Secret{}, usageError{err.Error()}
+		}
+	}
+	if secret.Type != models.SecretTypeLogin {
+		secret.Password = []byte{}
+		return secret, nil
+	}
+	switch {
+	case options.generate > 0:
+		secret.Password = []byte(security.GenerateStrongPassword(options.generate, true, true, true, true))
+	case options.passwordStdin:
+		password, err := readLine(os.Stdin)
+		if err != nil {
+			return models.Secret{}, err
+		}
+		secret.Password = []byte(password)
+	case secret.ID == 0 && isTerminal(os.Stdin):
+		password, err := c.newPassword("Password", "")
+		if err != nil {
+			return models.Secret{}, err
+		}
+		secret.Password = []byte(password)
+	}
+	if secret.Password == nil {
+		secret.Password = []byte{}
+	}
+	return secret, nil
+}
+
+func visited(fs *flag.FlagSet) map[string]bool {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+func titleTaken(group models.SecretGroup, title string, id int) bool {
+	for _, secret := range group.Secrets {
+		if secret.Title == title && secret.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+func cmdAdd(c *cli, args []string) error {
+	options := &secretOptions{}
+	fs := c.secretFlags("add", options)
+	positional, err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError{"add takes exactly one path"}
+	}
+	parts, err := splitPath(positional[0], 3, 3)
+	if err != nil {
+		return err
+	}
+	set := visited(fs)
+	set["type"] = true
+	if set["title"] {
+		return usageError{"add takes the title from the path"}
+	}
+	file, password, err := c.openVault()
+	if err != nil {
+		return err
+	}
+	databases, err := controller.GetAllDatabases(file, password)
+	if err != nil {
+		return err
+	}
+	group, err := controller.FindSecretGroup(databases, parts[0], parts[1])
+	if err != nil {
+		return err
+	}
+	if titleTaken(group, parts[2], 0) {
+		return fmt.Errorf("secret %s: %w", positional[0], controller.ErrExists)
+	}
+	secret, err := c.buildSecret(models.Secret{Title: parts[2]}, options, set)
+	if err != nil {
+		return err
+	}
+	sct, err := controller.CreateSecret(file, password, parts[0], parts[1], secret)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(map[string]interface{}{"id": sct.ID, "path": positional[0]})
+	}
+	fmt.Fprintf(c.stdout, "Added %s\n", positional[0])
+	return nil
+}
+
+func cmdEdit(c *cli, args []string) error {
+	options := &secretOptions{}
+	fs := c.secretFlags("edit", options)
+	positional, err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError{"edit takes exactly one path"}
+	}
+	if _, err := splitPath(positional[0], 3, 3); err != nil {
+		return err
+	}
+	set := visited(fs)
+	if len(set) == 0 {
+		return usageError{"nothing to change"}
+	}
+	file, password, err := c.openVault()
+	if err != nil {
+		return err
+	}
+	databases, err := controller.GetAllDatabases(file, password)
+	if err != nil {
+		return err
+	}
+	entry, err := controller.GetEntry(file, password, positional[0])
+	if err != nil {
+		return err
+	}
+	if set["title"] {
+		group, err2 := controller.FindSecretGroup(databases, entry.Database, entry.Group)
+		if err2 != nil {
+			return err2
+		}
+		if options.title == "" {
+			return usageError{"title is empty"}
+		}
+		if titleTaken(group, options.title, entry.Secret.ID) {
+			return fmt.Errorf("secret %s/%s/%s: %w", entry.Database, entry.Group, options.title, controller.ErrExists)
+		}
+	}
+	if set["type"] && options.kind != entry.Secret.Type {
+		return usageError{"the type of a secret cannot be changed"}
+	}
+	secret, err := c.buildSecret(entry.Secret, options, set)
+	if err != nil {
+		return err
+	}
+	_, err = controller.UpdateSecret(file, password, entry.Database, entry.Group, secret.ID, secret)
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("%s/%s/%s", entry.Database, entry.Group, secret.Title)
+	if c.json {
+		return c.printJSON(map[string]interface{}{"id": secret.ID, "path": path})
+	}
+	fmt.Fprintf(c.stdout, "Updated %s\n", path)
+	return nil
+}
+
+func cmdRemove(c *cli, args []string) error {
+	fs := c.flags("rm")
+	positional, err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError{"rm takes exactly one path"}
+	}
+	if _, err := splitPath(positional[0], 3, 3); err != nil {
+		return err
+	}
+	file, password, err := c.openVault()
+	if err != nil {
+		return err
+	}
+	databases, err := controller.GetAllDatabases(file, password)
+	if err != nil {
+		return err
+	}
+	entry, err := controller.FindSecret(databases, positional[0])
+	if err != nil {
+		return err
+	}
+	if err := controller.DeleteSecret(file, password, entry.Database, entry.Group, entry.Secret.ID); err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(map[string]interface{}{"id": entry.Secret.ID, "path": positional[0]})
+	}
+	fmt.Fprintf(c.stdout, "Deleted %s\n", positional[0])
+	return nil
+}
+
+func cmdMove(c *cli, args []string) error {
+	fs := c.flags("mv")
+	positional, err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		return usageError{"mv takes a source and a destination path"}
+	}
+	if _, err := splitPath(positional[0], 3, 3); err != nil {
+		return err
+	}
+	target, err := splitPath(positional[1], 2, 3)
+	if err != nil {
+		return err
+	}
+	file, password, err := c.openVault()
+	if err != nil {
+		return err
+	}
+	databases, err := controller.GetAllDatabases(file, password)
+	if err != nil {
+		return err
+	}
+	entry, err := controller.FindSecret(databases, positional[0])
+	if err != nil {
+		return err
+	}
+	group, err := controller.FindSecretGroup(databases, target[0], target[1])
+	if err != nil {
+		return err
+	}
+	title := entry.Secret.Title
+	if len(target) == 3 {
+		title = target[2]
+	}
+	if titleTaken(group, title, entry.Secret.ID) {
+		return fmt.Errorf("secret %s/%s/%s: %w", target[0], target[1], title, controller.ErrExists)
+	}
+	if title != entry.Secret.Title {
+		full, err2 := controller.GetEntry(file, password, fmt.Sprintf("%s/%s/@%d", entry.Database, entry.Group, entry.Secret.ID))
+		if err2 != nil {
+			return err2
+		}
+		full.Secret.Title = title
+		if _, err2 = controller.UpdateSecret(file, password, entry.Database, entry.Group, entry.Secret.ID, full.Secret); err2 != nil {
+			return err2
+		}
+	}
+	if target[0] != entry.Database || target[1] != entry.Group {
+		if _, err := controller.MoveSecret(file, password, entry.Database, entry.Group, entry.Secret.ID, target[0], target[1]); err != nil {
+			return err
+		}
+	}
+	path := fmt.Sprintf("%s/%s/%s", target[0], target[1], title)
+	if c.json {
+		return c.printJSON(map[string]interface{}{"id": entry.Secret.ID, "path": path})
+	}
+	fmt.Fprintf(c.stdout, "Moved %s to %s\n", positional[0], path)
+	return nil
+}
+
+func cmdFind(c *cli, args []string) error {
+	fs := c.flags("find")
+	positional, err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError{"find takes exactly one url"}
+	}
+	file, password, err := c.openVault()
+	if err != nil {
+		return err
+	}
+	entries, err := controller.FindByURL(file, password, positional[0])
+	if err != nil {
+		return err
+	}
+	if c.json {
+		list := []secretJSON{}
+		for _, entry := range entries {
+			list = append(list, secretJSON{
+				ID:       entry.Secret.ID,
+				Path:     fmt.Sprintf("%s/%s/%s", entry.Database, entry.Group, entry.Secret.Title),
+				Type:     entry.Secret.Type,
+				Title:    entry.Secret.Title,
+				Username: entry.Secret.Username,
+				URL:      entry.Secret.URL,
+				Created:  entry.Secret.Created_at,
+				Updated:  entry.Secret.Updated_at,
+			})
+		}
+		return c.printJSON(list)
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("login for %s: %w", positional[0], controller.ErrNotFound)
+	}
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tUSERNAME\tURL")
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s/%s/@%d\t%s\t%s\n", entry.Database, entry.Group, entry.Secret.ID, entry.Secret.Username, entry.Secret.URL)
+	}
+	return w.Flush()
+}
+
+func cmdTOTP(c *cli, args []string) error {
+	fs := c.flags("totp")
+	positional, err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError{"totp takes exactly one path"}
+	}
+	if _, err := splitPath(positional[0], 3, 3); err != nil {
+		return err
+	}
+	file, password, err := c.openVault()
+	if err != nil {
+		return err
+	}
+	entry, err := controller.GetEntry(file, password, positional[0])
+	if err != nil {
+		return err
+	}
+	spec := controller.DecodeFields(entry.Secret)["totp"]
+	if spec == "" {
+		return fmt.Errorf("%s has no totp secret: %w", positional[0], controller.ErrNotFound)
+	}
+	code, err := security.GenerateTOTP(spec, time.Now())
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(map[string]interface{}{"path": positional[0], "code": code.Code, "period": code.Period, "remaining": code.Remaining})
+	}
+	fmt.Fprintln(c.stdout, code.Code)
+	return nil
+}
+
+func cmdGenerate(c *cli, args []string) error {
+	fs := c.flags("generate")
+	length := fs.Int("length", 20, "password `length`")
+	noLower := fs.Bool("no-lower", false, "leave out lowercase letters")
+	noUpper := fs.Bool("no-upper", false, "leave out uppercase letters")
+	noDigits := fs.Bool("no-digits", false, "leave out digits")
+	noSpecial := fs.Bool("no-special", false, "leave out special characters")
+	positional, err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		return usageError{"generate takes no arguments"}
+	}
+	if *length < 4 || *length > 1024 {
+		return usageError{"length must be between 4 and 1024"}
+	}
+	password := security.GenerateStrongPassword(*length, !*noLower, !*noUpper, !*noDigits, !*noSpecial)
+	if c.json {
+		return c.printJSON(map[string]string{"password": password})
+	}
+	fmt.Fprintln(c.stdout, password)
+	return nil
+}
+
+func cmdPasswd(c *cli, args []string) error {
+	fs := c.flags("passwd")
+	positional, err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		return usageError{"passwd takes no arguments"}
+	}
+	file, password, err := c.openVault()
+	if err != nil {
+		return err
+	}
+	if _, err := controller.GetAllDatabases(file, password); err != nil {
+		return err
+	}
+	newPassword, err := c.newPassword("New master password", "FINALPASS_NEW_PASSWORD")
+	if err != nil {
+		return err
+	}
+	if err := controller.ChangePassword(file, password, newPassword); err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(map[string]string{"file": file})
+	}
+	fmt.Fprintf(c.stdout, "Changed the master password of %s\n", file)
+	return nil
+}
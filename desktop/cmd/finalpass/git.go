@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"desktop/controller"
+	"desktop/models"
+)
+
+type gitCredential struct {
+	protocol string
+	host     string
+	path     string
+	username string
+	password string
+}
+
+func init() {
+	commands["git-credential"] = command{"git-credential [--group subdb/group] get|store|erase", "git credential helper", cmdGitCredential}
+}
+
+func cmdGitCredential(c *cli, args []string) error {
+	fs := c.flags("git-credential")
+	group := fs.String("group", os.Getenv("FINALPASS_GIT_GROUP"), "`subdb/group` to store new credentials in (default <first subdb>/Git)")
+	positional, err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError{"git-credential takes one of get, store or erase"}
+	}
+	c.defaultCache = defaultCacheTimeout
+	credential, err := readGitCredential(os.Stdin)
+	if err != nil {
+		return err
+	}
+	if credential.host == "" {
+		return nil
+	}
+	switch positional[0] {
+	case "get":
+		return c.gitGet(credential)
+	case "store":
+		if credential.username == "" || credential.password == "" {
+			return nil
+		}
+		return c.gitStore(credential, *group)
+	case "erase":
+		return c.gitErase(credential, *group)
+	}
+	return nil
+}
+
+func readGitCredential(r io.Reader) (gitCredential, error) {
+	var credential gitCredential
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "protocol":
+			credential.protocol = value
+		case "host":
+			credential.host = value
+		case "path":
+			credential.path = value
+		case "username":
+			credential.username = value
+		case "password":
+			credential.password = value
+		case "url":
+			u, err := url.Parse(value)
+			if err != nil {
+				return gitCredential{}, err
+			}
+			credential.protocol = u.Scheme
+			credential.host = u.Host
+			credential.path = strings.TrimPrefix(u.Path, "/")
+			if u.User != nil {
+				credential.username = u.User.Username()
+			}
+		}
+	}
+	return credential, scanner.Err()
+}
+
+func gitLocation(credential gitCredential) string {
+	location := credential.host
+	if credential.path != "" {
+		location += "/" + strings.Trim(credential.path, "/")
+	}
+	return location
+}
+
+func gitURL(credential gitCredential) string {
+	protocol := credential.protocol
+	if protocol == "" {
+		protocol = "https"
+	}
+	return protocol + "://" + gitLocation(credential)
+}
+
+func gitMatches(resolver *controller.Resolver, credential gitCredential) ([]models.Entry, error) {
+	entries, err := resolver.FindByURL(gitURL(credential))
+	if err != nil {
+		return nil, err
+	}
+	var matches []models.Entry
+	for _, entry := range entries {
+		if credential.username != "" && entry.Secret.Username != credential.username {
+			continue
+		}
+		matches = append(matches, entry)
+	}
+	return matches, nil
+}
+
+func (c *cli) gitResolver() (string, string, *controller.Resolver, error) {
+	file, password, err := c.openVault()
+	if err != nil {
+		return "", "", nil, err
+	}
+	resolver, err := controller.NewResolver(file, password)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return file, password, resolver, nil
+}
+
+func (c *cli) gitGet(credential gitCredential) error {
+	_, _, resolver, err := c.gitResolver()
+	if err != nil {
+		return err
+	}
+	matches, err := gitMatches(resolver, credential)
+	if err != nil || len(matches) == 0 {
+		return err
+	}
+	entry := matches[0]
+	fmt.Fprintf(c.stdout, "username=%s\n", entry.Secret.Username)
+	fmt.Fprintf(c.stdout, "password=%s\n", string(entry.Secret.Password))
+	return nil
+}
+
+func (c *cli) gitGroup(resolver *controller.Resolver, file string, password string, group string) (string, string, error) {
+	if group == "" {
+		databases := resolver.Databases()
+		if len(databases) == 0 {
+			return "", "", fmt.Errorf("vault has no sub database: %w", controller.ErrNotFound)
+		}
+		group = databases[0].Name + "/Git"
+	}
+	parts, err := splitPath(group, 2, 2)
+	if err != nil {
+		return "", "", err
+	}
+	_, err = controller.FindSecretGroup(resolver.Databases(), parts[0], parts[1])
+	if errors.Is(err, controller.ErrNotFound) {
+		if _, err2 := controller.FindDatabase(resolver.Databases(), parts[0]); err2 != nil {
+			return "", "", err2
+		}
+		_, err = controller.CreateSecretGroup(file, password, parts[0], parts[1])
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return parts[0], parts[1], nil
+}
+
+func (c *cli) gitStore(credential gitCredential, group string) error {
+	file, password, resolver, err := c.gitResolver()
+	if err != nil {
+		return err
+	}
+	matches, err := gitMatches(resolver, credential)
+	if err != nil {
+		return err
+	}
+	for _, entry := range matches {
+		if string(entry.Secret.Password) == credential.password {
+			return nil
+		}
+	}
+	d, g, err := c.gitGroup(resolver, file, password, group)
+	if err != nil {
+		return err
+	}
+	location := gitLocation(credential)
+	secrets, err := controller.FindSecretGroup(resolver.Databases(), d, g)
+	if err == nil {
+		for _, secret := range secrets.Secrets {
+			if secret.Type == models.SecretTypeLogin && secret.URL == gitURL(credential) && secret.Username == credential.username {
+				entry, err2 := resolver.Entry(fmt.Sprintf("%s/%s/@%d", d, g, secret.ID))
+				if err2 != nil {
+					return err2
+				}
+				entry.Secret.Password = []byte(credential.password)
+				_, err2 = controller.UpdateSecret(file, password, d, g, secret.ID, entry.Secret)
+				return err2
+			}
+		}
+	}
+	_, err = controller.CreateSecret(file, password, d, g, models.Secret{
+		Type:     models.SecretTypeLogin,
+		Title:    fmt.Sprintf("%s@%s", credential.username, location),
+		Username: credential.username,
+		Password: []byte(credential.password),
+		URL:      gitURL(credential),
+	})
+	return err
+}
+
+func (c *cli) gitErase(credential gitCredential, group string) error {
+	file, password, resolver, err := c.gitResolver()
+	if err != nil {
+		return err
+	}
+	if group == "" && len(resolver.Databases()) > 0 {
+		group = resolver.Databases()[0].Name + "/Git"
+	}
+	parts, err := splitPath(group, 2, 2)
+	if err != nil {
+		return err
+	}
+	matches, err := gitMatches(resolver, credential)
+	if err != nil {
+		return err
+	}
+	best := 0
+	for _, entry := range matches {
+		if score := controller.MatchURL(entry.Secret, gitURL(credential)); entry.Database == parts[0] && entry.Group == parts[1] && score > best {
+			best = score
+		}
+	}
+	for _, entry := range matches {
+		if entry.Database != parts[0] || entry.Group != parts[1] || controller.MatchURL(entry.Secret, gitURL(credential)) < best {
+			continue
+		}
+		if credential.password != "" && string(entry.Secret.Password) != credential.password {
+			continue
+		}
+		if err := controller.DeleteSecret(file, password, entry.Database, entry.Group, entry.Secret.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
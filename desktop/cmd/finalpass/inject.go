@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"desktop/controller"
+)
+
+func init() {
+	commands["inject"] = command{"inject [-i in] [-o out] [--force]", "render a template with secret references", cmdInject}
+}
+
+func cmdInject(c *cli, args []string) error {
+	fs := c.flags("inject")
+	input := fs.String("i", "", "template `file` (default stdin)")
+	output := fs.String("o", "", "output `file` (default stdout)")
+	force := fs.Bool("force", false, "overwrite the output even if it changed since the last render")
+	positional, err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		return usageError{"inject takes no arguments, use -i and -o"}
+	}
+	var source []byte
+	if *input == "" {
+		source, err = io.ReadAll(os.Stdin)
+	} else {
+		source, err = os.ReadFile(*input)
+	}
+	if err != nil {
+		return err
+	}
+
+	var resolver *controller.Resolver
+	resolve := func() (*controller.Resolver, error) {
+		if resolver != nil {
+			return resolver, nil
+		}
+		file, password, err := c.openVault()
+		if err != nil {
+			return nil, err
+		}
+		resolver, err = controller.NewResolver(file, password)
+		return resolver, err
+	}
+	funcs := template.FuncMap{
+		"fp": func(path string, field ...string) (string, error) {
+			if len(field) > 1 {
+				return "", fmt.Errorf("fp takes a path and at most one field")
+			}
+			name := "password"
+			if len(field) == 1 {
+				name = field[0]
+			}
+			r, err := resolve()
+			if err != nil {
+				return "", err
+			}
+			return r.Field(path, name)
+		},
+		"fpref": func(reference string) (string, error) {
+			r, err := resolve()
+			if err != nil {
+				return "", err
+			}
+			return r.Reference(reference)
+		},
+	}
+	name := "stdin"
+	if *input != "" {
+		name = filepath.Base(*input)
+	}
+	tmpl, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(string(source))
+	if err != nil {
+		return usageError{err.Error()}
+	}
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, nil); err != nil {
+		return err
+	}
+
+	if *output == "" {
+		_, err := c.stdout.Write(rendered.Bytes())
+		return err
+	}
+	target, err := filepath.Abs(*output)
+	if err != nil {
+		return err
+	}
+	state, err := readInjectState()
+	if err != nil {
+		return err
+	}
+	current, err := os.ReadFile(target)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	case *force:
+	case state[target] == "":
+		return fmt.Errorf("%s was not rendered by finalpass, use --force to overwrite it: %w", *output, controller.ErrExists)
+	case state[target] != digest(current):
+		return fmt.Errorf("%s changed since the last render, use --force to overwrite it: %w", *output, controller.ErrExists)
+	}
+	if err := writePrivate(target, rendered.Bytes()); err != nil {
+		return err
+	}
+	state[target] = digest(rendered.Bytes())
+	if err := writeInjectState(state); err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(map[string]string{"output": target})
+	}
+	return nil
+}
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func writePrivate(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func injectStatePath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "finalpass", "inject.json"), nil
+}
+
+func readInjectState() (map[string]string, error) {
+	state := map[string]string{}
+	path, err := injectStatePath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return state, nil
+}
+
+func writeInjectState(state map[string]string) error {
+	path, err := injectStatePath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writePrivate(path, data)
+}
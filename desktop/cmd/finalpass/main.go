@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"desktop/controller"
+
+	"gorm.io/gorm/logger"
+)
+
+const (
+	exitOK        = 0
+	exitError     = 1
+	exitUsage     = 2
+	exitPassword  = 3
+	exitNotFound  = 4
+	exitAmbiguous = 5
+	exitExists    = 6
+)
+
+type usageError struct {
+	message string
+}
+
+func (e usageError) Error() string {
+	return e.message
+}
+
+type command struct {
+	usage   string
+	summary string
+	run     func(c *cli, args []string) error
+}
+
+var commands = map[string]command{}
+
+type cli struct {
+	file         string
+	json         bool
+	passwordFD   int
+	verbose      bool
+	password     string
+	cache        durationFlag
+	defaultCache time.Duration
+	cacheKey     string
+	stdout       io.Writer
+	stderr       io.Writer
+}
+
+func main() {
+	c := &cli{passwordFD: -1, stdout: os.Stdout, stderr: os.Stderr}
+	args := os.Args[1:]
+	if strings.HasPrefix(filepath.Base(os.Args[0]), "git-credential-finalpass") {
+		args = append([]string{"git-credential"}, args...)
+	}
+	os.Exit(c.main(args))
+}
+
+func (c *cli) main(args []string) int {
+	fs := c.flags("finalpass")
+	fs.Usage = func() {
+		c.usage()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if !c.verbose {
+		log.SetOutput(io.Discard)
+		logger.Default = logger.Default.LogMode(logger.Silent)
+	}
+	if fs.NArg() == 0 {
+		c.usage()
+		return exitUsage
+	}
+	name := fs.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		return c.fail(usageError{fmt.Sprintf("unknown command %s", name)})
+	}
+	if err := cmd.run(c, fs.Args()[1:]); err != nil {
+		var status exitStatus
+		if errors.As(err, &status) {
+			return int(status)
+		}
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		c.forgetCached(err)
+		return c.fail(err)
+	}
+	return exitOK
+}
+
+func (c *cli) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.StringVar(&c.file, "file", c.file, "vault `file` (default $FINALPASS_FILE or config.json)")
+	fs.BoolVar(&c.json, "json", c.json, "print output as JSON")
+	fs.IntVar(&c.passwordFD, "password-fd", c.passwordFD, "read the master password from file descriptor `fd`")
+	fs.BoolVar(&c.verbose, "verbose", c.verbose, "log what the vault is doing to stderr")
+	fs.Var(&c.cache, "cache-timeout", "keep the vault unlocked for `duration` (default $FINALPASS_CACHE_TIMEOUT)")
+	return fs
+}
+
+func (c *cli) parse(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if err == flag.ErrHelp {
+				return nil, err
+			}
+			return nil, usageError{err.Error()}
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func (c *cli) usage() {
+	fmt.Fprintln(c.stderr, "Usage: finalpass [--file vault.db] [--json] [--password-fd fd] <command> [arguments]")
+	fmt.Fprintln(c.stderr)
+	fmt.Fprintln(c.stderr, "Commands:")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(c.stderr, "  %-40s %s\n", commands[name].usage, commands[name].summary)
+	}
+	fmt.Fprintln(c.stderr)
+	fmt.Fprintln(c.stderr, "Secrets are addressed as subdb/group/title, or subdb/group/@id when titles are ambiguous.")
+	fmt.Fprintln(c.stderr, "The master password is read from --password-fd, $FINALPASS_PASSWORD or the terminal.")
+}
+
+func (c *cli) vault() (string, error) {
+	file := c.file
+	if file == "" {
+		file = os.Getenv("FINALPASS_FILE")
+	}
+	if file == "" {
+		file = controller.ReadConfig().Database
+	}
+	if file == "" {
+		return "", usageError{"no vault file, use --file or set FINALPASS_FILE"}
+	}
+	return file, nil
+}
+
+func (c *cli) openVault() (string, string, error) {
+	file, err := c.vault()
+	if err != nil {
+		return "", "", err
+	}
+	if !controller.CheckFileExist(file) {
+		return "", "", fmt.Errorf("vault %s: %w", file, controller.ErrNotFound)
+	}
+	password, err2 := c.unlock(file)
+	if err2 != nil {
+		return "", "", err2
+	}
+	return file, password, nil
+}
+
+func (c *cli) printJSON(v interface{}) error {
+	encoder := json.NewEncoder(c.stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func (c *cli) fail(err error) int {
+	code := exitCode(err)
+	if c.json {
+		encoder := json.NewEncoder(c.stderr)
+		encoder.Encode(map[string]interface{}{"error": err.Error(), "code": code})
+	} else {
+		fmt.Fprintf(c.stderr, "finalpass: %s\n", err)
+		if code == exitUsage {
+			fmt.Fprintln(c.stderr, "Run 'finalpass help' for usage.")
+		}
+	}
+	return code
+}
+
+func exitCode(err error) int {
+	var usage usageError
+	switch {
+	case errors.As(err, &usage):
+		return exitUsage
+	case errors.Is(err, controller.ErrWrongPassword):
+		return exitPassword
+	case errors.Is(err, controller.ErrNotFound):
+		return exitNotFound
+	case errors.Is(err, controller.ErrAmbiguous):
+		return exitAmbiguous
+	case errors.Is(err, controller.ErrExists):
+		return exitExists
+	}
+	return exitError
+}
+
+func splitPath(path string, min int, max int) ([]string, error) {
+	parts := controller.SplitPath(path)
+	if len(parts) < min || len(parts) > max {
+		switch max {
+		case 1:
+			return nil, usageError{fmt.Sprintf("invalid path %q, expected subdb", path)}
+		case 2:
+			return nil, usageError{fmt.Sprintf("invalid path %q, expected subdb/group", path)}
+		}
+		return nil, usageError{fmt.Sprintf("invalid path %q, expected subdb/group/title", path)}
+	}
+	for _, part := range parts {
+		if strings.TrimSpace(part) == "" {
+			return nil, usageError{fmt.Sprintf("invalid path %q", path)}
+		}
+	}
+	return parts, nil
+}
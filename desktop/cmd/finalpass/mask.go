@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"sync"
+)
+
+const concealed = "<concealed by finalpass>"
+
+type maskWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	secrets [][]byte
+	buf     []byte
+}
+
+func newMaskWriter(w io.Writer, values []string) *maskWriter {
+	m := &maskWriter{w: w}
+	seen := map[string]bool{}
+	for _, value := range values {
+		if len(value) < 3 || seen[value] {
+			continue
+		}
+		seen[value] = true
+		m.secrets = append(m.secrets, []byte(value))
+	}
+	sort.Slice(m.secrets, func(i, j int) bool {
+		return len(m.secrets[i]) > len(m.secrets[j])
+	})
+	return m
+}
+
+func (m *maskWriter) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.buf = append(m.buf, p...)
+	if err := m.flush(false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (m *maskWriter) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.flush(true)
+}
+
+func (m *maskWriter) flush(final bool) error {
+	var out bytes.Buffer
+	i := 0
+scan:
+	for i < len(m.buf) {
+		rest := m.buf[i:]
+		for _, secret := range m.secrets {
+			if bytes.HasPrefix(rest, secret) {
+				out.WriteString(concealed)
+				i += len(secret)
+				continue scan
+			}
+		}
+		if !final {
+			for _, secret := range m.secrets {
+				if len(rest) < len(secret) && bytes.HasPrefix(secret, rest) {
+					break scan
+				}
+			}
+		}
+		out.WriteByte(m.buf[i])
+		i++
+	}
+	m.buf = append(m.buf[:0], m.buf[i:]...)
+	if out.Len() == 0 {
+		return nil
+	}
+	_, err := m.w.Write(out.Bytes())
+	return err
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"desktop/unlock"
+
+	"golang.org/x/term"
+)
+
+func (c *cli) masterPassword() (string, error) {
+	if c.password != "" {
+		return c.password, nil
+	}
+	password := ""
+	switch {
+	case c.passwordFD >= 0:
+		f := os.NewFile(uintptr(c.passwordFD), "password-fd")
+		if f == nil {
+			return "", usageError{fmt.Sprintf("invalid file descriptor %d", c.passwordFD)}
+		}
+		line, err := readLine(f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+		password = line
+	case os.Getenv("FINALPASS_PASSWORD") != "":
+		password = os.Getenv("FINALPASS_PASSWORD")
+	default:
+		line, err := readPassword("Master password: ")
+		if err != nil {
+			return "", err
+		}
+		password = line
+	}
+	if password == "" {
+		return "", usageError{"master password is empty"}
+	}
+	c.password = password
+	return password, nil
+}
+
+func (c *cli) newPassword(label string, env string) (string, error) {
+	if password := os.Getenv(env); env != "" && password != "" {
+		return password, nil
+	}
+	password, err := readPassword(fmt.Sprintf("%s: ", label))
+	if err != nil {
+		return "", err
+	}
+	repeat, err2 := readPassword(fmt.Sprintf("Repeat %s: ", strings.ToLower(label)))
+	if err2 != nil {
+		return "", err2
+	}
+	if password != repeat {
+		return "", fmt.Errorf("passwords do not match")
+	}
+	if password == "" {
+		return "", usageError{"password is empty"}
+	}
+	return password, nil
+}
+
+func readPassword(prompt string) (string, error) {
+	password, err := unlock.ReadPassword(prompt)
+	if errors.Is(err, unlock.ErrNoTerminal) {
+		return "", usageError{err.Error()}
+	}
+	return password, err
+}
+
+func readLine(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"desktop/controller"
+)
+
+type exitStatus int
+
+func (e exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+func init() {
+	commands["run"] = command{"run [--env NAME=fp://path#field] -- cmd", "run a command with secrets in its environment", cmdRun}
+}
+
+func cmdRun(c *cli, args []string) error {
+	fs := c.flags("run")
+	var assignments multiFlag
+	fs.Var(&assignments, "env", "set `NAME=fp://subdb/group/title#field` in the environment of the command")
+	noMask := fs.Bool("no-mask", false, "do not mask secret values in the output of the command")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return usageError{err.Error()}
+	}
+	argv := fs.Args()
+	if len(argv) == 0 {
+		return usageError{"run needs a command after --"}
+	}
+	references := map[string]string{}
+	for _, assignment := range assignments {
+		name, reference, ok := strings.Cut(assignment, "=")
+		if !ok || name == "" {
+			return usageError{fmt.Sprintf("invalid --env %s, expected NAME=fp://subdb/group/title#field", assignment)}
+		}
+		if _, _, err := controller.ParseReference(reference); err != nil {
+			return usageError{err.Error()}
+		}
+		references[name] = reference
+	}
+	var env []string
+	for _, variable := range os.Environ() {
+		name, value, _ := strings.Cut(variable, "=")
+		if name == "FINALPASS_PASSWORD" || name == "FINALPASS_NEW_PASSWORD" {
+			continue
+		}
+		if _, ok := references[name]; ok {
+			continue
+		}
+		if strings.HasPrefix(value, controller.ReferencePrefix) {
+			references[name] = value
+			continue
+		}
+		env = append(env, variable)
+	}
+	var values []string
+	if len(references) > 0 {
+		file, password, err := c.openVault()
+		if err != nil {
+			return err
+		}
+		resolver, err := controller.NewResolver(file, password)
+		if err != nil {
+			return err
+		}
+		for name, reference := range references {
+			value, err2 := resolver.Reference(reference)
+			if err2 != nil {
+				return fmt.Errorf("%s: %w", name, err2)
+			}
+			env = append(env, fmt.Sprintf("%s=%s", name, value))
+			values = append(values, value)
+		}
+	}
+
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	var stdout, stderr *maskWriter
+	if !*noMask && len(values) > 0 {
+		stdout = newMaskWriter(os.Stdout, values)
+		stderr = newMaskWriter(os.Stderr, values)
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	defer signal.Stop(signals)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				cmd.Process.Signal(sig)
+			case <-done:
+				return
+			}
+		}
+	}()
+	err := cmd.Wait()
+	close(done)
+	if stdout != nil {
+		stdout.Close()
+		stderr.Close()
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return exitStatus(128 + int(status.Signal()))
+		}
+		return exitStatus(exitErr.ExitCode())
+	}
+	return err
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"desktop/tui"
+)
+
+func init() {
+	commands["tui"] = command{"tui", "browse and edit the vault in the terminal", cmdTUI}
+}
+
+func cmdTUI(c *cli, args []string) error {
+	fs := c.flags("tui")
+	positional, err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		return usageError{"tui takes no arguments"}
+	}
+	if !isTerminal(os.Stdin) {
+		return fmt.Errorf("tui needs a terminal")
+	}
+	file, password, err := c.openVault()
+	if err != nil {
+		return err
+	}
+	return tui.Run(file, password)
+}
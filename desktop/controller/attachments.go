@@ -0,0 +1,530 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"desktop/models"
+	"desktop/security"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+const chunkSize = 1024 * 1024
+const chunkOverhead = 4 + 12 + 16
+
+func GetAttachments(file string, password string, id int) ([]models.Attachment, error) {
+	decrypted := security.DecryptFile(file, password, fmt.Sprintf("%s.tmp", file))
+	if !decrypted {
+		return nil, ErrWrongPassword
+	}
+	attachments, err := getAttachments(file, id)
+	encrypted := security.EncryptFile(file, password, fmt.Sprintf("%s.tmp", file))
+	if !encrypted {
+		return nil, fmt.Errorf("error when encrypting file")
+	}
+	return attachments, err
+}
+
+func getAttachments(file string, id int) ([]models.Attachment, error) {
+	log.Println("Get attachments")
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("%s.tmp", file)), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer cleanup(db)
+	var attachments []models.Attachment
+	result := db.Where("secret_id = ?", id).Order("name").Find(&attachments)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return attachments, nil
+}
+
+func AddAttachment(file string, password string, id int, path string) (models.Attachment, error) {
+	hash, size, err := security.HashFile(password, path)
+	if err != nil {
+		return models.Attachment{}, err
+	}
+	decrypted := security.DecryptFile(file, password, fmt.Sprintf("%s.tmp", file))
+	if !decrypted {
+		return models.Attachment{}, ErrWrongPassword
+	}
+	attachment, err := addAttachment(file, password, id, path, hash, size)
+	encrypted := security.EncryptFile(file, password, fmt.Sprintf("%s.tmp", file))
+	if !encrypted {
+		return models.Attachment{}, fmt.Errorf("error when encrypting file")
+	}
+	return attachment, err
+}
+
+func addAttachment(file string, password string, id int, path string, hash string, size int64) (models.Attachment, error) {
+	log.Println("Add attachment")
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("%s.tmp", file)), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return models.Attachment{}, err
+	}
+	defer cleanup(db)
+	var secret models.Secret
+	err2 := db.First(&secret, id).Error
+	if err2 != nil {
+		return models.Attachment{}, fmt.Errorf("secret not found")
+	}
+	if _, err3 := os.Stat(attachmentPath(file, hash)); err3 != nil {
+		var sizes []int64
+		result := db.Raw("SELECT size FROM (SELECT DISTINCT hash, size FROM attachments)").Scan(&sizes)
+		if result.Error != nil {
+			return models.Attachment{}, result.Error
+		}
+		used := storedSize(size)
+		for _, s := range sizes {
+			used += storedSize(s)
+		}
+		if used > models.MaxAttachmentSize {
+			return models.Attachment{}, fmt.Errorf("attachment size limit exceeded")
+		}
+		err4 := storeChunks(file, password, path, hash)
+		if err4 != nil {
+			log.Println(err4)
+			return models.Attachment{}, err4
+		}
+	}
+	currentTime := time.Now()
+	formattedTime := currentTime.Format("2006-01-02 15:04:05")
+	attachment := models.Attachment{
+		Name:       filepath.Base(path),
+		Size:       size,
+		Hash:       hash,
+		SecretID:   secret.ID,
+		Created_at: formattedTime,
+	}
+	result := db.Create(&attachment)
+	if result.Error != nil {
+		return models.Attachment{}, result.Error
+	}
+	return attachment, nil
+}
+
+func attachmentDir(file string) string {
+	return fmt.Sprintf("%s.attachments", file)
+}
+
+func attachmentPath(file string, hash string) string {
+	return filepath.Join(attachmentDir(file), hash)
+}
+
+func deletedPath(file string) string {
+	return filepath.Join(attachmentDir(file), "deleted")
+}
+
+func removeChunks(file string, hash string) error {
+	err := os.Remove(attachmentPath(file, hash))
+	if err != nil {
+		return err
+	}
+	f, err2 := os.OpenFile(deletedPath(file), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err2 != nil {
+		return err2
+	}
+	_, err3 := fmt.Fprintln(f, hash)
+	if err4 := f.Close(); err3 == nil {
+		err3 = err4
+	}
+	return err3
+}
+
+func deletedAttachments(file string) map[string]bool {
+	hashes := map[string]bool{}
+	data, err := os.ReadFile(deletedPath(file))
+	if err != nil {
+		return hashes
+	}
+	for _, hash := range strings.Fields(string(data)) {
+		hashes[hash] = true
+	}
+	return hashes
+}
+
+func storedSize(size int64) int64 {
+	chunks := (size + chunkSize - 1) / chunkSize
+	return size + chunks*chunkOverhead
+}
+
+func storeChunks(file string, password string, path string, hash string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return writeChunks(file, hash, func(write func([]byte) error) error {
+		buf := make([]byte, chunkSize)
+		for {
+			n, err2 := io.ReadFull(f, buf)
+			if n > 0 {
+				if err3 := write(buf[:n]); err3 != nil {
+					return err3
+				}
+			}
+			if err2 == io.EOF || err2 == io.ErrUnexpectedEOF {
+				return nil
+			}
+			if err2 != nil {
+				return err2
+			}
+		}
+	}, password)
+}
+
+func writeChunks(file string, hash string, produce func(write func([]byte) error) error, password string) error {
+	err := os.MkdirAll(attachmentDir(file), 0700)
+	if err != nil {
+		return err
+	}
+	tmp := attachmentPath(file, hash) + ".tmp"
+	out, err2 := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err2 != nil {
+		return err2
+	}
+	writer := bufio.NewWriter(out)
+	err3 := produce(func(plaintext []byte) error {
+		ciphertext, err4 := security.EncryptText(password, string(plaintext))
+		if err4 != nil {
+			return err4
+		}
+		if err5 := binary.Write(writer, binary.BigEndian, uint32(len(ciphertext))); err5 != nil {
+			return err5
+		}
+		_, err6 := writer.Write(ciphertext)
+		return err6
+	})
+	if err3 == nil {
+		err3 = writer.Flush()
+	}
+	if err7 := out.Close(); err3 == nil {
+		err3 = err7
+	}
+	if err3 != nil {
+		os.Remove(tmp)
+		return err3
+	}
+	return os.Rename(tmp, attachmentPath(file, hash))
+}
+
+func readChunks(file string, password string, hash string, consume func([]byte) error) error {
+	in, err := os.Open(attachmentPath(file, hash))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	reader := bufio.NewReader(in)
+	mac := security.NewHasher(password)
+	for {
+		var length uint32
+		err2 := binary.Read(reader, binary.BigEndian, &length)
+		if err2 == io.EOF {
+			if hex.EncodeToString(mac.Sum(nil)) != hash {
+				return fmt.Errorf("attachment is corrupted")
+			}
+			return nil
+		}
+		if err2 != nil || length > chunkSize+chunkOverhead {
+			return fmt.Errorf("attachment is corrupted")
+		}
+		ciphertext := make([]byte, length)
+		if _, err3 := io.ReadFull(reader, ciphertext); err3 != nil {
+			return fmt.Errorf("attachment is corrupted")
+		}
+		plaintext, err4 := security.DecryptText(password, ciphertext)
+		if err4 != nil {
+			return err4
+		}
+		mac.Write(plaintext)
+		if err5 := consume(plaintext); err5 != nil {
+			return err5
+		}
+	}
+}
+
+func reencryptChunks(file string, password string, newPassword string, hash string) (string, error) {
+	mac := security.NewHasher(newPassword)
+	tmp := fmt.Sprintf("%s.rekey", hash)
+	err := writeChunks(file, tmp, func(write func([]byte) error) error {
+		return readChunks(file, password, hash, func(plaintext []byte) error {
+			mac.Write(plaintext)
+			return write(plaintext)
+		})
+	}, newPassword)
+	if err != nil {
+		return "", err
+	}
+	newHash := hex.EncodeToString(mac.Sum(nil))
+	err2 := os.Rename(attachmentPath(file, tmp), attachmentPath(file, newHash))
+	if err2 != nil {
+		os.Remove(attachmentPath(file, tmp))
+		return "", err2
+	}
+	return newHash, nil
+}
+
+func SaveAttachment(file string, password string, attachmentID int, path string) error {
+	decrypted := security.DecryptFile(file, password, fmt.Sprintf("%s.tmp", file))
+	if !decrypted {
+		return ErrWrongPassword
+	}
+	err := saveAttachment(file, password, attachmentID, path)
+	encrypted := security.EncryptFile(file, password, fmt.Sprintf("%s.tmp", file))
+	if !encrypted {
+		return fmt.Errorf("error when encrypting file")
+	}
+	return err
+}
+
+func saveAttachment(file string, password string, attachmentID int, path string) error {
+	log.Println("Save attachment")
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("%s.tmp", file)), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	defer cleanup(db)
+	var attachment models.Attachment
+	err2 := db.First(&attachment, attachmentID).Error
+	if err2 != nil {
+		return fmt.Errorf("attachment not found")
+	}
+	out, err3 := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err3 != nil {
+		log.Println(err3)
+		return err3
+	}
+	var written int64
+	err4 := readChunks(file, password, attachment.Hash, func(plaintext []byte) error {
+		n, err5 := out.Write(plaintext)
+		written += int64(n)
+		return err5
+	})
+	if err4 == nil && written != attachment.Size {
+		err4 = fmt.Errorf("attachment is corrupted")
+	}
+	if err6 := out.Close(); err4 == nil {
+		err4 = err6
+	}
+	if err4 != nil {
+		os.Remove(path)
+		return err4
+	}
+	return nil
+}
+
+func DeleteAttachment(file string, password string, attachmentID int) error {
+	decrypted := security.DecryptFile(file, password, fmt.Sprintf("%s.tmp", file))
+	if !decrypted {
+		return ErrWrongPassword
+	}
+	err := deleteAttachment(file, attachmentID)
+	encrypted := security.EncryptFile(file, password, fmt.Sprintf("%s.tmp", file))
+	if !encrypted {
+		return fmt.Errorf("error when encrypting file")
+	}
+	return err
+}
+
+func deleteAttachment(file string, attachmentID int) error {
+	log.Println("Delete attachment")
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("%s.tmp", file)), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	defer cleanup(db)
+	var attachment models.Attachment
+	err2 := db.First(&attachment, attachmentID).Error
+	if err2 != nil {
+		return fmt.Errorf("attachment not found")
+	}
+	db.Delete(&attachment)
+	pruneChunks(db, file, attachment.Hash)
+	return nil
+}
+
+func deleteAttachments(db *gorm.DB, file string, id int) {
+	var attachments []models.Attachment
+	db.Where("secret_id = ?", id).Find(&attachments)
+	for _, attachment := range attachments {
+		db.Delete(&attachment)
+		pruneChunks(db, file, attachment.Hash)
+	}
+}
+
+func pruneChunks(db *gorm.DB, file string, hash string) {
+	var references int64
+	db.Model(&models.Attachment{}).Where("hash = ?", hash).Count(&references)
+	if references == 0 {
+		err := removeChunks(file, hash)
+		if err != nil && !os.IsNotExist(err) {
+			log.Println(err)
+		}
+	}
+}
+
+func localAttachments(file string) map[string]bool {
+	hashes := map[string]bool{}
+	entries, err := os.ReadDir(attachmentDir(file))
+	if err != nil {
+		return hashes
+	}
+	for _, entry := range entries {
+		if len(entry.Name()) == 64 && !strings.Contains(entry.Name(), ".") {
+			hashes[entry.Name()] = true
+		}
+	}
+	return hashes
+}
+
+func remoteAttachments(user *models.User) (map[string]bool, error) {
+	resp, err := SendRequest(fmt.Sprintf("%s/user/attachments", models.Url), "GET", nil, user.Token)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var data struct {
+		Message     string   `json:"message"`
+		Attachments []string `json:"attachments"`
+	}
+	err2 := json.NewDecoder(resp.Body).Decode(&data)
+	if err2 != nil {
+		log.Println(err2)
+		return nil, err2
+	}
+	if resp.StatusCode != 200 {
+		log.Println("Error when listing attachments")
+		log.Println(data.Message)
+		return nil, fmt.Errorf("error when listing attachments")
+	}
+	hashes := map[string]bool{}
+	for _, hash := range data.Attachments {
+		hashes[hash] = true
+	}
+	return hashes, nil
+}
+
+func uploadAttachments(user *models.User, file string) error {
+	remote, err := remoteAttachments(user)
+	if err != nil {
+		return err
+	}
+	local := localAttachments(file)
+	for hash := range deletedAttachments(file) {
+		if local[hash] || !remote[hash] {
+			continue
+		}
+		resp, err2 := SendRequest(fmt.Sprintf("%s/user/attachments/%s", models.Url, hash), "DELETE", nil, user.Token)
+		if err2 != nil {
+			log.Println(err2)
+			return err2
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			log.Println("Error when removing attachment")
+			return fmt.Errorf("error when removing attachment")
+		}
+	}
+	if err6 := os.Remove(deletedPath(file)); err6 != nil && !os.IsNotExist(err6) {
+		log.Println(err6)
+	}
+	for hash := range local {
+		if remote[hash] {
+			continue
+		}
+		f, err3 := os.Open(attachmentPath(file, hash))
+		if err3 != nil {
+			log.Println(err3)
+			return err3
+		}
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, err4 := writer.CreateFormFile("file", hash)
+		if err4 == nil {
+			_, err4 = io.Copy(part, f)
+		}
+		f.Close()
+		if err4 == nil {
+			err4 = writer.Close()
+		}
+		if err4 != nil {
+			log.Println(err4)
+			return err4
+		}
+		resp, err5 := SendRequest2(fmt.Sprintf("%s/user/attachments/%s", models.Url, hash), "POST", body, writer.FormDataContentType(), user.Token)
+		if err5 != nil {
+			log.Println(err5)
+			return err5
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			log.Println("Error when saving attachment")
+			return fmt.Errorf("error when saving attachment")
+		}
+	}
+	return nil
+}
+
+func downloadAttachments(user *models.User, file string) error {
+	remote, err := remoteAttachments(user)
+	if err != nil {
+		return err
+	}
+	local := localAttachments(file)
+	for hash := range remote {
+		if local[hash] {
+			continue
+		}
+		resp, err2 := SendRequest(fmt.Sprintf("%s/user/attachments/%s", models.Url, hash), "GET", nil, user.Token)
+		if err2 != nil {
+			log.Println(err2)
+			return err2
+		}
+		err3 := func() error {
+			defer resp.Body.Close()
+			if resp.StatusCode != 200 {
+				return fmt.Errorf("error when syncing attachment")
+			}
+			err4 := os.MkdirAll(attachmentDir(file), 0700)
+			if err4 != nil {
+				return err4
+			}
+			out, err5 := os.OpenFile(attachmentPath(file, hash)+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+			if err5 != nil {
+				return err5
+			}
+			_, err6 := io.Copy(out, resp.Body)
+			if err7 := out.Close(); err6 == nil {
+				err6 = err7
+			}
+			if err6 != nil {
+				os.Remove(attachmentPath(file, hash) + ".tmp")
+				return err6
+			}
+			return os.Rename(attachmentPath(file, hash)+".tmp", attachmentPath(file, hash))
+		}()
+		if err3 != nil {
+			log.Println(err3)
+			return err3
+		}
+	}
+	return nil
+}
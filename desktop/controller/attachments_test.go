@@ -0,0 +1,71 @@
+package controller
+
+import (
+	"bytes"
+	"desktop/security"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func storeTestAttachment(t *testing.T, password string) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	file := filepath.Join(dir, "vault")
+	path := filepath.Join(dir, "plain")
+	content := append(bytes.Repeat([]byte("a"), chunkSize), bytes.Repeat([]byte("b"), chunkSize)...)
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	hash, _, err := security.HashFile(password, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storeChunks(file, password, path, hash); err != nil {
+		t.Fatal(err)
+	}
+	return file, hash
+}
+
+func TestReadChunksRejectsSwappedChunks(t *testing.T) {
+	file, hash := storeTestAttachment(t, "master password")
+	if err := readChunks(file, "master password", hash, func([]byte) error { return nil }); err != nil {
+		t.Fatalf("stored attachment does not read back: %v", err)
+	}
+
+	data, err := os.ReadFile(attachmentPath(file, hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := 4 + int(binary.BigEndian.Uint32(data))
+	swapped := append(append([]byte{}, data[first:]...), data[:first]...)
+	if err := os.WriteFile(attachmentPath(file, hash), swapped, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := readChunks(file, "master password", hash, func([]byte) error { return nil }); err == nil {
+		t.Error("attachment with swapped chunks was accepted")
+	}
+	if err := os.WriteFile(attachmentPath(file, hash), data[:first], 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := readChunks(file, "master password", hash, func([]byte) error { return nil }); err == nil {
+		t.Error("attachment missing its last chunk was accepted")
+	}
+}
+
+func TestRemoveChunksRemembersDeletedHashes(t *testing.T) {
+	file, hash := storeTestAttachment(t, "master password")
+	if deleted := deletedAttachments(file); len(deleted) != 0 {
+		t.Fatalf("deleted before removal = %v", deleted)
+	}
+	if err := removeChunks(file, hash); err != nil {
+		t.Fatal(err)
+	}
+	if local := localAttachments(file); local[hash] {
+		t.Error("removed attachment is still listed locally")
+	}
+	if deleted := deletedAttachments(file); len(deleted) != 1 || !deleted[hash] {
+		t.Errorf("deleted = %v, want %s", deleted, hash)
+	}
+}
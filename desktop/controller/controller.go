@@ -2,27 +2,37 @@ package controller
 
 import (
 	"bytes"
+	"crypto/hmac"
 	"crypto/sha256"
 	"crypto/tls"
 	"desktop/models"
 	"desktop/security"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
+var ErrWrongPassword = errors.New("wrong password")
+var ErrSessionExpired = errors.New("session expired")
+
+var refreshMutex sync.Mutex
+
 func CheckFileExist(file string) bool {
 	log.Println("Check if file exist")
 	if _, err := os.Stat(file); err == nil {
@@ -54,7 +64,11 @@ func initDB(file string, password string) error {
 		return err
 	}
 	defer cleanup(db)
-	err = db.AutoMigrate(&models.Database{}, &models.SecretGroup{}, &models.Secret{})
+	return migrate(db)
+}
+
+func migrate(db *gorm.DB) error {
+	err := db.AutoMigrate(&models.Database{}, &models.SecretGroup{}, &models.Secret{}, &models.Attachment{})
 	if err != nil {
 		log.Println(err)
 		return err
@@ -65,7 +79,7 @@ func initDB(file string, password string) error {
 func CreateDatabaseAndSecretGroupIfNotExist(file string, password string, name string) error {
 	decrypted := security.DecryptFile(file, password, fmt.Sprintf("%s.tmp", file))
 	if !decrypted {
-		return fmt.Errorf("wrong password")
+		return ErrWrongPassword
 	}
 	err := createDatabaseAndSecretGroupIfNotExist(file, name)
 	encrypted := security.EncryptFile(file, password, fmt.Sprintf("%s.tmp", file))
@@ -113,7 +127,7 @@ func createDatabaseAndSecretGroupIfNotExist(file string, name string) error {
 func CreateSubDatabase(file string, password string, name string) (models.Database, error) {
 	decrypted := security.DecryptFile(file, password, fmt.Sprintf("%s.tmp", file))
 	if !decrypted {
-		return models.Database{}, fmt.Errorf("wrong password")
+		return models.Database{}, ErrWrongPassword
 	}
 	sub, err := createSubDatabase(file, name)
 	encrypted := security.EncryptFile(file, password, fmt.Sprintf("%s.tmp", file))
@@ -159,7 +173,7 @@ func createSubDatabase(file string, name string) (models.Database, error) {
 func GetAllDatabases(file string, password string) ([]models.Database, error) {
 	decrypted := security.DecryptFile(file, password, fmt.Sprintf("%s.tmp", file))
 	if !decrypted {
-		return nil, fmt.Errorf("wrong password")
+		return nil, ErrWrongPassword
 	}
 	db, err := getAllDatabases(file)
 	encrypted := security.EncryptFile(file, password, fmt.Sprintf("%s.tmp", file))
@@ -177,6 +191,10 @@ func getAllDatabases(file string) ([]models.Database, error) {
 		return nil, err
 	}
 	defer cleanup(db)
+	err = migrate(db)
+	if err != nil {
+		return nil, err
+	}
 	var databases []models.Database
 	result := db.Preload("SecretGroups.Secrets").Find(&databases)
 	if result.Error != nil {
@@ -188,7 +206,7 @@ func getAllDatabases(file string) ([]models.Database, error) {
 func GetDatabase(file string, password string, d string) (models.Database, error) {
 	decrypted := security.DecryptFile(file, password, fmt.Sprintf("%s.tmp", file))
 	if !decrypted {
-		return models.Database{}, fmt.Errorf("wrong password")
+		return models.Database{}, ErrWrongPassword
 	}
 	db, err := getDatabase(file, d)
 	encrypted := security.EncryptFile(file, password, fmt.Sprintf("%s.tmp", file))
@@ -217,7 +235,7 @@ func getDatabase(file string, d string) (models.Database, error) {
 func UpdateDatabase(file string, password string, d string, name string) (models.Database, error) {
 	decrypted := security.DecryptFile(file, password, fmt.Sprintf("%s.tmp", file))
 	if !decrypted {
-		return models.Database{}, fmt.Errorf("wrong password")
+		return models.Database{}, ErrWrongPassword
 	}
 	db, err := updateDatabase(file, d, name)
 	encrypted := security.EncryptFile(file, password, fmt.Sprintf("%s.tmp", file))
@@ -251,7 +269,7 @@ func updateDatabase(file string, d string, name string) (models.Database, error)
 func GetSecrets(file string, password string, d string, g string) ([]models.Secret, error) {
 	decrypted := security.DecryptFile(file, password, fmt.Sprintf("%s.tmp", file))
 	if !decrypted {
-		return nil, fmt.Errorf("wrong password")
+		return nil, ErrWrongPassword
 	}
 	sct, err := getSecrets(file, d, g)
 	encrypted := security.EncryptFile(file, password, fmt.Sprintf("%s.tmp", file))
@@ -282,19 +300,40 @@ func getSecrets(file string, d string, g string) ([]models.Secret, error) {
 func GetSecret(file string, password string, d string, g string, s int) (models.Secret, error) {
 	decrypted := security.DecryptFile(file, password, fmt.Sprintf("%s.tmp", file))
 	if !decrypted {
-		return models.Secret{}, fmt.Errorf("wrong password")
+		return models.Secret{}, ErrWrongPassword
 	}
 	sct, err := getSecret(file, d, g, s)
 	encrypted := security.EncryptFile(file, password, fmt.Sprintf("%s.tmp", file))
 	if !encrypted {
 		return models.Secret{}, fmt.Errorf("error when encrypting file")
 	}
-	plaintext, err2 := security.DecryptText(password, sct.Password)
-	if err2 != nil {
-		return models.Secret{}, err2
+	if err != nil {
+		return models.Secret{}, err
 	}
-	sct.Password = plaintext
-	return sct, err
+	return decryptSecret(password, sct)
+}
+
+func decryptSecret(password string, s models.Secret) (models.Secret, error) {
+	plaintext, err := security.DecryptText(password, s.Password)
+	if err != nil {
+		return models.Secret{}, err
+	}
+	s.Password = plaintext
+	if s.Note != nil {
+		note, err2 := security.DecryptText(password, s.Note)
+		if err2 != nil {
+			return models.Secret{}, err2
+		}
+		s.Note = note
+	}
+	if s.Fields != nil {
+		fields, err3 := security.DecryptText(password, s.Fields)
+		if err3 != nil {
+			return models.Secret{}, err3
+		}
+		s.Fields = fields
+	}
+	return s, nil
 }
 
 func getSecret(file string, d string, g string, s int) (models.Secret, error) {
@@ -322,7 +361,7 @@ func getSecret(file string, d string, g string, s int) (models.Secret, error) {
 func CreateSecretGroup(file string, password string, d string, name string) (models.SecretGroup, error) {
 	decrypted := security.DecryptFile(file, password, fmt.Sprintf("%s.tmp", file))
 	if !decrypted {
-		return models.SecretGroup{}, fmt.Errorf("wrong password")
+		return models.SecretGroup{}, ErrWrongPassword
 	}
 	sg, err := createSecretGroup(file, d, name)
 	encrypted := security.EncryptFile(file, password, fmt.Sprintf("%s.tmp", file))
@@ -361,7 +400,7 @@ func createSecretGroup(file string, d string, name string) (models.SecretGroup,
 func GetSecretGroup(file string, password string, d string, g string) (models.SecretGroup, error) {
 	decrypted := security.DecryptFile(file, password, fmt.Sprintf("%s.tmp", file))
 	if !decrypted {
-		return models.SecretGroup{}, fmt.Errorf("wrong password")
+		return models.SecretGroup{}, ErrWrongPassword
 	}
 	sg, err := getSecretGroup(file, d, g)
 	encrypted := security.EncryptFile(file, password, fmt.Sprintf("%s.tmp", file))
@@ -392,7 +431,7 @@ func getSecretGroup(file string, d string, g string) (models.SecretGroup, error)
 func UpdateSecretGroup(file string, password string, d string, g string, name string) (models.SecretGroup, error) {
 	decrypted := security.DecryptFile(file, password, fmt.Sprintf("%s.tmp", file))
 	if !decrypted {
-		return models.SecretGroup{}, fmt.Errorf("wrong password")
+		return models.SecretGroup{}, ErrWrongPassword
 	}
 	sg, err := updateSecretGroup(file, d, g, name)
 	encrypted := security.EncryptFile(file, password, fmt.Sprintf("%s.tmp", file))
@@ -431,14 +470,13 @@ func updateSecretGroup(file string, d string, g string, name string) (models.Sec
 }
 
 func CreateSecret(file string, password string, d string, g string, s models.Secret) (models.Secret, error) {
-	ciphertext, err := security.EncryptText(password, string(s.Password))
-	if err != nil || ciphertext == nil {
+	s, err := encryptSecret(password, s)
+	if err != nil {
 		return models.Secret{}, err
 	}
-	s.Password = ciphertext
 	decrypted := security.DecryptFile(file, password, fmt.Sprintf("%s.tmp", file))
 	if !decrypted {
-		return models.Secret{}, fmt.Errorf("wrong password")
+		return models.Secret{}, ErrWrongPassword
 	}
 	sct, err := createSecret(file, d, g, s)
 	encrypted := security.EncryptFile(file, password, fmt.Sprintf("%s.tmp", file))
@@ -448,6 +486,32 @@ func CreateSecret(file string, password string, d string, g string, s models.Sec
 	return sct, err
 }
 
+func encryptSecret(password string, s models.Secret) (models.Secret, error) {
+	if s.Type == "" {
+		s.Type = models.SecretTypeLogin
+	}
+	ciphertext, err := security.EncryptText(password, string(s.Password))
+	if err != nil || ciphertext == nil {
+		return models.Secret{}, err
+	}
+	s.Password = ciphertext
+	if s.Note != nil {
+		note, err2 := security.EncryptText(password, string(s.Note))
+		if err2 != nil {
+			return models.Secret{}, err2
+		}
+		s.Note = note
+	}
+	if s.Fields != nil {
+		fields, err3 := security.EncryptText(password, string(s.Fields))
+		if err3 != nil {
+			return models.Secret{}, err3
+		}
+		s.Fields = fields
+	}
+	return s, nil
+}
+
 func createSecret(file string, d string, g string, s models.Secret) (models.Secret, error) {
 	log.Println("Create secret")
 	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("%s.tmp", file)), &gorm.Config{})
@@ -473,14 +537,13 @@ func createSecret(file string, d string, g string, s models.Secret) (models.Secr
 }
 
 func UpdateSecret(file string, password string, d string, g string, id int, s models.Secret) (models.Secret, error) {
-	ciphertext, err := security.EncryptText(password, string(s.Password))
-	if err != nil || ciphertext == nil {
+	s, err := encryptSecret(password, s)
+	if err != nil {
 		return models.Secret{}, err
 	}
-	s.Password = ciphertext
 	decrypted := security.DecryptFile(file, password, fmt.Sprintf("%s.tmp", file))
 	if !decrypted {
-		return models.Secret{}, fmt.Errorf("wrong password")
+		return models.Secret{}, ErrWrongPassword
 	}
 	sct, err := updateSecret(file, d, g, id, s)
 	encrypted := security.EncryptFile(file, password, fmt.Sprintf("%s.tmp", file))
@@ -504,9 +567,12 @@ func updateSecret(file string, d string, g string, id int, s models.Secret) (mod
 		if group.Name == g {
 			for _, secret := range group.Secrets {
 				if secret.ID == id {
+					secret.Type = s.Type
 					secret.Title = s.Title
 					secret.Username = s.Username
 					secret.Password = s.Password
+					secret.Note = s.Note
+					secret.Fields = s.Fields
 					secret.URL = s.URL
 					secret.Description = s.Description
 					currentTime := time.Now()
@@ -521,10 +587,61 @@ func updateSecret(file string, d string, g string, id int, s models.Secret) (mod
 	return models.Secret{}, fmt.Errorf("secret group not found")
 }
 
+func MoveSecret(file string, password string, d string, g string, id int, nd string, ng string) (models.Secret, error) {
+	decrypted := security.DecryptFile(file, password, fmt.Sprintf("%s.tmp", file))
+	if !decrypted {
+		return models.Secret{}, ErrWrongPassword
+	}
+	sct, err := moveSecret(file, d, g, id, nd, ng)
+	encrypted := security.EncryptFile(file, password, fmt.Sprintf("%s.tmp", file))
+	if !encrypted {
+		return models.Secret{}, fmt.Errorf("error when encrypting file")
+	}
+	return sct, err
+}
+
+func moveSecret(file string, d string, g string, id int, nd string, ng string) (models.Secret, error) {
+	log.Println("Move secret")
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("%s.tmp", file)), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return models.Secret{}, err
+	}
+	defer cleanup(db)
+	var target models.Database
+	db.Preload("SecretGroups").First(&target, "name = ?", nd)
+	targetID := 0
+	for _, group := range target.SecretGroups {
+		if group.Name == ng {
+			targetID = group.ID
+		}
+	}
+	if targetID == 0 {
+		return models.Secret{}, fmt.Errorf("secret group not found")
+	}
+	var database models.Database
+	db.Preload("SecretGroups.Secrets").First(&database, "name = ?", d)
+	for _, group := range database.SecretGroups {
+		if group.Name == g {
+			for _, secret := range group.Secrets {
+				if secret.ID == id {
+					secret.SecretGroupID = targetID
+					currentTime := time.Now()
+					formattedTime := currentTime.Format("2006-01-02 15:04:05")
+					secret.Updated_at = formattedTime
+					db.Save(&secret)
+					return secret, nil
+				}
+			}
+		}
+	}
+	return models.Secret{}, fmt.Errorf("secret not found")
+}
+
 func DeleteSecret(file string, password string, d string, g string, id int) error {
 	decrypted := security.DecryptFile(file, password, fmt.Sprintf("%s.tmp", file))
 	if !decrypted {
-		return fmt.Errorf("wrong password")
+		return ErrWrongPassword
 	}
 	err := deleteSecret(file, d, g, id)
 	encrypted := security.EncryptFile(file, password, fmt.Sprintf("%s.tmp", file))
@@ -548,6 +665,7 @@ func deleteSecret(file string, d string, g string, id int) error {
 		if group.Name == g {
 			for _, secret := range group.Secrets {
 				if secret.ID == id {
+					deleteAttachments(db, file, secret.ID)
 					db.Delete(&secret)
 					return nil
 				}
@@ -560,7 +678,7 @@ func deleteSecret(file string, d string, g string, id int) error {
 func DeleteSecretGroup(file string, password string, d string, g string) error {
 	decrypted := security.DecryptFile(file, password, fmt.Sprintf("%s.tmp", file))
 	if !decrypted {
-		return fmt.Errorf("wrong password")
+		return ErrWrongPassword
 	}
 	err := deleteSecretGroup(file, d, g)
 	encrypted := security.EncryptFile(file, password, fmt.Sprintf("%s.tmp", file))
@@ -583,6 +701,7 @@ func deleteSecretGroup(file string, d string, g string) error {
 	for _, group := range database.SecretGroups {
 		if group.Name == g {
 			for _, secret := range group.Secrets {
+				deleteAttachments(db, file, secret.ID)
 				db.Delete(&secret)
 			}
 			db.Delete(&group)
@@ -595,7 +714,7 @@ func deleteSecretGroup(file string, d string, g string) error {
 func DeleteDatabase(file string, password string, d string) error {
 	decrypted := security.DecryptFile(file, password, fmt.Sprintf("%s.tmp", file))
 	if !decrypted {
-		return fmt.Errorf("wrong password")
+		return ErrWrongPassword
 	}
 	err := deleteDatabase(file, d)
 	encrypted := security.EncryptFile(file, password, fmt.Sprintf("%s.tmp", file))
@@ -617,6 +736,7 @@ func deleteDatabase(file string, d string) error {
 	db.Preload("SecretGroups.Secrets").First(&database, "name = ?", d)
 	for _, group := range database.SecretGroups {
 		for _, secret := range group.Secrets {
+			deleteAttachments(db, file, secret.ID)
 			db.Delete(&secret)
 		}
 		db.Delete(&group)
@@ -625,6 +745,170 @@ func deleteDatabase(file string, d string) error {
 	return nil
 }
 
+func ChangePassword(file string, password string, newPassword string) error {
+	decrypted := security.DecryptFile(file, password, fmt.Sprintf("%s.tmp", file))
+	if !decrypted {
+		return ErrWrongPassword
+	}
+	err := changePassword(file, password, newPassword)
+	if err != nil {
+		encrypted := security.EncryptFile(file, password, fmt.Sprintf("%s.tmp", file))
+		if !encrypted {
+			return fmt.Errorf("error when encrypting file")
+		}
+		return err
+	}
+	encrypted := security.EncryptFile(file, newPassword, fmt.Sprintf("%s.tmp", file))
+	if !encrypted {
+		return fmt.Errorf("error when encrypting file")
+	}
+	return nil
+}
+
+func changePassword(file string, password string, newPassword string) error {
+	log.Println("Change master password")
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("%s.tmp", file)), &gorm.Config{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	defer cleanup(db)
+	err = migrate(db)
+	if err != nil {
+		return err
+	}
+	var rekeyed [][2]string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var secrets []models.Secret
+		if result := tx.Find(&secrets); result.Error != nil {
+			return result.Error
+		}
+		for _, secret := range secrets {
+			plaintext, err2 := decryptSecret(password, secret)
+			if err2 != nil {
+				return err2
+			}
+			ciphertext, err3 := encryptSecret(newPassword, plaintext)
+			if err3 != nil {
+				return err3
+			}
+			result := tx.Model(&secret).Updates(map[string]interface{}{
+				"password": ciphertext.Password,
+				"note":     ciphertext.Note,
+				"fields":   ciphertext.Fields,
+			})
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+		var hashes []string
+		if result := tx.Model(&models.Attachment{}).Distinct().Pluck("hash", &hashes); result.Error != nil {
+			return result.Error
+		}
+		for _, hash := range hashes {
+			newHash, err4 := reencryptChunks(file, password, newPassword, hash)
+			if err4 != nil {
+				return err4
+			}
+			rekeyed = append(rekeyed, [2]string{hash, newHash})
+			result := tx.Model(&models.Attachment{}).Where("hash = ?", hash).Update("hash", newHash)
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+		return nil
+	})
+	for _, pair := range rekeyed {
+		stale := pair[0]
+		if err != nil {
+			stale = pair[1]
+		}
+		if err2 := removeChunks(file, stale); err2 != nil {
+			log.Println(err2)
+		}
+	}
+	return err
+}
+
+func SearchSecrets(file string, password string, query string) ([]models.Entry, error) {
+	databases, err := GetAllDatabases(file, password)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Search secrets")
+	query = strings.ToLower(query)
+	var entries []models.Entry
+	for _, database := range databases {
+		for _, group := range database.SecretGroups {
+			for _, secret := range group.Secrets {
+				fields := []string{secret.Title, secret.Username, secret.URL, secret.Description}
+				if secret.Note != nil {
+					note, err2 := security.DecryptText(password, secret.Note)
+					if err2 != nil {
+						return nil, err2
+					}
+					fields = append(fields, string(note))
+				}
+				if secret.Fields != nil {
+					plaintext, err3 := security.DecryptText(password, secret.Fields)
+					if err3 != nil {
+						return nil, err3
+					}
+					values := DecodeFields(models.Secret{Fields: plaintext})
+					for _, field := range models.SecretFields[secret.Type] {
+						if !field.Hidden {
+							fields = append(fields, values[field.Name])
+						}
+					}
+				}
+				for _, field := range fields {
+					if strings.Contains(strings.ToLower(field), query) {
+						entries = append(entries, models.Entry{
+							Database: database.Name,
+							Group:    group.Name,
+							Secret:   secret,
+						})
+						break
+					}
+				}
+			}
+		}
+	}
+	return entries, nil
+}
+
+func BreachReport(file string, password string, provider security.BreachProvider) ([]models.Breach, error) {
+	databases, err := GetAllDatabases(file, password)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Check secrets against breach corpus")
+	var breaches []models.Breach
+	for _, database := range databases {
+		for _, group := range database.SecretGroups {
+			for _, secret := range group.Secrets {
+				plaintext, err2 := security.DecryptText(password, secret.Password)
+				if err2 != nil {
+					return nil, err2
+				}
+				count, err3 := security.CheckBreached(provider, string(plaintext))
+				if err3 != nil {
+					return nil, err3
+				}
+				if count > 0 {
+					breaches = append(breaches, models.Breach{
+						Database: database.Name,
+						Group:    group.Name,
+						Secret:   secret,
+						Count:    count,
+					})
+				}
+			}
+		}
+	}
+	return breaches, nil
+}
+
 func ValidateEmail(email string) bool {
 	emailRegex := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
 	return emailRegex.MatchString(email)
@@ -697,16 +981,26 @@ func getChallenge(url string) (*http.Response, error) {
 	return client.Do(req)
 }
 
+func clientProof(secret string, method string, path string, timestamp string, challenge string, body []byte) string {
+	hash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join([]string{method, path, timestamp, challenge, hex.EncodeToString(hash[:])}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
 func SendRequest(url string, reqType string, body []byte, token string) (*http.Response, error) {
 	var challenge string = ""
-	var hashString string = ""
-	if strings.Contains(url, "/login") || strings.Contains(url, "/register") {
+	if token == "" && (strings.Contains(url, "/login") || strings.Contains(url, "/register") || strings.Contains(url, "/password/") || strings.Contains(url, "/verify/")) {
 		re, er := getChallenge(url)
 		if er != nil {
 			log.Println(er)
 			return nil, er
 		}
 		defer re.Body.Close()
+		if re.StatusCode != http.StatusOK {
+			log.Println("Challenge request failed with", re.Status)
+			return nil, fmt.Errorf("failed to get a challenge from the server: %s", re.Status)
+		}
 		body, err := io.ReadAll(re.Body)
 		if err != nil {
 			log.Println(err)
@@ -718,43 +1012,132 @@ func SendRequest(url string, reqType string, body []byte, token string) (*http.R
 			log.Println(err2)
 			return nil, err2
 		}
-		challenge = data["challenge"].(string)
-		hash := sha256.Sum256([]byte(challenge + models.Password))
-		hashString = hex.EncodeToString(hash[:])
-	}
-	req, err := http.NewRequest(reqType, url, bytes.NewBuffer(body))
-	if err != nil {
-		log.Println(err)
-		return nil, err
+		var ok bool
+		challenge, ok = data["challenge"].(string)
+		if !ok || challenge == "" {
+			log.Println("Challenge missing in server response")
+			return nil, fmt.Errorf("server sent no challenge")
+		}
 	}
-	req.Header.Set("X-Auth-Challenge", challenge)
-	req.Header.Set("X-Auth-Hash", hashString)
-	req.Header.Set("Content-Type", "application/json")
-	if token != "" {
-		req.Header.Set("Authorization", token)
+	return send(func(token string) (*http.Request, error) {
+		req, err := http.NewRequest(reqType, url, bytes.NewBuffer(body))
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		if challenge != "" {
+			timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+			req.Header.Set("X-Auth-Client", models.Client)
+			req.Header.Set("X-Auth-Challenge", challenge)
+			req.Header.Set("X-Auth-Timestamp", timestamp)
+			req.Header.Set("X-Auth-Hash", clientProof(models.Password, reqType, req.URL.Path, timestamp, challenge, body))
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		return req, nil
+	}, token)
+}
+
+func SendRequest2(url string, reqType string, body *bytes.Buffer, cont string, token string) (*http.Response, error) {
+	data := body.Bytes()
+	return send(func(token string) (*http.Request, error) {
+		req, err := http.NewRequest(reqType, url, bytes.NewBuffer(data))
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		req.Header.Set("Content-Type", cont)
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		return req, nil
+	}, token)
+}
+
+func send(request func(token string) (*http.Request, error), token string) (*http.Response, error) {
+	if token != "" && models.AccessToken != "" {
+		token = models.AccessToken
 	}
 	tr := &http.Transport{
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 	}
 	client := &http.Client{Transport: tr}
-	return client.Do(req)
+	req, err := request(token)
+	if err != nil {
+		return nil, err
+	}
+	resp, err2 := client.Do(req)
+	if err2 != nil || resp.StatusCode != http.StatusUnauthorized || token == "" || models.RefreshToken == "" {
+		return resp, err2
+	}
+	body, err3 := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err3 != nil {
+		log.Println(err3)
+		return nil, err3
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	fresh, err4 := RefreshSession(token)
+	if err4 != nil {
+		log.Println(err4)
+		return resp, nil
+	}
+	req2, err5 := request(fresh)
+	if err5 != nil {
+		return nil, err5
+	}
+	return client.Do(req2)
 }
 
-func SendRequest2(url string, reqType string, body *bytes.Buffer, cont string, token string) (*http.Response, error) {
-	req, err := http.NewRequest(reqType, url, body)
+func RefreshSession(stale string) (string, error) {
+	refreshMutex.Lock()
+	defer refreshMutex.Unlock()
+	if models.AccessToken != "" && models.AccessToken != stale {
+		return models.AccessToken, nil
+	}
+	if models.RefreshToken == "" {
+		return "", ErrSessionExpired
+	}
+	body, err := json.Marshal(map[string]string{"refresh_token": models.RefreshToken})
 	if err != nil {
 		log.Println(err)
-		return nil, err
+		return "", err
 	}
-	req.Header.Set("Content-Type", cont)
-	if token != "" {
-		req.Header.Set("Authorization", token)
+	req, err2 := http.NewRequest("POST", fmt.Sprintf("%s/token/refresh", models.Url), bytes.NewBuffer(body))
+	if err2 != nil {
+		log.Println(err2)
+		return "", err2
 	}
+	req.Header.Set("Content-Type", "application/json")
 	tr := &http.Transport{
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 	}
 	client := &http.Client{Transport: tr}
-	return client.Do(req)
+	resp, err3 := client.Do(req)
+	if err3 != nil {
+		log.Println(err3)
+		return "", err3
+	}
+	defer resp.Body.Close()
+	var data map[string]interface{}
+	err4 := json.NewDecoder(resp.Body).Decode(&data)
+	if err4 != nil {
+		log.Println(err4)
+		return "", err4
+	}
+	token, ok := data["token"].(string)
+	refresh, ok2 := data["refresh_token"].(string)
+	if resp.StatusCode != http.StatusOK || !ok || !ok2 {
+		log.Println("Error when refreshing session")
+		models.AccessToken = ""
+		models.RefreshToken = ""
+		return "", ErrSessionExpired
+	}
+	models.AccessToken = token
+	models.RefreshToken = refresh
+	return token, nil
 }
 
 func GetSettings(user *models.User) error {
@@ -778,8 +1161,10 @@ func GetSettings(user *models.User) error {
 	if resp.StatusCode == 200 {
 		verified := data["verified"].(bool)
 		totp := data["totp"].(bool)
+		codes, _ := data["recovery_codes"].(float64)
 		user.Verified = verified
 		user.Totp = totp
+		user.RecoveryCodes = int(codes)
 	} else {
 		log.Println("Error when getting settings")
 		log.Println(data["message"].(string))
@@ -788,6 +1173,58 @@ func GetSettings(user *models.User) error {
 	return nil
 }
 
+func GetSessions(user *models.User) ([]models.Session, error) {
+	resp, err := SendRequest(fmt.Sprintf("%s/sessions", models.Url), "GET", nil, user.Token)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var data struct {
+		Message  string           `json:"message"`
+		Sessions []models.Session `json:"sessions"`
+	}
+	err2 := json.NewDecoder(resp.Body).Decode(&data)
+	if err2 != nil {
+		log.Println(err2)
+		return nil, err2
+	}
+	if resp.StatusCode != 200 {
+		log.Println("Error when getting sessions")
+		log.Println(data.Message)
+		return nil, fmt.Errorf("error when getting sessions")
+	}
+	return data.Sessions, nil
+}
+
+func RevokeSession(user *models.User, id string) error {
+	resp, err := SendRequest(fmt.Sprintf("%s/sessions/%s", models.Url, url.PathEscape(id)), "DELETE", nil, user.Token)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		log.Println("Error when revoking session")
+		return fmt.Errorf("error when revoking session")
+	}
+	return nil
+}
+
+func Logout(user *models.User) error {
+	resp, err := SendRequest(fmt.Sprintf("%s/logout", models.Url), "POST", nil, user.Token)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		log.Println("Error when logging out")
+		return fmt.Errorf("error when logging out")
+	}
+	return nil
+}
+
 func Save(user *models.User, file string) error {
 	f, err := os.Open(file)
 	if err != nil {
@@ -836,7 +1273,7 @@ func Save(user *models.User, file string) error {
 		log.Println(data["message"].(string))
 		return fmt.Errorf("error when saving")
 	}
-	return nil
+	return uploadAttachments(user, file)
 }
 
 func Sync(user *models.User, file string) error {
@@ -861,7 +1298,7 @@ func Sync(user *models.User, file string) error {
 		log.Println("Error when syncing")
 		return fmt.Errorf("error when syncing")
 	}
-	return nil
+	return downloadAttachments(user, file)
 }
 
 func IsPasswordSecure(password string) bool {
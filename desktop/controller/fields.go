@@ -0,0 +1,192 @@
+package controller
+
+import (
+	"desktop/models"
+	"desktop/security"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+)
+
+func DecodeFields(secret models.Secret) map[string]string {
+	fields := map[string]string{}
+	if secret.Fields == nil {
+		return fields
+	}
+	err := json.Unmarshal(secret.Fields, &fields)
+	if err != nil {
+		log.Println(err)
+		return map[string]string{}
+	}
+	return fields
+}
+
+func EncodeFields(fields map[string]string) []byte {
+	data, err := json.Marshal(fields)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	return data
+}
+
+func ValidateLuhn(number string) bool {
+	number = strings.ReplaceAll(strings.ReplaceAll(number, " ", ""), "-", "")
+	if len(number) < 12 || len(number) > 19 {
+		return false
+	}
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		if number[i] < '0' || number[i] > '9' {
+			return false
+		}
+		digit := int(number[i] - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+func ValidateIBAN(iban string) bool {
+	iban = strings.ToUpper(strings.ReplaceAll(iban, " ", ""))
+	ibanRegex := regexp.MustCompile(`^[A-Z]{2}[0-9]{2}[A-Z0-9]{11,30}$`)
+	if !ibanRegex.MatchString(iban) {
+		return false
+	}
+	rearranged := iban[4:] + iban[:4]
+	var digits strings.Builder
+	for _, character := range rearranged {
+		if character >= 'A' && character <= 'Z' {
+			digits.WriteString(fmt.Sprint(character - 'A' + 10))
+		} else {
+			digits.WriteRune(character)
+		}
+	}
+	n, ok := new(big.Int).SetString(digits.String(), 10)
+	if !ok {
+		return false
+	}
+	return new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+func ValidateBIC(bic string) bool {
+	bicRegex := regexp.MustCompile(`^[A-Z]{4}[A-Z]{2}[A-Z0-9]{2}([A-Z0-9]{3})?$`)
+	return bicRegex.MatchString(strings.ToUpper(strings.ReplaceAll(bic, " ", "")))
+}
+
+func ValidateExpiry(expiry string) bool {
+	_, ok := ParseExpiry(models.FieldExpiry, expiry)
+	return ok
+}
+
+func ParseExpiry(kind string, value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	switch kind {
+	case models.FieldExpiry:
+		t, err := time.Parse("01/06", value)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return t.AddDate(0, 1, 0), true
+	case models.FieldDate:
+		t, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+func ValidateField(field models.Field, value string) bool {
+	if value == "" {
+		return true
+	}
+	switch field.Kind {
+	case models.FieldCard:
+		return ValidateLuhn(value)
+	case models.FieldExpiry, models.FieldDate:
+		_, ok := ParseExpiry(field.Kind, value)
+		return ok
+	case models.FieldDigits:
+		digitRegex := regexp.MustCompile(`^[0-9]{3,12}$`)
+		return digitRegex.MatchString(value)
+	case models.FieldIBAN:
+		return ValidateIBAN(value)
+	case models.FieldBIC:
+		return ValidateBIC(value)
+	}
+	return true
+}
+
+func ValidateFields(kind string, fields map[string]string) error {
+	for _, field := range models.SecretFields[kind] {
+		if !ValidateField(field, fields[field.Name]) {
+			return fmt.Errorf("invalid %s", strings.ToLower(field.Label))
+		}
+	}
+	return nil
+}
+
+func ExpiryWarning(secret models.Secret, within time.Duration) string {
+	fields := DecodeFields(secret)
+	now := time.Now()
+	for _, field := range models.SecretFields[secret.Type] {
+		if field.Kind != models.FieldExpiry && !strings.HasSuffix(field.Name, "expiry") {
+			continue
+		}
+		expires, ok := ParseExpiry(field.Kind, fields[field.Name])
+		if !ok {
+			continue
+		}
+		if expires.Before(now) {
+			return fmt.Sprintf("Expired on %s!", expires.Format("2006-01-02"))
+		}
+		if expires.Before(now.Add(within)) {
+			return fmt.Sprintf("Expires in %d days!", int(expires.Sub(now).Hours()/24)+1)
+		}
+	}
+	return ""
+}
+
+func ExpiringSecrets(file string, password string, within time.Duration) ([]models.Entry, error) {
+	databases, err := GetAllDatabases(file, password)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Check secrets for expiry")
+	var entries []models.Entry
+	for _, database := range databases {
+		for _, group := range database.SecretGroups {
+			for _, secret := range group.Secrets {
+				if secret.Fields == nil {
+					continue
+				}
+				fields, err2 := security.DecryptText(password, secret.Fields)
+				if err2 != nil {
+					return nil, err2
+				}
+				secret.Fields = fields
+				if ExpiryWarning(secret, within) != "" {
+					entries = append(entries, models.Entry{
+						Database: database.Name,
+						Group:    group.Name,
+						Secret:   secret,
+					})
+				}
+			}
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,81 @@
+package controller
+
+import (
+	"desktop/models"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+func FuzzyScore(text string, query string) int {
+	text = strings.ToLower(text)
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" || text == "" {
+		return 0
+	}
+	runes := []rune(text)
+	score := 0
+	last := -2
+	i := 0
+	for _, q := range query {
+		if unicode.IsSpace(q) {
+			continue
+		}
+		for i < len(runes) && runes[i] != q {
+			i++
+		}
+		if i == len(runes) {
+			return 0
+		}
+		score++
+		if i == last+1 {
+			score += 5
+		}
+		if i == 0 || !unicode.IsLetter(runes[i-1]) && !unicode.IsDigit(runes[i-1]) {
+			score += 10
+		}
+		last = i
+		i++
+	}
+	if strings.Contains(text, query) {
+		score += 20
+	}
+	return score
+}
+
+func FuzzySearch(databases []models.Database, query string) []models.Entry {
+	type match struct {
+		entry models.Entry
+		score int
+	}
+	var matches []match
+	for _, database := range databases {
+		for _, group := range database.SecretGroups {
+			for _, secret := range group.Secrets {
+				best := 2 * FuzzyScore(secret.Title, query)
+				for _, field := range []string{secret.Username, secret.URL, secret.Description, group.Name, database.Name} {
+					if score := FuzzyScore(field, query); score > best {
+						best = score
+					}
+				}
+				if best > 0 {
+					matches = append(matches, match{
+						entry: models.Entry{Database: database.Name, Group: group.Name, Secret: secret},
+						score: best,
+					})
+				}
+			}
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return strings.ToLower(matches[i].entry.Secret.Title) < strings.ToLower(matches[j].entry.Secret.Title)
+	})
+	entries := []models.Entry{}
+	for _, m := range matches {
+		entries = append(entries, m.entry)
+	}
+	return entries
+}
@@ -0,0 +1,238 @@
+package controller
+
+import (
+	"desktop/models"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+const AndroidScheme = "androidapp"
+
+const (
+	ScoreDomain = 1000
+	ScoreHost   = 2000
+	ScorePrefix = 3000
+)
+
+func parseURL(value string) (*url.URL, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, fmt.Errorf("empty url")
+	}
+	if !strings.Contains(value, "://") {
+		value = "https://" + value
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return nil, fmt.Errorf("url %s has no host", value)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	port := u.Port()
+	if (u.Scheme == "https" && port == "443") || (u.Scheme == "http" && port == "80") {
+		port = ""
+	}
+	switch {
+	case port != "":
+		u.Host = net.JoinHostPort(host, port)
+	case strings.Contains(host, ":"):
+		u.Host = "[" + host + "]"
+	default:
+		u.Host = host
+	}
+	u.User = nil
+	u.Fragment = ""
+	u.RawFragment = ""
+	if u.Path == "" && u.Scheme != AndroidScheme {
+		u.Path = "/"
+	}
+	return u, nil
+}
+
+func NormalizeURL(value string) (string, error) {
+	u, err := parseURL(value)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func BaseDomain(host string) string {
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return domain
+}
+
+func SecretURLs(secret models.Secret) []string {
+	var urls []string
+	if value := strings.TrimSpace(secret.URL); value != "" {
+		urls = append(urls, value)
+	}
+	for _, line := range strings.Split(DecodeFields(secret)["urls"], "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			urls = append(urls, line)
+		}
+	}
+	return urls
+}
+
+func SecretMatch(secret models.Secret) string {
+	if mode := DecodeFields(secret)["match"]; mode != "" {
+		return mode
+	}
+	return models.MatchDomain
+}
+
+func ValidateMatch(mode string, urls []string) error {
+	switch mode {
+	case "", models.MatchDomain, models.MatchHost, models.MatchStartsWith:
+		for _, value := range urls {
+			if _, err := parseURL(value); err != nil {
+				return fmt.Errorf("invalid url %s", value)
+			}
+		}
+	case models.MatchRegex:
+		for _, value := range urls {
+			if _, err := regexp.Compile(value); err != nil {
+				return fmt.Errorf("invalid regular expression %s", value)
+			}
+		}
+	case models.MatchNever:
+	default:
+		return fmt.Errorf("unknown match mode %s, expected one of %s", mode, strings.Join(models.MatchModes, ", "))
+	}
+	return nil
+}
+
+func matchURL(mode string, value string, target string) int {
+	if mode == models.MatchRegex {
+		re, err := regexp.Compile(value)
+		if err != nil {
+			log.Println(err)
+			return 0
+		}
+		if re.MatchString(target) {
+			return ScorePrefix
+		}
+		return 0
+	}
+	u, err := parseURL(value)
+	if err != nil {
+		return 0
+	}
+	t, err2 := parseURL(target)
+	if err2 != nil {
+		return 0
+	}
+	if u.Scheme == AndroidScheme || t.Scheme == AndroidScheme {
+		if u.Scheme == t.Scheme && u.Host == t.Host {
+			return ScoreHost
+		}
+		return 0
+	}
+	if u.Scheme != t.Scheme && !(u.Scheme == "http" && t.Scheme == "https") {
+		return 0
+	}
+	switch mode {
+	case models.MatchStartsWith:
+		if strings.HasPrefix(t.String(), u.String()) {
+			return ScorePrefix + len(u.String())
+		}
+		return 0
+	case models.MatchHost:
+		if u.Host != t.Host {
+			return 0
+		}
+	default:
+		if BaseDomain(u.Hostname()) != BaseDomain(t.Hostname()) {
+			return 0
+		}
+	}
+	score := ScoreDomain
+	if u.Host == t.Host {
+		score = ScoreHost
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	if path != "" && (t.Path == path || strings.HasPrefix(t.Path, path+"/")) {
+		score += len(path)
+	}
+	return score
+}
+
+func MatchURL(secret models.Secret, target string) int {
+	if secret.Type != models.SecretTypeLogin && secret.Type != "" {
+		return 0
+	}
+	mode := SecretMatch(secret)
+	if mode == models.MatchNever {
+		return 0
+	}
+	best := 0
+	for _, value := range SecretURLs(secret) {
+		if score := matchURL(mode, value, target); score > best {
+			best = score
+		}
+	}
+	return best
+}
+
+func (r *Resolver) FindByURL(target string) ([]models.Entry, error) {
+	if _, err := parseURL(target); err != nil {
+		return nil, fmt.Errorf("invalid url %s: %w", target, err)
+	}
+	type match struct {
+		entry models.Entry
+		score int
+	}
+	var matches []match
+	for _, database := range r.databases {
+		for _, group := range database.SecretGroups {
+			for _, secret := range group.Secrets {
+				if secret.Type != models.SecretTypeLogin && secret.Type != "" {
+					continue
+				}
+				decrypted, err := decryptSecret(r.password, secret)
+				if err != nil {
+					return nil, err
+				}
+				if score := MatchURL(decrypted, target); score > 0 {
+					matches = append(matches, match{
+						entry: models.Entry{Database: database.Name, Group: group.Name, Secret: decrypted},
+						score: score,
+					})
+				}
+			}
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score > matches[j].score
+	})
+	entries := []models.Entry{}
+	for _, m := range matches {
+		entries = append(entries, m.entry)
+	}
+	return entries, nil
+}
+
+func FindByURL(file string, password string, target string) ([]models.Entry, error) {
+	resolver, err := NewResolver(file, password)
+	if err != nil {
+		return nil, err
+	}
+	return resolver.FindByURL(target)
+}
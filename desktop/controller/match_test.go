@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"desktop/models"
+	"strings"
+	"testing"
+)
+
+func TestMatchURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   string
+		urls   []string
+		target string
+		want   int
+	}{
+		{"same host", models.MatchDomain, []string{"https://example.com"}, "https://example.com/login", ScoreHost},
+		{"subdomain", models.MatchDomain, []string{"example.com"}, "https://accounts.example.com/", ScoreDomain},
+		{"other domain", models.MatchDomain, []string{"example.com"}, "https://example.org/", 0},
+		{"suffix of another domain", models.MatchDomain, []string{"example.com"}, "https://badexample.com/", 0},
+		{"path", models.MatchDomain, []string{"https://example.com/app"}, "https://example.com/app/login", ScoreHost + len("/app")},
+		{"multi-part tld", models.MatchDomain, []string{"https://shop.example.co.uk"}, "https://www.example.co.uk/", ScoreDomain},
+		{"other name under multi-part tld", models.MatchDomain, []string{"example.co.uk"}, "https://other.co.uk/", 0},
+		{"public suffix only", models.MatchDomain, []string{"https://a.github.io"}, "https://b.github.io/", 0},
+		{"ip host", models.MatchDomain, []string{"http://192.168.1.1"}, "http://192.168.1.1/admin", ScoreHost},
+		{"other ip host", models.MatchDomain, []string{"http://192.168.1.1"}, "http://192.168.1.2/", 0},
+		{"ipv6 host", models.MatchDomain, []string{"https://[::1]:8443"}, "https://[::1]:8443/", ScoreHost},
+		{"default port", models.MatchHost, []string{"https://example.com:443"}, "https://example.com/", ScoreHost},
+		{"other port", models.MatchHost, []string{"https://example.com:8443"}, "https://example.com/", 0},
+		{"https to http", models.MatchDomain, []string{"https://example.com"}, "http://example.com/", 0},
+		{"bare url to http", models.MatchDomain, []string{"example.com"}, "http://example.com/", 0},
+		{"http to https", models.MatchDomain, []string{"http://example.com"}, "https://example.com/", ScoreHost},
+		{"other scheme", models.MatchHost, []string{"https://example.com"}, "ftp://example.com/", 0},
+		{"host", models.MatchHost, []string{"example.com"}, "https://example.com/", ScoreHost},
+		{"host rejects subdomain", models.MatchHost, []string{"example.com"}, "https://www.example.com/", 0},
+		{"host https to http", models.MatchHost, []string{"https://example.com"}, "http://example.com/", 0},
+		{"starts with", models.MatchStartsWith, []string{"https://example.com/app"}, "https://example.com/app/login", ScorePrefix + len("https://example.com/app")},
+		{"starts with on a host boundary", models.MatchStartsWith, []string{"https://example.com"}, "https://example.com.evil.io/", 0},
+		{"starts with another scheme", models.MatchStartsWith, []string{"https://example.com"}, "http://example.com/", 0},
+		{"android app", models.MatchDomain, []string{"androidapp://com.example.app"}, "androidapp://com.example.app", ScoreHost},
+		{"other android app", models.MatchDomain, []string{"androidapp://com.example.app"}, "androidapp://com.example.other", 0},
+		{"android app against a site", models.MatchDomain, []string{"androidapp://com.example.app"}, "https://com.example.app/", 0},
+		{"site against an android app", models.MatchDomain, []string{"https://example.com"}, "androidapp://com.example", 0},
+		{"regex", models.MatchRegex, []string{`^https://(www\.)?example\.com/`}, "https://www.example.com/", ScorePrefix},
+		{"regex keeps its own scheme rules", models.MatchRegex, []string{`^https?://example\.com/`}, "http://example.com/", ScorePrefix},
+		{"regex mismatch", models.MatchRegex, []string{`^https://example\.com/`}, "https://example.org/", 0},
+		{"never", models.MatchNever, []string{"https://example.com"}, "https://example.com/", 0},
+		{"best of several urls", models.MatchDomain, []string{"example.org", "https://www.example.com"}, "https://www.example.com/", ScoreHost},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := models.Secret{
+				Type:   models.SecretTypeLogin,
+				URL:    tt.urls[0],
+				Fields: EncodeFields(map[string]string{"match": tt.mode, "urls": strings.Join(tt.urls[1:], "\n")}),
+			}
+			if got := MatchURL(secret, tt.target); got != tt.want {
+				t.Errorf("MatchURL(%s %v, %s) = %d, want %d", tt.mode, tt.urls, tt.target, got, tt.want)
+			}
+		})
+	}
+}
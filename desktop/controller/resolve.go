@@ -0,0 +1,206 @@
+package controller
+
+import (
+	"desktop/models"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrNotFound = errors.New("not found")
+var ErrAmbiguous = errors.New("ambiguous")
+var ErrExists = errors.New("already exists")
+
+const ReferencePrefix = "fp://"
+
+func SplitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.SplitN(path, "/", 3)
+}
+
+func FindDatabase(databases []models.Database, d string) (models.Database, error) {
+	for _, database := range databases {
+		if database.Name == d {
+			return database, nil
+		}
+	}
+	return models.Database{}, fmt.Errorf("sub database %s: %w", d, ErrNotFound)
+}
+
+func FindSecretGroup(databases []models.Database, d string, g string) (models.SecretGroup, error) {
+	database, err := FindDatabase(databases, d)
+	if err != nil {
+		return models.SecretGroup{}, err
+	}
+	for _, group := range database.SecretGroups {
+		if group.Name == g {
+			return group, nil
+		}
+	}
+	return models.SecretGroup{}, fmt.Errorf("secret group %s/%s: %w", d, g, ErrNotFound)
+}
+
+func FindSecret(databases []models.Database, path string) (models.Entry, error) {
+	parts := SplitPath(path)
+	if len(parts) != 3 {
+		return models.Entry{}, fmt.Errorf("invalid path %s, expected subdb/group/title", path)
+	}
+	group, err := FindSecretGroup(databases, parts[0], parts[1])
+	if err != nil {
+		return models.Entry{}, err
+	}
+	title := parts[2]
+	if strings.HasPrefix(title, "@") {
+		id, err2 := strconv.Atoi(title[1:])
+		if err2 == nil {
+			for _, secret := range group.Secrets {
+				if secret.ID == id {
+					return models.Entry{Database: parts[0], Group: parts[1], Secret: secret}, nil
+				}
+			}
+		}
+	}
+	var matches []models.Secret
+	for _, secret := range group.Secrets {
+		if secret.Title == title {
+			matches = append(matches, secret)
+		}
+	}
+	if len(matches) == 0 {
+		return models.Entry{}, fmt.Errorf("secret %s: %w", path, ErrNotFound)
+	}
+	if len(matches) > 1 {
+		var ids []string
+		for _, secret := range matches {
+			ids = append(ids, fmt.Sprintf("%s/%s/@%d", parts[0], parts[1], secret.ID))
+		}
+		return models.Entry{}, fmt.Errorf("secret %s: %w, matches %s", path, ErrAmbiguous, strings.Join(ids, ", "))
+	}
+	return models.Entry{Database: parts[0], Group: parts[1], Secret: matches[0]}, nil
+}
+
+func GetEntry(file string, password string, path string) (models.Entry, error) {
+	resolver, err := NewResolver(file, password)
+	if err != nil {
+		return models.Entry{}, err
+	}
+	return resolver.Entry(path)
+}
+
+type Resolver struct {
+	password  string
+	databases []models.Database
+}
+
+func NewResolver(file string, password string) (*Resolver, error) {
+	databases, err := GetAllDatabases(file, password)
+	if err != nil {
+		return nil, err
+	}
+	return &Resolver{password: password, databases: databases}, nil
+}
+
+func (r *Resolver) Databases() []models.Database {
+	return r.databases
+}
+
+func (r *Resolver) Entry(path string) (models.Entry, error) {
+	entry, err := FindSecret(r.databases, path)
+	if err != nil {
+		return models.Entry{}, err
+	}
+	entry.Secret, err = decryptSecret(r.password, entry.Secret)
+	if err != nil {
+		return models.Entry{}, err
+	}
+	return entry, nil
+}
+
+func (r *Resolver) Field(path string, field string) (string, error) {
+	entry, err := r.Entry(path)
+	if err != nil {
+		return "", err
+	}
+	value, err2 := SecretField(entry.Secret, field)
+	if err2 != nil {
+		return "", fmt.Errorf("%s: %w", path, err2)
+	}
+	return value, nil
+}
+
+func (r *Resolver) Reference(reference string) (string, error) {
+	path, field, err := ParseReference(reference)
+	if err != nil {
+		return "", err
+	}
+	return r.Field(path, field)
+}
+
+func ParseReference(reference string) (string, string, error) {
+	if !strings.HasPrefix(reference, ReferencePrefix) {
+		return "", "", fmt.Errorf("invalid reference %s, expected %ssubdb/group/title#field", reference, ReferencePrefix)
+	}
+	path := strings.TrimPrefix(reference, ReferencePrefix)
+	field := "password"
+	if i := strings.LastIndex(path, "#"); i >= 0 {
+		path, field = path[:i], path[i+1:]
+	}
+	if len(SplitPath(path)) != 3 || field == "" {
+		return "", "", fmt.Errorf("invalid reference %s, expected %ssubdb/group/title#field", reference, ReferencePrefix)
+	}
+	return path, field, nil
+}
+
+func SecretField(secret models.Secret, name string) (string, error) {
+	switch name {
+	case "title":
+		return secret.Title, nil
+	case "username":
+		return secret.Username, nil
+	case "password":
+		return string(secret.Password), nil
+	case "url":
+		return secret.URL, nil
+	case "notes", "description":
+		return secret.Description, nil
+	case "note":
+		return string(secret.Note), nil
+	case "type":
+		return secret.Type, nil
+	}
+	fields := DecodeFields(secret)
+	if value, ok := fields[name]; ok {
+		return value, nil
+	}
+	for _, field := range models.SecretFields[secret.Type] {
+		if field.Name == name {
+			return "", nil
+		}
+	}
+	return "", fmt.Errorf("field %s: %w", name, ErrNotFound)
+}
+
+func SecretFieldNames(secret models.Secret) []string {
+	names := []string{"title", "username", "password", "url", "notes"}
+	switch secret.Type {
+	case models.SecretTypeNote:
+		names = []string{"title", "note"}
+	case models.SecretTypeLogin, "":
+		fields := DecodeFields(secret)
+		for _, name := range []string{"urls", "match", "totp"} {
+			if _, ok := fields[name]; ok {
+				names = append(names, name)
+			}
+		}
+	default:
+		names = []string{"title", "notes"}
+		for _, field := range models.SecretFields[secret.Type] {
+			names = append(names, field.Name)
+		}
+	}
+	return names
+}
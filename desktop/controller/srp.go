@@ -0,0 +1,295 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"desktop/models"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+var srpN, _ = new(big.Int).SetString("AC6BDB41324A9A9BF166DE5E1389582FAF72B6651987EE07FC3192943DB56050A37329CBB4A099ED8193E0757767A13DD52312AB4B03310DCD7F48A9DA04FD50E8083969EDB767B0CF6095179A163AB3661A05FBD5FAAAE82918A9962F0B93B855F97993EC975EEAA80D740ADBF4FF747359D041D5C33EA71D281E446B14773BCA97B43A23FB801676BD207A436C6481F1D2B9078717461A5B9D32E688F87748544523B524B0D57D5EA77A2775D2ECFA032CFBDBF52FB3786160279004E57AE6AF874E7303CE53299CCC041C7BC308D82A5698F3A8D0C38271AE35F8E9DBFBB694B5C803D89F7AE435DE236D525F54759B65E372FCD68EF20FA7111F9E4AFF73", 16)
+var srpG = big.NewInt(2)
+var srpK = new(big.Int).SetBytes(srpHash(srpN.Bytes(), srpPad(srpG)))
+
+type srpClient struct {
+	secret *big.Int
+	public *big.Int
+	m2     []byte
+}
+
+func srpHash(parts ...[]byte) []byte {
+	hash := sha256.New()
+	for _, part := range parts {
+		hash.Write(part)
+	}
+	return hash.Sum(nil)
+}
+
+func srpPad(n *big.Int) []byte {
+	return n.FillBytes(make([]byte, len(srpN.Bytes())))
+}
+
+func srpX(password string, salt []byte) *big.Int {
+	key := argon2.IDKey([]byte(password), salt, 3, 64*1024, 4, 32)
+	return new(big.Int).SetBytes(srpHash(salt, key))
+}
+
+func NewVerifier(password string) (string, string, error) {
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	if err != nil {
+		log.Println(err)
+		return "", "", err
+	}
+	v := new(big.Int).Exp(srpG, srpX(password, salt), srpN)
+	return hex.EncodeToString(salt), hex.EncodeToString(srpPad(v)), nil
+}
+
+func newSrpClient() (*srpClient, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	a := new(big.Int).SetBytes(secret)
+	return &srpClient{secret: a, public: new(big.Int).Exp(srpG, a, srpN)}, nil
+}
+
+func (c *srpClient) A() string {
+	return hex.EncodeToString(srpPad(c.public))
+}
+
+func (c *srpClient) proof(username string, password string, salt string, server string) (string, error) {
+	rawSalt, err := hex.DecodeString(salt)
+	if err != nil || len(rawSalt) == 0 {
+		return "", fmt.Errorf("invalid salt from server")
+	}
+	rawB, err2 := hex.DecodeString(server)
+	if err2 != nil {
+		return "", fmt.Errorf("invalid srp value from server")
+	}
+	B := new(big.Int).SetBytes(rawB)
+	if new(big.Int).Mod(B, srpN).Sign() == 0 {
+		return "", fmt.Errorf("invalid srp value from server")
+	}
+	u := new(big.Int).SetBytes(srpHash(srpPad(c.public), srpPad(B)))
+	if u.Sign() == 0 {
+		return "", fmt.Errorf("invalid srp value from server")
+	}
+	x := srpX(password, rawSalt)
+	base := new(big.Int).Exp(srpG, x, srpN)
+	base.Mul(base, srpK)
+	base.Sub(B, base)
+	base.Mod(base, srpN)
+	exponent := new(big.Int).Mul(u, x)
+	exponent.Add(exponent, c.secret)
+	S := new(big.Int).Exp(base, exponent, srpN)
+
+	key := srpHash(srpPad(S))
+	hashN := srpHash(srpN.Bytes())
+	hashG := srpHash(srpPad(srpG))
+	for i := range hashN {
+		hashN[i] ^= hashG[i]
+	}
+	m1 := srpHash(hashN, srpHash([]byte(strings.ToLower(username))), rawSalt, srpPad(c.public), srpPad(B), key)
+	c.m2 = srpHash(srpPad(c.public), m1, key)
+	return hex.EncodeToString(m1), nil
+}
+
+func (c *srpClient) verify(proof string) bool {
+	return c.m2 != nil && hmac.Equal([]byte(hex.EncodeToString(c.m2)), []byte(strings.ToLower(proof)))
+}
+
+func readResponse(resp *http.Response) (map[string]interface{}, error) {
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	var data map[string]interface{}
+	err2 := json.Unmarshal(body, &data)
+	if err2 != nil {
+		log.Println(err2)
+		return nil, err2
+	}
+	return data, nil
+}
+
+func srpBegin(url string, request map[string]string, token string) (*srpClient, *http.Response, map[string]interface{}, error) {
+	client, err := newSrpClient()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	request["a"] = client.A()
+	body, err2 := json.Marshal(request)
+	if err2 != nil {
+		log.Println(err2)
+		return nil, nil, nil, err2
+	}
+	resp, err3 := SendRequest(url, "POST", body, token)
+	if err3 != nil {
+		log.Println(err3)
+		return nil, nil, nil, err3
+	}
+	data, err4 := readResponse(resp)
+	if err4 != nil {
+		return nil, nil, nil, err4
+	}
+	return client, resp, data, nil
+}
+
+func srpFinish(client *srpClient, url string, request map[string]interface{}, token string) (*http.Response, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	resp, err2 := SendRequest(url, "POST", body, token)
+	if err2 != nil {
+		log.Println(err2)
+		return nil, err2
+	}
+	if resp.StatusCode != 200 || client == nil {
+		return resp, nil
+	}
+	data, err3 := readResponse(resp)
+	if err3 != nil {
+		return nil, err3
+	}
+	proof, _ := data["proof"].(string)
+	if !client.verify(proof) {
+		resp.Body.Close()
+		log.Println("Server proof does not match")
+		return nil, fmt.Errorf("server could not prove it knows the password")
+	}
+	return resp, nil
+}
+
+func Login(username string, password string, totp string, device string) (*http.Response, error) {
+	username = strings.ToLower(username)
+	client, resp, data, err := srpBegin(fmt.Sprintf("%s/login/begin", models.Url), map[string]string{"username": username}, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return resp, nil
+	}
+	request := map[string]interface{}{"username": username, "device": device}
+	if totp != "" {
+		request["totp"] = totp
+	}
+	if legacy, _ := data["legacy"].(bool); legacy {
+		salt, verifier, err2 := NewVerifier(password)
+		if err2 != nil {
+			return nil, err2
+		}
+		request["password"] = password
+		request["salt"] = salt
+		request["verifier"] = verifier
+		return srpFinish(nil, fmt.Sprintf("%s/login", models.Url), request, "")
+	}
+	salt, _ := data["salt"].(string)
+	server, _ := data["b"].(string)
+	proof, err3 := client.proof(username, password, salt, server)
+	if err3 != nil {
+		log.Println(err3)
+		return nil, err3
+	}
+	request["session"] = data["session"]
+	request["proof"] = proof
+	return srpFinish(client, fmt.Sprintf("%s/login/finish", models.Url), request, "")
+}
+
+func Register(username string, password string) (*http.Response, error) {
+	salt, verifier, err := NewVerifier(password)
+	if err != nil {
+		return nil, err
+	}
+	body, err2 := json.Marshal(map[string]string{"username": username, "salt": salt, "verifier": verifier})
+	if err2 != nil {
+		log.Println(err2)
+		return nil, err2
+	}
+	return SendRequest(fmt.Sprintf("%s/register", models.Url), "POST", body, "")
+}
+
+func ChangeAccountPassword(user *models.User, current string, password string) (*http.Response, error) {
+	client, resp, data, err := srpBegin(fmt.Sprintf("%s/user/password/begin", models.Url), map[string]string{}, user.Token)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return resp, nil
+	}
+	salt, verifier, err2 := NewVerifier(password)
+	if err2 != nil {
+		return nil, err2
+	}
+	request := map[string]interface{}{"salt": salt, "verifier": verifier}
+	if legacy, _ := data["legacy"].(bool); legacy {
+		request["currentpassword"] = current
+		return srpFinish(nil, fmt.Sprintf("%s/user/password", models.Url), request, user.Token)
+	}
+	oldSalt, _ := data["salt"].(string)
+	server, _ := data["b"].(string)
+	proof, err3 := client.proof(strings.ToLower(user.Email), current, oldSalt, server)
+	if err3 != nil {
+		log.Println(err3)
+		return nil, err3
+	}
+	request["session"] = data["session"]
+	request["proof"] = proof
+	return srpFinish(client, fmt.Sprintf("%s/user/password", models.Url), request, user.Token)
+}
+
+func DisableTotp(user *models.User, code string, password string) (*http.Response, error) {
+	url := fmt.Sprintf("%s/otp/remove", models.Url)
+	if code != "" {
+		return srpFinish(nil, url, map[string]interface{}{"code": code}, user.Token)
+	}
+	client, resp, data, err := srpBegin(fmt.Sprintf("%s/user/confirm/begin", models.Url), map[string]string{}, user.Token)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return resp, nil
+	}
+	if legacy, _ := data["legacy"].(bool); legacy {
+		return nil, fmt.Errorf("log in again before confirming with your password")
+	}
+	salt, _ := data["salt"].(string)
+	server, _ := data["b"].(string)
+	proof, err2 := client.proof(strings.ToLower(user.Email), password, salt, server)
+	if err2 != nil {
+		log.Println(err2)
+		return nil, err2
+	}
+	return srpFinish(client, url, map[string]interface{}{"session": data["session"], "proof": proof}, user.Token)
+}
+
+func ResetPassword(token string, password string, totp string) (*http.Response, error) {
+	salt, verifier, err := NewVerifier(password)
+	if err != nil {
+		return nil, err
+	}
+	body, err2 := json.Marshal(map[string]string{"token": token, "salt": salt, "verifier": verifier, "totp": totp})
+	if err2 != nil {
+		log.Println(err2)
+		return nil, err2
+	}
+	return SendRequest(fmt.Sprintf("%s/password/reset", models.Url), "POST", body, "")
+}
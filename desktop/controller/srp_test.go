@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"desktop/models"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+type loginServer struct {
+	mu       sync.Mutex
+	begin    map[string]interface{}
+	finish   int
+	requests map[string]int
+	bodies   map[string]map[string]interface{}
+}
+
+func startLoginServer(t *testing.T, begin map[string]interface{}, finish int) *loginServer {
+	t.Helper()
+	s := &loginServer{begin: begin, finish: finish, requests: map[string]int{}, bodies: map[string]map[string]interface{}{}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodGet {
+			json.NewEncoder(w).Encode(map[string]string{"challenge": "challenge"})
+			return
+		}
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		s.mu.Lock()
+		s.requests[r.URL.Path]++
+		s.bodies[r.URL.Path] = body
+		s.mu.Unlock()
+		switch r.URL.Path {
+		case "/login/begin":
+			json.NewEncoder(w).Encode(s.begin)
+		case "/login/finish":
+			w.WriteHeader(s.finish)
+			json.NewEncoder(w).Encode(map[string]string{"message": "Invalid credentials"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"message": "Invalid credentials"})
+		}
+	}))
+	t.Cleanup(server.Close)
+	saved := models.Url
+	models.Url = server.URL
+	t.Cleanup(func() { models.Url = saved })
+	return s
+}
+
+func (s *loginServer) count(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+func TestLoginNeverSendsPasswordAfterProof(t *testing.T) {
+	B := new(big.Int).Exp(srpG, big.NewInt(12345), srpN)
+	begin := map[string]interface{}{"session": "session", "salt": "00112233445566778899aabbccddeeff", "b": hex.EncodeToString(srpPad(B))}
+	for _, status := range []int{http.StatusNotFound, http.StatusMethodNotAllowed} {
+		s := startLoginServer(t, begin, status)
+		resp, err := Login("Alice@example.com", "Correct horse 1!", "123456", "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("Login = %d, want the %d from /login/finish", resp.StatusCode, status)
+		}
+		if s.count("/login/finish") != 1 {
+			t.Errorf("Login sent %d proofs", s.count("/login/finish"))
+		}
+		if s.count("/login") != 0 {
+			t.Errorf("Login sent the password to /login after a %d from /login/finish", status)
+		}
+		if _, ok := s.bodies["/login/finish"]["password"]; ok {
+			t.Error("Login sent the password with the proof")
+		}
+	}
+}
+
+func TestLoginLegacyAccount(t *testing.T) {
+	s := startLoginServer(t, map[string]interface{}{"message": "Password required", "legacy": true}, http.StatusNotFound)
+	resp, err := Login("alice@example.com", "Correct horse 1!", "", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if s.count("/login/finish") != 0 || s.count("/login") != 1 {
+		t.Fatalf("legacy login sent %d proofs and %d passwords", s.count("/login/finish"), s.count("/login"))
+	}
+	body := s.bodies["/login"]
+	if body["password"] != "Correct horse 1!" || body["salt"] == nil || body["verifier"] == nil {
+		t.Errorf("legacy login request = %v", body)
+	}
+}
@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"desktop/models"
+	"desktop/security"
+	"desktop/sshagent"
+	"fmt"
+	"log"
+)
+
+func SSHKeys(file string, password string) ([]sshagent.Key, error) {
+	databases, err := GetAllDatabases(file, password)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Get ssh keys")
+	var keys []sshagent.Key
+	for _, database := range databases {
+		for _, group := range database.SecretGroups {
+			for _, secret := range group.Secrets {
+				if secret.Type != models.SecretTypeSSH || secret.Fields == nil {
+					continue
+				}
+				plaintext, err2 := security.DecryptText(password, secret.Fields)
+				if err2 != nil {
+					return nil, err2
+				}
+				fields := DecodeFields(models.Secret{Fields: plaintext})
+				if fields["agent"] != "true" {
+					continue
+				}
+				key, err3 := security.ParseSSHKey(fields["private_key"], fields["passphrase"])
+				if err3 != nil {
+					log.Println(err3)
+					continue
+				}
+				keys = append(keys, sshagent.Key{
+					Name:    fmt.Sprintf("%s/%s/%s", database.Name, group.Name, secret.Title),
+					Key:     key,
+					Confirm: fields["confirm"] == "true",
+				})
+			}
+		}
+	}
+	return keys, nil
+}
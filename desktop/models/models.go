@@ -2,6 +2,12 @@ package models
 
 var Url string = ""
 var Password string = ""
+var Client string = "desktop"
+var AccessToken string = ""
+var RefreshToken string = ""
+var BreachPath string = ""
+var MaxAttachmentSize int64 = 10 * 1024 * 1024
+var SSHAuthSock string = ""
 
 type Database struct {
 	ID           int           `gorm:"primaryKey"`
@@ -20,10 +26,84 @@ type SecretGroup struct {
 	Secrets    []Secret `gorm:"foreignkey:SecretGroupID"`
 }
 
+const (
+	SecretTypeLogin    = "login"
+	SecretTypeNote     = "note"
+	SecretTypeCard     = "card"
+	SecretTypeIdentity = "identity"
+	SecretTypeBank     = "bank"
+	SecretTypeSSH      = "ssh"
+)
+
+const (
+	FieldText   = "text"
+	FieldCard   = "card"
+	FieldExpiry = "expiry"
+	FieldDate   = "date"
+	FieldDigits = "digits"
+	FieldIBAN   = "iban"
+	FieldBIC    = "bic"
+)
+
+const (
+	MatchDomain     = "domain"
+	MatchHost       = "host"
+	MatchStartsWith = "startswith"
+	MatchRegex      = "regex"
+	MatchNever      = "never"
+)
+
+var MatchModes = []string{MatchDomain, MatchHost, MatchStartsWith, MatchRegex, MatchNever}
+
+type Field struct {
+	Name   string
+	Label  string
+	Kind   string
+	Hidden bool
+}
+
+var SecretFields = map[string][]Field{
+	SecretTypeCard: {
+		{Name: "cardholder", Label: "Cardholder", Kind: FieldText},
+		{Name: "number", Label: "Card number", Kind: FieldCard, Hidden: true},
+		{Name: "expiry", Label: "Expiry (MM/YY)", Kind: FieldExpiry},
+		{Name: "cvv", Label: "CVV", Kind: FieldDigits, Hidden: true},
+		{Name: "pin", Label: "PIN", Kind: FieldDigits, Hidden: true},
+	},
+	SecretTypeIdentity: {
+		{Name: "name", Label: "Full name", Kind: FieldText},
+		{Name: "birthdate", Label: "Date of birth", Kind: FieldDate},
+		{Name: "address", Label: "Address", Kind: FieldText},
+		{Name: "postcode", Label: "Postcode", Kind: FieldText},
+		{Name: "city", Label: "City", Kind: FieldText},
+		{Name: "country", Label: "Country", Kind: FieldText},
+		{Name: "phone", Label: "Phone", Kind: FieldText},
+		{Name: "email", Label: "Email", Kind: FieldText},
+		{Name: "passport", Label: "Passport number", Kind: FieldText, Hidden: true},
+		{Name: "passport_expiry", Label: "Passport expiry", Kind: FieldDate},
+	},
+	SecretTypeBank: {
+		{Name: "holder", Label: "Account holder", Kind: FieldText},
+		{Name: "bank", Label: "Bank", Kind: FieldText},
+		{Name: "iban", Label: "IBAN", Kind: FieldIBAN},
+		{Name: "bic", Label: "BIC", Kind: FieldBIC},
+		{Name: "pin", Label: "PIN", Kind: FieldDigits, Hidden: true},
+	},
+	SecretTypeSSH: {
+		{Name: "fingerprint", Label: "Fingerprint", Kind: FieldText},
+		{Name: "public_key", Label: "Public key", Kind: FieldText},
+		{Name: "private_key", Label: "Private key", Kind: FieldText, Hidden: true},
+		{Name: "passphrase", Label: "Passphrase", Kind: FieldText, Hidden: true},
+	},
+}
+
 type Secret struct {
-	ID            int `gorm:"primaryKey"`
+	ID            int    `gorm:"primaryKey"`
+	Type          string `gorm:"not null;default:login"`
 	Username      string
 	Password      []byte `gorm:"not null"`
+	Note          []byte
+	Fields        []byte
 	Title         string
 	Description   string
 	URL           string
@@ -32,13 +112,46 @@ type Secret struct {
 	SecretGroupID int
 }
 
+type Attachment struct {
+	ID         int    `gorm:"primaryKey"`
+	Name       string `gorm:"not null"`
+	Size       int64  `gorm:"not null"`
+	Hash       string `gorm:"index;not null"`
+	SecretID   int    `gorm:"index"`
+	Created_at string `gorm:"not null"`
+}
+
 type Configuration struct {
 	Database string
 }
 
 type User struct {
-	Email    string
-	Token    string
-	Verified bool
-	Totp     bool
+	Email         string
+	Token         string
+	Verified      bool
+	Totp          bool
+	RecoveryCodes int
+}
+
+type Session struct {
+	ID        string `json:"id"`
+	Device    string `json:"device"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	CreatedAt string `json:"created_at"`
+	LastSeen  string `json:"last_seen"`
+	Current   bool   `json:"current"`
+}
+
+type Entry struct {
+	Database string
+	Group    string
+	Secret   Secret
+}
+
+type Breach struct {
+	Database string
+	Group    string
+	Secret   Secret
+	Count    int
 }
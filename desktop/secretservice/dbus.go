@@ -0,0 +1,638 @@
+package secretservice
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"desktop/agent"
+	"desktop/controller"
+
+	"github.com/godbus/dbus/v5"
+)
+
+type serviceHandler struct {
+	s *Service
+}
+
+type collectionHandler struct {
+	s *Service
+}
+
+type itemHandler struct {
+	s *Service
+}
+
+type sessionHandler struct {
+	s *Service
+}
+
+type promptHandler struct {
+	s *Service
+}
+
+type propertiesHandler struct {
+	s *Service
+}
+
+func dbusError(err error) *dbus.Error {
+	switch {
+	case errors.Is(err, ErrLocked):
+		return dbus.NewError("org.freedesktop.Secret.Error.IsLocked", []interface{}{err.Error()})
+	case errors.Is(err, controller.ErrNotFound):
+		return dbus.NewError("org.freedesktop.Secret.Error.NoSuchObject", []interface{}{err.Error()})
+	}
+	return dbus.MakeFailedError(err)
+}
+
+func noSuchObject(path dbus.ObjectPath) *dbus.Error {
+	return dbus.NewError("org.freedesktop.Secret.Error.NoSuchObject", []interface{}{fmt.Sprintf("no such object %s", path)})
+}
+
+func noSession(path dbus.ObjectPath) *dbus.Error {
+	return dbus.NewError("org.freedesktop.Secret.Error.NoSession", []interface{}{fmt.Sprintf("no such session %s", path)})
+}
+
+func unknownInterface(iface string) *dbus.Error {
+	err := dbus.MakeUnknownInterfaceError(iface)
+	return &err
+}
+
+func messagePath(msg dbus.Message) dbus.ObjectPath {
+	path, _ := msg.Headers[dbus.FieldPath].Value().(dbus.ObjectPath)
+	return path
+}
+
+func (s *Service) collectionAt(path dbus.ObjectPath) (string, *dbus.Error) {
+	database, id, ok := s.parse(path)
+	if !ok || id != 0 {
+		return "", noSuchObject(path)
+	}
+	return database, nil
+}
+
+func (s *Service) itemAt(path dbus.ObjectPath) (string, int, *dbus.Error) {
+	database, id, ok := s.parse(path)
+	if !ok || id == 0 {
+		return "", 0, noSuchObject(path)
+	}
+	return database, id, nil
+}
+
+func (h *serviceHandler) OpenSession(sender dbus.Sender, algorithm string, input dbus.Variant) (dbus.Variant, dbus.ObjectPath, *dbus.Error) {
+	s := h.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := s.nextPath(sessionPrefix)
+	session, output, err := newSession(path, string(sender), algorithm, input)
+	if errors.Is(err, errUnsupportedAlgorithm) {
+		return dbus.Variant{}, noPrompt, dbus.NewError("org.freedesktop.DBus.Error.NotSupported", []interface{}{fmt.Sprintf("algorithm %s is not supported", algorithm)})
+	}
+	if err != nil {
+		return dbus.Variant{}, noPrompt, dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", []interface{}{err.Error()})
+	}
+	s.sessions[path] = session
+	log.Println("Open " + algorithm + " session for " + string(sender))
+	return output, path, nil
+}
+
+func (h *serviceHandler) CreateCollection(properties map[string]dbus.Variant, alias string) (dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	s := h.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, err := s.resolver()
+	if err != nil {
+		return noPrompt, noPrompt, dbusError(err)
+	}
+	label, _ := properties[collectionIface+".Label"].Value().(string)
+	if alias != "" {
+		if database := s.alias(alias); database != "" {
+			if _, err := controller.FindDatabase(r.Databases(), database); err == nil {
+				return collectionPath(database), noPrompt, nil
+			}
+		}
+		if label == "" {
+			label = alias
+		}
+	}
+	if label == "" {
+		return noPrompt, noPrompt, dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", []interface{}{"collection needs a label"})
+	}
+	if _, err := controller.FindDatabase(r.Databases(), label); errors.Is(err, controller.ErrNotFound) {
+		if _, err2 := controller.CreateSubDatabase(s.file, s.password, label); err2 != nil {
+			return noPrompt, noPrompt, dbusError(err2)
+		}
+		s.collections = append(s.collections, label)
+		s.emit(servicePath, serviceIface+".CollectionCreated", collectionPath(label))
+	}
+	if alias != "" {
+		s.aliases[alias] = label
+	}
+	return collectionPath(label), noPrompt, nil
+}
+
+func (h *serviceHandler) SearchItems(attributes map[string]string) ([]dbus.ObjectPath, []dbus.ObjectPath, *dbus.Error) {
+	s := h.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, err := s.resolver()
+	if errors.Is(err, ErrLocked) {
+		return []dbus.ObjectPath{}, []dbus.ObjectPath{}, nil
+	}
+	if err != nil {
+		return nil, nil, dbusError(err)
+	}
+	paths, err := s.search(r, s.collections, attributes)
+	if err != nil {
+		return nil, nil, dbusError(err)
+	}
+	return paths, []dbus.ObjectPath{}, nil
+}
+
+func (h *serviceHandler) Unlock(objects []dbus.ObjectPath) ([]dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	s := h.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.unlocked(); err == nil {
+		return objects, noPrompt, nil
+	}
+	path := s.nextPath(promptPrefix)
+	s.prompts[path] = &prompt{path: path, objects: objects}
+	return []dbus.ObjectPath{}, path, nil
+}
+
+func (h *serviceHandler) Lock(objects []dbus.ObjectPath) ([]dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	s := h.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fromAgent {
+		if err := agent.Lock(agent.DefaultSocket(), s.key); err != nil {
+			log.Println(err)
+		}
+	}
+	s.lock()
+	return objects, noPrompt, nil
+}
+
+func (h *serviceHandler) GetSecrets(items []dbus.ObjectPath, sessionPath dbus.ObjectPath) (map[dbus.ObjectPath]Secret, *dbus.Error) {
+	s := h.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[sessionPath]
+	if !ok {
+		return nil, noSession(sessionPath)
+	}
+	r, err := s.resolver()
+	if err != nil {
+		return nil, dbusError(err)
+	}
+	secrets := map[dbus.ObjectPath]Secret{}
+	for _, path := range items {
+		database, id, ok := s.parse(path)
+		if !ok || id == 0 {
+			continue
+		}
+		i, err := s.item(r, database, id)
+		if err != nil {
+			continue
+		}
+		secret, err2 := session.encrypt(i.entry.Secret.Password, contentType(i))
+		if err2 != nil {
+			return nil, dbusError(err2)
+		}
+		secrets[path] = secret
+	}
+	return secrets, nil
+}
+
+func (h *serviceHandler) ReadAlias(name string) (dbus.ObjectPath, *dbus.Error) {
+	s := h.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.resolver(); err != nil && !errors.Is(err, ErrLocked) {
+		return noPrompt, dbusError(err)
+	}
+	database := s.alias(name)
+	if database == "" {
+		return noPrompt, nil
+	}
+	return collectionPath(database), nil
+}
+
+func (h *serviceHandler) SetAlias(name string, collection dbus.ObjectPath) *dbus.Error {
+	s := h.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if collection == noPrompt {
+		delete(s.aliases, name)
+		return nil
+	}
+	database, err := s.collectionAt(collection)
+	if err != nil {
+		return err
+	}
+	s.aliases[name] = database
+	return nil
+}
+
+func contentType(i item) string {
+	if value := controller.DecodeFields(i.entry.Secret)[contentTypeField]; value != "" {
+		return value
+	}
+	return "text/plain"
+}
+
+func (h *collectionHandler) Delete(msg dbus.Message) (dbus.ObjectPath, *dbus.Error) {
+	s := h.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := messagePath(msg)
+	database, derr := s.collectionAt(path)
+	if derr != nil {
+		return noPrompt, derr
+	}
+	r, err := s.resolver()
+	if err != nil {
+		return noPrompt, dbusError(err)
+	}
+	d, err := controller.FindDatabase(r.Databases(), database)
+	if err != nil {
+		return noPrompt, dbusError(err)
+	}
+	for _, group := range d.SecretGroups {
+		if group.Name != s.group && len(group.Secrets) > 0 {
+			return noPrompt, dbus.NewError("org.freedesktop.DBus.Error.AccessDenied", []interface{}{fmt.Sprintf("%s holds secrets outside %s", database, s.group)})
+		}
+	}
+	if err := controller.DeleteDatabase(s.file, s.password, database); err != nil {
+		return noPrompt, dbusError(err)
+	}
+	for alias, name := range s.aliases {
+		if name == database {
+			delete(s.aliases, alias)
+		}
+	}
+	s.emit(servicePath, serviceIface+".CollectionDeleted", collectionPath(database))
+	return noPrompt, nil
+}
+
+func (h *collectionHandler) SearchItems(msg dbus.Message, attributes map[string]string) ([]dbus.ObjectPath, *dbus.Error) {
+	s := h.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	database, derr := s.collectionAt(messagePath(msg))
+	if derr != nil {
+		return nil, derr
+	}
+	r, err := s.resolver()
+	if err != nil {
+		return nil, dbusError(err)
+	}
+	paths, err := s.search(r, []string{database}, attributes)
+	if err != nil {
+		return nil, dbusError(err)
+	}
+	return paths, nil
+}
+
+func (h *collectionHandler) CreateItem(msg dbus.Message, properties map[string]dbus.Variant, secret Secret, replace bool) (dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	s := h.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	database, derr := s.collectionAt(messagePath(msg))
+	if derr != nil {
+		return noPrompt, noPrompt, derr
+	}
+	session, ok := s.sessions[secret.Session]
+	if !ok {
+		return noPrompt, noPrompt, noSession(secret.Session)
+	}
+	value, err := session.decrypt(secret)
+	if err != nil {
+		return noPrompt, noPrompt, dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", []interface{}{err.Error()})
+	}
+	label, _ := properties[itemIface+".Label"].Value().(string)
+	attrs, _ := properties[itemIface+".Attributes"].Value().(map[string]string)
+	r, err := s.resolver()
+	if err != nil {
+		return noPrompt, noPrompt, dbusError(err)
+	}
+	path, created, err := s.createItem(r, database, label, attrs, value, secret.ContentType, replace)
+	if err != nil {
+		return noPrompt, noPrompt, dbusError(err)
+	}
+	if created {
+		s.emit(collectionPath(database), collectionIface+".ItemCreated", path)
+	} else {
+		s.emit(collectionPath(database), collectionIface+".ItemChanged", path)
+	}
+	return path, noPrompt, nil
+}
+
+func (h *itemHandler) Delete(msg dbus.Message) (dbus.ObjectPath, *dbus.Error) {
+	s := h.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	database, id, derr := s.itemAt(messagePath(msg))
+	if derr != nil {
+		return noPrompt, derr
+	}
+	r, err := s.resolver()
+	if err != nil {
+		return noPrompt, dbusError(err)
+	}
+	if _, err := s.item(r, database, id); err != nil {
+		return noPrompt, dbusError(err)
+	}
+	if err := controller.DeleteSecret(s.file, s.password, database, s.group, id); err != nil {
+		return noPrompt, dbusError(err)
+	}
+	s.emit(collectionPath(database), collectionIface+".ItemDeleted", itemPath(database, id))
+	return noPrompt, nil
+}
+
+func (h *itemHandler) GetSecret(msg dbus.Message, sessionPath dbus.ObjectPath) (Secret, *dbus.Error) {
+	s := h.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	database, id, derr := s.itemAt(messagePath(msg))
+	if derr != nil {
+		return Secret{}, derr
+	}
+	session, ok := s.sessions[sessionPath]
+	if !ok {
+		return Secret{}, noSession(sessionPath)
+	}
+	r, err := s.resolver()
+	if err != nil {
+		return Secret{}, dbusError(err)
+	}
+	i, err := s.item(r, database, id)
+	if err != nil {
+		return Secret{}, dbusError(err)
+	}
+	secret, err := session.encrypt(i.entry.Secret.Password, contentType(i))
+	if err != nil {
+		return Secret{}, dbusError(err)
+	}
+	return secret, nil
+}
+
+func (h *itemHandler) SetSecret(msg dbus.Message, secret Secret) *dbus.Error {
+	s := h.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	database, id, derr := s.itemAt(messagePath(msg))
+	if derr != nil {
+		return derr
+	}
+	session, ok := s.sessions[secret.Session]
+	if !ok {
+		return noSession(secret.Session)
+	}
+	value, err := session.decrypt(secret)
+	if err != nil {
+		return dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", []interface{}{err.Error()})
+	}
+	r, err := s.resolver()
+	if err != nil {
+		return dbusError(err)
+	}
+	i, err := s.item(r, database, id)
+	if err != nil {
+		return dbusError(err)
+	}
+	fields := controller.DecodeFields(i.entry.Secret)
+	delete(fields, contentTypeField)
+	if secret.ContentType != "" && secret.ContentType != "text/plain" {
+		fields[contentTypeField] = secret.ContentType
+	}
+	updated := i.entry.Secret
+	updated.Password = value
+	updated.Fields = controller.EncodeFields(fields)
+	if _, err := controller.UpdateSecret(s.file, s.password, database, s.group, id, updated); err != nil {
+		return dbusError(err)
+	}
+	s.emit(collectionPath(database), collectionIface+".ItemChanged", itemPath(database, id))
+	return nil
+}
+
+func (h *sessionHandler) Close(msg dbus.Message) *dbus.Error {
+	s := h.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := messagePath(msg)
+	if _, ok := s.sessions[path]; !ok {
+		return noSession(path)
+	}
+	delete(s.sessions, path)
+	return nil
+}
+
+func (h *promptHandler) Prompt(msg dbus.Message, windowID string) *dbus.Error {
+	s := h.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := messagePath(msg)
+	p, ok := s.prompts[path]
+	if !ok {
+		return noSuchObject(path)
+	}
+	delete(s.prompts, path)
+	go s.runPrompt(p)
+	return nil
+}
+
+func (h *promptHandler) Dismiss(msg dbus.Message) *dbus.Error {
+	s := h.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := messagePath(msg)
+	if _, ok := s.prompts[path]; !ok {
+		return noSuchObject(path)
+	}
+	delete(s.prompts, path)
+	s.emit(path, promptIface+".Completed", true, dbus.MakeVariant([]dbus.ObjectPath{}))
+	return nil
+}
+
+func (s *Service) runPrompt(p *prompt) {
+	dismissed := func() {
+		s.emit(p.path, promptIface+".Completed", true, dbus.MakeVariant([]dbus.ObjectPath{}))
+	}
+	if s.ask == nil {
+		dismissed()
+		return
+	}
+	password, err := s.ask()
+	if err != nil || password == "" {
+		log.Println(err)
+		dismissed()
+		return
+	}
+	if err := s.Unlock(password); err != nil {
+		log.Println(err)
+		dismissed()
+		return
+	}
+	socket := agent.DefaultSocket()
+	if agent.Running(socket) {
+		if err := agent.Unlock(socket, s.key, password, 0); err != nil {
+			log.Println(err)
+		}
+	}
+	s.emit(p.path, promptIface+".Completed", false, dbus.MakeVariant(p.objects))
+}
+
+func (h *propertiesHandler) Get(msg dbus.Message, iface string, name string) (dbus.Variant, *dbus.Error) {
+	properties, err := h.s.properties(messagePath(msg), iface)
+	if err != nil {
+		return dbus.Variant{}, err
+	}
+	value, ok := properties[name]
+	if !ok {
+		return dbus.Variant{}, dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", []interface{}{fmt.Sprintf("no property %s on %s", name, iface)})
+	}
+	return value, nil
+}
+
+func (h *propertiesHandler) GetAll(msg dbus.Message, iface string) (map[string]dbus.Variant, *dbus.Error) {
+	return h.s.properties(messagePath(msg), iface)
+}
+
+func (h *propertiesHandler) Set(msg dbus.Message, iface string, name string, value dbus.Variant) *dbus.Error {
+	s := h.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := messagePath(msg)
+	database, id, ok := s.parse(path)
+	if !ok {
+		return noSuchObject(path)
+	}
+	r, err := s.resolver()
+	if err != nil {
+		return dbusError(err)
+	}
+	switch {
+	case iface == collectionIface && id == 0 && name == "Label":
+		label, _ := value.Value().(string)
+		if label == "" {
+			return dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", []interface{}{"label must be a non-empty string"})
+		}
+		if _, err := controller.UpdateDatabase(s.file, s.password, database, label); err != nil {
+			return dbusError(err)
+		}
+		for alias, current := range s.aliases {
+			if current == database {
+				s.aliases[alias] = label
+			}
+		}
+		if s.defaults == database {
+			s.defaults = label
+		}
+		s.emit(servicePath, serviceIface+".CollectionChanged", collectionPath(label))
+		return nil
+	case iface == itemIface && id != 0 && (name == "Label" || name == "Attributes"):
+		i, err := s.item(r, database, id)
+		if err != nil {
+			return dbusError(err)
+		}
+		updated := i.entry.Secret
+		if name == "Label" {
+			label, ok := value.Value().(string)
+			if !ok {
+				return dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", []interface{}{"label must be a string"})
+			}
+			updated.Title = label
+		} else {
+			attrs, ok := value.Value().(map[string]string)
+			if !ok {
+				return dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", []interface{}{"attributes must be a string dictionary"})
+			}
+			fields := map[string]string{}
+			for key, v := range attrs {
+				fields[key] = v
+			}
+			if current := controller.DecodeFields(i.entry.Secret)[contentTypeField]; current != "" {
+				fields[contentTypeField] = current
+			}
+			updated.Fields = controller.EncodeFields(fields)
+		}
+		if _, err := controller.UpdateSecret(s.file, s.password, database, s.group, id, updated); err != nil {
+			return dbusError(err)
+		}
+		s.emit(collectionPath(database), collectionIface+".ItemChanged", path)
+		return nil
+	}
+	return dbus.NewError("org.freedesktop.DBus.Error.PropertyReadOnly", []interface{}{fmt.Sprintf("%s.%s is read only", iface, name)})
+}
+
+func (s *Service) properties(path dbus.ObjectPath, iface string) (map[string]dbus.Variant, *dbus.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if path == servicePath {
+		if iface != serviceIface {
+			return nil, unknownInterface(iface)
+		}
+		if _, err := s.resolver(); err != nil && !errors.Is(err, ErrLocked) {
+			return nil, dbusError(err)
+		}
+		collections := []dbus.ObjectPath{}
+		for _, database := range s.collections {
+			collections = append(collections, collectionPath(database))
+		}
+		return map[string]dbus.Variant{"Collections": dbus.MakeVariant(collections)}, nil
+	}
+	database, id, ok := s.parse(path)
+	if !ok {
+		return nil, noSuchObject(path)
+	}
+	r, err := s.resolver()
+	locked := errors.Is(err, ErrLocked)
+	if err != nil && !locked {
+		return nil, dbusError(err)
+	}
+	if iface == collectionIface && id == 0 {
+		if locked {
+			return map[string]dbus.Variant{
+				"Items":    dbus.MakeVariant([]dbus.ObjectPath{}),
+				"Label":    dbus.MakeVariant(database),
+				"Locked":   dbus.MakeVariant(true),
+				"Created":  dbus.MakeVariant(uint64(0)),
+				"Modified": dbus.MakeVariant(uint64(0)),
+			}, nil
+		}
+		d, err := controller.FindDatabase(r.Databases(), database)
+		if err != nil {
+			return nil, dbusError(err)
+		}
+		items, err := s.search(r, []string{database}, nil)
+		if err != nil {
+			return nil, dbusError(err)
+		}
+		return map[string]dbus.Variant{
+			"Items":    dbus.MakeVariant(items),
+			"Label":    dbus.MakeVariant(d.Name),
+			"Locked":   dbus.MakeVariant(false),
+			"Created":  dbus.MakeVariant(timestamp(d.Created_at)),
+			"Modified": dbus.MakeVariant(timestamp(d.Updated_at)),
+		}, nil
+	}
+	if iface == itemIface && id != 0 {
+		if locked {
+			return nil, dbusError(err)
+		}
+		i, err := s.item(r, database, id)
+		if err != nil {
+			return nil, dbusError(err)
+		}
+		return map[string]dbus.Variant{
+			"Locked":     dbus.MakeVariant(false),
+			"Attributes": dbus.MakeVariant(attributes(i.entry.Secret)),
+			"Label":      dbus.MakeVariant(i.entry.Secret.Title),
+			"Created":    dbus.MakeVariant(timestamp(i.entry.Secret.Created_at)),
+			"Modified":   dbus.MakeVariant(timestamp(i.entry.Secret.Updated_at)),
+		}, nil
+	}
+	return nil, unknownInterface(iface)
+}
@@ -0,0 +1,411 @@
+package secretservice
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"desktop/agent"
+	"desktop/controller"
+	"desktop/models"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	BusName          = "org.freedesktop.secrets"
+	servicePath      = dbus.ObjectPath("/org/freedesktop/secrets")
+	collectionPrefix = "/org/freedesktop/secrets/collection/"
+	aliasPrefix      = "/org/freedesktop/secrets/aliases/"
+	sessionPrefix    = "/org/freedesktop/secrets/session/"
+	promptPrefix     = "/org/freedesktop/secrets/prompt/"
+	serviceIface     = "org.freedesktop.Secret.Service"
+	collectionIface  = "org.freedesktop.Secret.Collection"
+	itemIface        = "org.freedesktop.Secret.Item"
+	sessionIface     = "org.freedesktop.Secret.Session"
+	promptIface      = "org.freedesktop.Secret.Prompt"
+	propertiesIface  = "org.freedesktop.DBus.Properties"
+	contentTypeField = "secret-service:content-type"
+	noPrompt         = dbus.ObjectPath("/")
+)
+
+var ErrLocked = errors.New("vault is locked")
+
+type Service struct {
+	mu          sync.Mutex
+	conn        *dbus.Conn
+	file        string
+	key         string
+	group       string
+	defaults    string
+	password    string
+	fromAgent   bool
+	collections []string
+	aliases     map[string]string
+	sessions    map[dbus.ObjectPath]*session
+	prompts     map[dbus.ObjectPath]*prompt
+	counter     int
+	ask         func() (string, error)
+}
+
+type prompt struct {
+	path    dbus.ObjectPath
+	objects []dbus.ObjectPath
+}
+
+type item struct {
+	database string
+	entry    models.Entry
+}
+
+func New(conn *dbus.Conn, file string, group string, collection string, ask func() (string, error)) (*Service, error) {
+	key, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	s := &Service{
+		conn:     conn,
+		file:     file,
+		key:      key,
+		group:    group,
+		defaults: collection,
+		aliases:  map[string]string{},
+		sessions: map[dbus.ObjectPath]*session{},
+		prompts:  map[dbus.ObjectPath]*prompt{},
+		ask:      ask,
+	}
+	return s, nil
+}
+
+func (s *Service) Export() error {
+	exports := []struct {
+		v       interface{}
+		iface   string
+		subtree bool
+	}{
+		{&serviceHandler{s}, serviceIface, false},
+		{&collectionHandler{s}, collectionIface, true},
+		{&itemHandler{s}, itemIface, true},
+		{&sessionHandler{s}, sessionIface, true},
+		{&promptHandler{s}, promptIface, true},
+		{&propertiesHandler{s}, propertiesIface, true},
+	}
+	for _, export := range exports {
+		var err error
+		if export.subtree {
+			err = s.conn.ExportSubtree(export.v, servicePath, export.iface)
+		} else {
+			err = s.conn.Export(export.v, servicePath, export.iface)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Service) Unlock(password string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	databases, err := controller.GetAllDatabases(s.file, password)
+	if err != nil {
+		return err
+	}
+	s.password = password
+	s.fromAgent = false
+	s.remember(databases)
+	return nil
+}
+
+func (s *Service) Lock() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lock()
+}
+
+func (s *Service) lock() {
+	if s.password != "" {
+		log.Println("Lock secret service")
+	}
+	s.password = ""
+	s.fromAgent = false
+}
+
+func (s *Service) unlocked() (string, error) {
+	if s.password != "" {
+		if s.fromAgent {
+			if _, err := agent.Key(agent.DefaultSocket(), s.key); errors.Is(err, agent.ErrLocked) {
+				s.lock()
+				return "", ErrLocked
+			}
+		}
+		return s.password, nil
+	}
+	password, err := agent.Key(agent.DefaultSocket(), s.key)
+	if err != nil {
+		return "", ErrLocked
+	}
+	s.password = password
+	s.fromAgent = true
+	log.Println("Unlock secret service from agent session")
+	return password, nil
+}
+
+func (s *Service) resolver() (*controller.Resolver, error) {
+	password, err := s.unlocked()
+	if err != nil {
+		return nil, err
+	}
+	r, err := controller.NewResolver(s.file, password)
+	if err != nil {
+		return nil, err
+	}
+	s.remember(r.Databases())
+	return r, nil
+}
+
+func (s *Service) remember(databases []models.Database) {
+	s.collections = nil
+	for _, database := range databases {
+		s.collections = append(s.collections, database.Name)
+	}
+}
+
+func (s *Service) nextPath(prefix string) dbus.ObjectPath {
+	s.counter++
+	return dbus.ObjectPath(prefix + strconv.Itoa(s.counter))
+}
+
+func encodeName(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "_%02x", c)
+		}
+	}
+	return b.String()
+}
+
+func decodeName(encoded string) (string, bool) {
+	var b strings.Builder
+	for i := 0; i < len(encoded); i++ {
+		if encoded[i] != '_' {
+			b.WriteByte(encoded[i])
+			continue
+		}
+		if i+2 >= len(encoded) {
+			return "", false
+		}
+		c, err := strconv.ParseUint(encoded[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", false
+		}
+		b.WriteByte(byte(c))
+		i += 2
+	}
+	return b.String(), b.Len() > 0
+}
+
+func collectionPath(database string) dbus.ObjectPath {
+	return dbus.ObjectPath(collectionPrefix + encodeName(database))
+}
+
+func itemPath(database string, id int) dbus.ObjectPath {
+	return dbus.ObjectPath(fmt.Sprintf("%s/%d", collectionPath(database), id))
+}
+
+func (s *Service) alias(name string) string {
+	if database, ok := s.aliases[name]; ok {
+		return database
+	}
+	if name != "default" {
+		return ""
+	}
+	if s.defaults != "" {
+		return s.defaults
+	}
+	if len(s.collections) > 0 {
+		return s.collections[0]
+	}
+	return ""
+}
+
+func (s *Service) parse(path dbus.ObjectPath) (string, int, bool) {
+	var rest string
+	var database string
+	switch {
+	case strings.HasPrefix(string(path), collectionPrefix):
+		rest = strings.TrimPrefix(string(path), collectionPrefix)
+		encoded, _, _ := strings.Cut(rest, "/")
+		name, ok := decodeName(encoded)
+		if !ok {
+			return "", 0, false
+		}
+		database = name
+	case strings.HasPrefix(string(path), aliasPrefix):
+		rest = strings.TrimPrefix(string(path), aliasPrefix)
+		name, _, _ := strings.Cut(rest, "/")
+		database = s.alias(name)
+		if database == "" {
+			return "", 0, false
+		}
+	default:
+		return "", 0, false
+	}
+	_, id, found := strings.Cut(rest, "/")
+	if !found {
+		return database, 0, true
+	}
+	n, err := strconv.Atoi(id)
+	if err != nil || n <= 0 {
+		return "", 0, false
+	}
+	return database, n, true
+}
+
+func attributes(secret models.Secret) map[string]string {
+	fields := controller.DecodeFields(secret)
+	result := map[string]string{}
+	for name, value := range fields {
+		if !strings.HasPrefix(name, "secret-service:") {
+			result[name] = value
+		}
+	}
+	return result
+}
+
+func matches(attrs map[string]string, query map[string]string) bool {
+	for name, value := range query {
+		if attrs[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Service) items(r *controller.Resolver, database string) ([]item, error) {
+	group, err := controller.FindSecretGroup(r.Databases(), database, s.group)
+	if errors.Is(err, controller.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var items []item
+	for _, secret := range group.Secrets {
+		entry, err2 := r.Entry(fmt.Sprintf("%s/%s/@%d", database, s.group, secret.ID))
+		if err2 != nil {
+			return nil, err2
+		}
+		items = append(items, item{database: database, entry: entry})
+	}
+	return items, nil
+}
+
+func (s *Service) item(r *controller.Resolver, database string, id int) (item, error) {
+	items, err := s.items(r, database)
+	if err != nil {
+		return item{}, err
+	}
+	for _, i := range items {
+		if i.entry.Secret.ID == id {
+			return i, nil
+		}
+	}
+	return item{}, controller.ErrNotFound
+}
+
+func (s *Service) search(r *controller.Resolver, databases []string, query map[string]string) ([]dbus.ObjectPath, error) {
+	paths := []dbus.ObjectPath{}
+	for _, database := range databases {
+		items, err := s.items(r, database)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range items {
+			if matches(attributes(i.entry.Secret), query) {
+				paths = append(paths, itemPath(database, i.entry.Secret.ID))
+			}
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return paths[i] < paths[j]
+	})
+	return paths, nil
+}
+
+func (s *Service) ensureGroup(r *controller.Resolver, database string) error {
+	if _, err := controller.FindDatabase(r.Databases(), database); err != nil {
+		return err
+	}
+	_, err := controller.FindSecretGroup(r.Databases(), database, s.group)
+	if errors.Is(err, controller.ErrNotFound) {
+		_, err = controller.CreateSecretGroup(s.file, s.password, database, s.group)
+	}
+	return err
+}
+
+func (s *Service) createItem(r *controller.Resolver, database string, label string, attrs map[string]string, value []byte, contentType string, replace bool) (dbus.ObjectPath, bool, error) {
+	if err := s.ensureGroup(r, database); err != nil {
+		return "", false, err
+	}
+	fields := map[string]string{}
+	for name, v := range attrs {
+		fields[name] = v
+	}
+	if contentType != "" && contentType != "text/plain" {
+		fields[contentTypeField] = contentType
+	}
+	if replace {
+		items, err := s.items(r, database)
+		if err != nil {
+			return "", false, err
+		}
+		for _, i := range items {
+			current := attributes(i.entry.Secret)
+			if len(current) == len(attrs) && matches(current, attrs) {
+				secret := i.entry.Secret
+				secret.Title = label
+				secret.Password = value
+				secret.Fields = controller.EncodeFields(fields)
+				if _, err := controller.UpdateSecret(s.file, s.password, database, s.group, secret.ID, secret); err != nil {
+					return "", false, err
+				}
+				return itemPath(database, secret.ID), false, nil
+			}
+		}
+	}
+	secret, err := controller.CreateSecret(s.file, s.password, database, s.group, models.Secret{
+		Type:     models.SecretTypeLogin,
+		Title:    label,
+		Password: value,
+		Fields:   controller.EncodeFields(fields),
+	})
+	if err != nil {
+		return "", false, err
+	}
+	return itemPath(database, secret.ID), true, nil
+}
+
+func timestamp(value string) uint64 {
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", value, time.Local)
+	if err != nil {
+		return 0
+	}
+	return uint64(t.Unix())
+}
+
+func (s *Service) emit(path dbus.ObjectPath, name string, values ...interface{}) {
+	if err := s.conn.Emit(path, name, values...); err != nil {
+		log.Println(err)
+	}
+}
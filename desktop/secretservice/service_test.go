@@ -0,0 +1,333 @@
+package secretservice
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"log"
+	"math/big"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"desktop/controller"
+
+	"github.com/godbus/dbus/v5"
+	"golang.org/x/crypto/hkdf"
+	"gorm.io/gorm/logger"
+)
+
+const testPassword = "Correct horse 1!"
+
+type testBus struct {
+	client  *dbus.Conn
+	service *Service
+	file    string
+}
+
+func privateBus(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("dbus-daemon"); err != nil {
+		t.Skip("dbus-daemon not installed")
+	}
+	cmd := exec.Command("dbus-daemon", "--session", "--nofork", "--nopidfile", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("dbus-daemon does not start: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("read bus address: %v", err)
+	}
+	return strings.TrimSpace(address)
+}
+
+func startService(t *testing.T) *testBus {
+	t.Helper()
+	log.SetOutput(io.Discard)
+	logger.Default = logger.Default.LogMode(logger.Silent)
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	address := privateBus(t)
+
+	file := filepath.Join(t.TempDir(), "vault.db")
+	if err := controller.InitDB(file, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.CreateDatabaseAndSecretGroupIfNotExist(file, testPassword, "Main"); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	ask := func() (string, error) { return testPassword, nil }
+	service, err := New(conn, file, "Secret Service", "", ask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Unlock(testPassword); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Export(); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := conn.RequestName(BusName, dbus.NameFlagDoNotQueue)
+	if err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("request name: %v %v", reply, err)
+	}
+
+	client, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return &testBus{client: client, service: service, file: file}
+}
+
+func (b *testBus) call(t *testing.T, path dbus.ObjectPath, method string, args ...interface{}) *dbus.Call {
+	t.Helper()
+	return b.client.Object(BusName, path).Call(method, 0, args...)
+}
+
+func (b *testBus) openPlain(t *testing.T) dbus.ObjectPath {
+	t.Helper()
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	if err := b.call(t, servicePath, serviceIface+".OpenSession", algorithmPlain, dbus.MakeVariant("")).Store(&output, &session); err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+func (b *testBus) defaultCollection(t *testing.T) dbus.ObjectPath {
+	t.Helper()
+	var collection dbus.ObjectPath
+	if err := b.call(t, servicePath, serviceIface+".ReadAlias", "default").Store(&collection); err != nil {
+		t.Fatal(err)
+	}
+	if collection != collectionPath("Main") {
+		t.Fatalf("default collection is %s", collection)
+	}
+	return collection
+}
+
+func (b *testBus) createItem(t *testing.T, session dbus.ObjectPath, label string, attrs map[string]string, value string) dbus.ObjectPath {
+	t.Helper()
+	properties := map[string]dbus.Variant{
+		itemIface + ".Label":      dbus.MakeVariant(label),
+		itemIface + ".Attributes": dbus.MakeVariant(attrs),
+	}
+	secret := Secret{Session: session, Parameters: []byte{}, Value: []byte(value), ContentType: "text/plain"}
+	var item, prompt dbus.ObjectPath
+	if err := b.call(t, b.defaultCollection(t), collectionIface+".CreateItem", properties, secret, true).Store(&item, &prompt); err != nil {
+		t.Fatal(err)
+	}
+	if prompt != noPrompt {
+		t.Fatalf("CreateItem asked for prompt %s", prompt)
+	}
+	return item
+}
+
+func TestCreateSearchAndGetSecret(t *testing.T) {
+	b := startService(t)
+	session := b.openPlain(t)
+	attrs := map[string]string{"service": "mail", "user": "alice"}
+	item := b.createItem(t, session, "Mail", attrs, "hunter2")
+
+	var unlocked, locked []dbus.ObjectPath
+	if err := b.call(t, servicePath, serviceIface+".SearchItems", map[string]string{"service": "mail"}).Store(&unlocked, &locked); err != nil {
+		t.Fatal(err)
+	}
+	if len(unlocked) != 1 || unlocked[0] != item || len(locked) != 0 {
+		t.Fatalf("SearchItems = %v %v, want [%s]", unlocked, locked, item)
+	}
+	if err := b.call(t, servicePath, serviceIface+".SearchItems", map[string]string{"service": "other"}).Store(&unlocked, &locked); err != nil {
+		t.Fatal(err)
+	}
+	if len(unlocked) != 0 {
+		t.Fatalf("SearchItems for another service = %v", unlocked)
+	}
+
+	var secret Secret
+	if err := b.call(t, item, itemIface+".GetSecret", session).Store(&secret); err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Value) != "hunter2" || secret.ContentType != "text/plain" {
+		t.Fatalf("GetSecret = %q %q", secret.Value, secret.ContentType)
+	}
+
+	label, err := b.client.Object(BusName, item).GetProperty(itemIface + ".Label")
+	if err != nil || label.Value() != "Mail" {
+		t.Fatalf("Label = %v %v", label, err)
+	}
+
+	databases, err := controller.GetAllDatabases(b.file, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, database := range databases {
+		for _, group := range database.SecretGroups {
+			for _, secret := range group.Secrets {
+				if group.Name == "Secret Service" && secret.Title == "Mail" {
+					found = true
+				}
+			}
+		}
+	}
+	if !found {
+		t.Fatal("item was not stored in the vault")
+	}
+}
+
+func TestReplaceAndDeleteItem(t *testing.T) {
+	b := startService(t)
+	session := b.openPlain(t)
+	attrs := map[string]string{"service": "mail"}
+	first := b.createItem(t, session, "Mail", attrs, "one")
+	second := b.createItem(t, session, "Mail", attrs, "two")
+	if first != second {
+		t.Fatalf("replace created %s next to %s", second, first)
+	}
+
+	var secret Secret
+	if err := b.call(t, first, itemIface+".GetSecret", session).Store(&secret); err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Value) != "two" {
+		t.Fatalf("GetSecret after replace = %q", secret.Value)
+	}
+
+	var prompt dbus.ObjectPath
+	if err := b.call(t, first, itemIface+".Delete").Store(&prompt); err != nil {
+		t.Fatal(err)
+	}
+	err := b.call(t, first, itemIface+".GetSecret", session).Store(&secret)
+	if err == nil {
+		t.Fatal("GetSecret on a deleted item succeeded")
+	}
+}
+
+func TestEncryptedSession(t *testing.T) {
+	b := startService(t)
+	private, err := rand.Int(rand.Reader, new(big.Int).Sub(dhPrime, big.NewInt(2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	private.Add(private, big.NewInt(1))
+	public := new(big.Int).Exp(big.NewInt(2), private, dhPrime)
+
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	if err := b.call(t, servicePath, serviceIface+".OpenSession", algorithmDH, dbus.MakeVariant(public.Bytes())).Store(&output, &session); err != nil {
+		t.Fatal(err)
+	}
+	theirs, ok := output.Value().([]byte)
+	if !ok {
+		t.Fatalf("OpenSession returned %v", output)
+	}
+	shared := new(big.Int).Exp(new(big.Int).SetBytes(theirs), private, dhPrime).Bytes()
+	padded := make([]byte, (dhPrime.BitLen()+7)/8)
+	copy(padded[len(padded)-len(shared):], shared)
+	key := make([]byte, 16)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, padded, nil, nil), key); err != nil {
+		t.Fatal(err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	rand.Read(iv)
+	plaintext := append([]byte("s3cret"), bytes.Repeat([]byte{10}, 10)...)
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, plaintext)
+	properties := map[string]dbus.Variant{
+		itemIface + ".Label":      dbus.MakeVariant("Encrypted"),
+		itemIface + ".Attributes": dbus.MakeVariant(map[string]string{"kind": "dh"}),
+	}
+	var item, prompt dbus.ObjectPath
+	if err := b.call(t, b.defaultCollection(t), collectionIface+".CreateItem", properties, Secret{Session: session, Parameters: iv, Value: ciphertext, ContentType: "text/plain"}, false).Store(&item, &prompt); err != nil {
+		t.Fatal(err)
+	}
+
+	var secrets map[dbus.ObjectPath]Secret
+	if err := b.call(t, servicePath, serviceIface+".GetSecrets", []dbus.ObjectPath{item}, session).Store(&secrets); err != nil {
+		t.Fatal(err)
+	}
+	secret, ok := secrets[item]
+	if !ok || bytes.Equal(secret.Value, []byte("s3cret")) {
+		t.Fatalf("GetSecrets returned %v", secrets)
+	}
+	decrypted := make([]byte, len(secret.Value))
+	cipher.NewCBCDecrypter(block, secret.Parameters).CryptBlocks(decrypted, secret.Value)
+	if string(decrypted[:len(decrypted)-int(decrypted[len(decrypted)-1])]) != "s3cret" {
+		t.Fatalf("decrypted secret %q", decrypted)
+	}
+
+	if err := b.call(t, servicePath, serviceIface+".OpenSession", algorithmDH, dbus.MakeVariant([]byte{1})).Store(&output, &session); err == nil {
+		t.Fatal("OpenSession accepted an invalid public key")
+	}
+}
+
+func TestLockAndPromptUnlock(t *testing.T) {
+	b := startService(t)
+	session := b.openPlain(t)
+	item := b.createItem(t, session, "Mail", map[string]string{"service": "mail"}, "hunter2")
+
+	var objects []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	if err := b.call(t, servicePath, serviceIface+".Lock", []dbus.ObjectPath{item}).Store(&objects, &prompt); err != nil {
+		t.Fatal(err)
+	}
+	var secret Secret
+	err := b.call(t, item, itemIface+".GetSecret", session).Store(&secret)
+	if dbusErr, ok := err.(dbus.Error); !ok || dbusErr.Name != "org.freedesktop.Secret.Error.IsLocked" {
+		t.Fatalf("GetSecret while locked = %v", err)
+	}
+
+	if err := b.call(t, servicePath, serviceIface+".Unlock", []dbus.ObjectPath{item}).Store(&objects, &prompt); err != nil {
+		t.Fatal(err)
+	}
+	if prompt == noPrompt || len(objects) != 0 {
+		t.Fatalf("Unlock = %v %s, want a prompt", objects, prompt)
+	}
+	if err := b.client.AddMatchSignal(dbus.WithMatchObjectPath(prompt), dbus.WithMatchInterface(promptIface)); err != nil {
+		t.Fatal(err)
+	}
+	signals := make(chan *dbus.Signal, 1)
+	b.client.Signal(signals)
+	if err := b.call(t, prompt, promptIface+".Prompt", "").Err; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case signal := <-signals:
+		if dismissed, _ := signal.Body[0].(bool); dismissed {
+			t.Fatal("prompt was dismissed")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("prompt did not complete")
+	}
+	if err := b.call(t, item, itemIface+".GetSecret", session).Store(&secret); err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Value) != "hunter2" {
+		t.Fatalf("GetSecret after unlock = %q", secret.Value)
+	}
+}
@@ -0,0 +1,114 @@
+package secretservice
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/godbus/dbus/v5"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	algorithmPlain = "plain"
+	algorithmDH    = "dh-ietf1024-sha256-aes128-cbc-pkcs7"
+)
+
+var dhPrime, _ = new(big.Int).SetString(
+	"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD1"+
+		"29024E088A67CC74020BBEA63B139B22514A08798E3404DD"+
+		"EF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245"+
+		"E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED"+
+		"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE65381"+
+		"FFFFFFFFFFFFFFFF", 16)
+
+var errUnsupportedAlgorithm = errors.New("unsupported session algorithm")
+
+type Secret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+type session struct {
+	path   dbus.ObjectPath
+	sender string
+	key    []byte
+}
+
+func newSession(path dbus.ObjectPath, sender string, algorithm string, input dbus.Variant) (*session, dbus.Variant, error) {
+	switch algorithm {
+	case algorithmPlain:
+		return &session{path: path, sender: sender}, dbus.MakeVariant(""), nil
+	case algorithmDH:
+		public, ok := input.Value().([]byte)
+		if !ok {
+			return nil, dbus.Variant{}, fmt.Errorf("%s expects a byte array", algorithmDH)
+		}
+		theirs := new(big.Int).SetBytes(public)
+		if theirs.Cmp(big.NewInt(1)) <= 0 || theirs.Cmp(new(big.Int).Sub(dhPrime, big.NewInt(1))) >= 0 {
+			return nil, dbus.Variant{}, fmt.Errorf("invalid public key")
+		}
+		private, err := rand.Int(rand.Reader, new(big.Int).Sub(dhPrime, big.NewInt(2)))
+		if err != nil {
+			return nil, dbus.Variant{}, err
+		}
+		private.Add(private, big.NewInt(1))
+		mine := new(big.Int).Exp(big.NewInt(2), private, dhPrime)
+		shared := new(big.Int).Exp(theirs, private, dhPrime).Bytes()
+		padded := make([]byte, (dhPrime.BitLen()+7)/8)
+		copy(padded[len(padded)-len(shared):], shared)
+		key := make([]byte, 16)
+		if _, err := io.ReadFull(hkdf.New(sha256.New, padded, nil, nil), key); err != nil {
+			return nil, dbus.Variant{}, err
+		}
+		return &session{path: path, sender: sender, key: key}, dbus.MakeVariant(mine.Bytes()), nil
+	}
+	return nil, dbus.Variant{}, errUnsupportedAlgorithm
+}
+
+func (s *session) encrypt(value []byte, contentType string) (Secret, error) {
+	if s.key == nil {
+		return Secret{Session: s.path, Parameters: []byte{}, Value: value, ContentType: contentType}, nil
+	}
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return Secret{}, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return Secret{}, err
+	}
+	padding := aes.BlockSize - len(value)%aes.BlockSize
+	plaintext := append(append([]byte{}, value...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, plaintext)
+	return Secret{Session: s.path, Parameters: iv, Value: ciphertext, ContentType: contentType}, nil
+}
+
+func (s *session) decrypt(secret Secret) ([]byte, error) {
+	if s.key == nil {
+		return secret.Value, nil
+	}
+	if len(secret.Parameters) != aes.BlockSize || len(secret.Value) == 0 || len(secret.Value)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("invalid encrypted secret")
+	}
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(secret.Value))
+	cipher.NewCBCDecrypter(block, secret.Parameters).CryptBlocks(plaintext, secret.Value)
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize || !bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, fmt.Errorf("invalid secret padding")
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
@@ -0,0 +1,191 @@
+package security
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type BreachProvider interface {
+	Range(prefix string) (map[string]int, error)
+}
+
+type DirectoryProvider struct {
+	Dir string
+}
+
+type FileProvider struct {
+	File string
+}
+
+func NewBreachProvider(path string) (BreachProvider, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &DirectoryProvider{Dir: path}, nil
+	}
+	return &FileProvider{File: path}, nil
+}
+
+func CheckBreached(provider BreachProvider, password string) (int, error) {
+	if provider == nil {
+		return 0, fmt.Errorf("no breach provider")
+	}
+	if password == "" {
+		return 0, nil
+	}
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	suffixes, err := provider.Range(hash[:5])
+	if err != nil {
+		return 0, err
+	}
+	return suffixes[hash[5:]], nil
+}
+
+func (p *DirectoryProvider) Range(prefix string) (map[string]int, error) {
+	prefix = strings.ToUpper(prefix)
+	if !isRangePrefix(prefix) {
+		return nil, fmt.Errorf("invalid range prefix")
+	}
+	file, err := os.Open(filepath.Join(p.Dir, prefix+".txt"))
+	if os.IsNotExist(err) {
+		return map[string]int{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseRange(file)
+}
+
+func (p *FileProvider) Range(prefix string) (map[string]int, error) {
+	prefix = strings.ToUpper(prefix)
+	if !isRangePrefix(prefix) {
+		return nil, fmt.Errorf("invalid range prefix")
+	}
+	file, err := os.Open(p.File)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	start, err := seekRange(file, info.Size(), prefix)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	suffixes := map[string]int{}
+	scanner := bufio.NewScanner(file)
+	if start > 0 {
+		scanner.Scan()
+	}
+	for scanner.Scan() {
+		hash, count, ok := parseRangeLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		if !strings.HasPrefix(hash, prefix) {
+			if hash > prefix {
+				break
+			}
+			continue
+		}
+		suffixes[hash[len(prefix):]] = count
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return suffixes, nil
+}
+
+func seekRange(file *os.File, size int64, prefix string) (int64, error) {
+	low, high := int64(0), size
+	for high-low > 4096 {
+		mid := low + (high-low)/2
+		hash, err := hashAfter(file, mid)
+		if err != nil {
+			return 0, err
+		}
+		if hash == "" || hash[:len(prefix)] >= prefix {
+			high = mid
+		} else {
+			low = mid
+		}
+	}
+	return low, nil
+}
+
+func hashAfter(file *os.File, offset int64) (string, error) {
+	buf := make([]byte, 256)
+	n, err := file.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	buf = buf[:n]
+	if offset > 0 {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			return "", nil
+		}
+		buf = buf[i+1:]
+	}
+	if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+		buf = buf[:i]
+	}
+	hash, _, ok := parseRangeLine(string(buf))
+	if !ok || len(hash) < 5 {
+		return "", nil
+	}
+	return hash, nil
+}
+
+func parseRange(r io.Reader) (map[string]int, error) {
+	suffixes := map[string]int{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		suffix, count, ok := parseRangeLine(scanner.Text())
+		if ok {
+			suffixes[suffix] = count
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return suffixes, nil
+}
+
+func parseRangeLine(line string) (string, int, bool) {
+	line = strings.TrimSpace(line)
+	hash, count, found := strings.Cut(line, ":")
+	if !found || hash == "" {
+		return "", 0, false
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil {
+		return "", 0, false
+	}
+	return strings.ToUpper(hash), n, true
+}
+
+func isRangePrefix(prefix string) bool {
+	if len(prefix) != 5 {
+		return false
+	}
+	_, err := hex.DecodeString(prefix + "0")
+	return err == nil
+}
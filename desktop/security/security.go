@@ -1,9 +1,13 @@
 package security
 
 import (
+	"crypto/hmac"
 	cryptorand "crypto/rand"
 	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"hash"
+	"io"
 	"log"
 	"math/rand"
 	"os"
@@ -127,6 +131,25 @@ func DecryptFile(file string, password string, decrypted_file string) bool {
 	return true
 }
 
+func HashFile(password string, file string) (string, int64, error) {
+	infile, err := os.Open(file)
+	if err != nil {
+		return "", 0, err
+	}
+	defer infile.Close()
+	mac := NewHasher(password)
+	size, err := io.Copy(mac, infile)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(mac.Sum(nil)), size, nil
+}
+
+func NewHasher(password string) hash.Hash {
+	key := sha256.Sum256([]byte(password))
+	return hmac.New(sha256.New, key[:])
+}
+
 func GenerateStrongPassword(length int, lower, upper, digit, special bool) string {
 	var characters string
 
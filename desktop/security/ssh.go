@@ -0,0 +1,74 @@
+package security
+
+import (
+	"crypto/ed25519"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	SSHKeyEd25519 = "ed25519"
+	SSHKeyRSA     = "rsa"
+)
+
+func GenerateSSHKey(kind string, bits int, comment string, passphrase string) (string, error) {
+	var key interface{}
+	switch kind {
+	case SSHKeyEd25519:
+		_, private, err := ed25519.GenerateKey(cryptorand.Reader)
+		if err != nil {
+			return "", err
+		}
+		key = private
+	case SSHKeyRSA:
+		if bits < 2048 {
+			return "", fmt.Errorf("rsa keys must be at least 2048 bits")
+		}
+		private, err := rsa.GenerateKey(cryptorand.Reader, bits)
+		if err != nil {
+			return "", err
+		}
+		key = private
+	default:
+		return "", fmt.Errorf("unsupported key type %s", kind)
+	}
+	var block *pem.Block
+	var err error
+	if passphrase != "" {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(key, comment, []byte(passphrase))
+	} else {
+		block, err = ssh.MarshalPrivateKey(key, comment)
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(block)), nil
+}
+
+func ParseSSHKey(privateKey string, passphrase string) (interface{}, error) {
+	if passphrase != "" {
+		return ssh.ParseRawPrivateKeyWithPassphrase([]byte(privateKey), []byte(passphrase))
+	}
+	return ssh.ParseRawPrivateKey([]byte(privateKey))
+}
+
+func SSHPublicKey(privateKey string, passphrase string, comment string) (string, string, error) {
+	key, err := ParseSSHKey(privateKey, passphrase)
+	if err != nil {
+		return "", "", err
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	public := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+	if comment != "" {
+		public += " " + comment
+	}
+	return public, ssh.FingerprintSHA256(signer.PublicKey()), nil
+}
@@ -0,0 +1,63 @@
+package security
+
+import (
+	"encoding/base32"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+type TOTPCode struct {
+	Code      string
+	Period    int
+	Remaining int
+}
+
+func parseTOTP(spec string) (string, totp.ValidateOpts, error) {
+	opts := totp.ValidateOpts{Period: 30, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "otpauth://") {
+		key, err := otp.NewKeyFromURL(spec)
+		if err != nil {
+			return "", opts, err
+		}
+		if key.Type() != "totp" {
+			return "", opts, fmt.Errorf("otpauth uri is not a totp key")
+		}
+		if period := key.Period(); period > 0 {
+			opts.Period = uint(period)
+		}
+		if key.Digits() != 0 {
+			opts.Digits = key.Digits()
+		}
+		opts.Algorithm = key.Algorithm()
+		spec = key.Secret()
+	}
+	secret := strings.ToUpper(strings.ReplaceAll(spec, " ", ""))
+	secret = strings.TrimRight(secret, "=")
+	if _, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret); err != nil || secret == "" {
+		return "", opts, fmt.Errorf("totp secret is not valid base32")
+	}
+	return secret, opts, nil
+}
+
+func ValidateTOTP(spec string) bool {
+	_, _, err := parseTOTP(spec)
+	return err == nil
+}
+
+func GenerateTOTP(spec string, t time.Time) (TOTPCode, error) {
+	secret, opts, err := parseTOTP(spec)
+	if err != nil {
+		return TOTPCode{}, err
+	}
+	code, err := totp.GenerateCodeCustom(secret, t, opts)
+	if err != nil {
+		return TOTPCode{}, err
+	}
+	period := int(opts.Period)
+	return TOTPCode{Code: code, Period: period, Remaining: period - int(t.Unix()%int64(period))}, nil
+}
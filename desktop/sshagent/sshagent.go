@@ -0,0 +1,245 @@
+package sshagent
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+var ErrLocked = errors.New("agent is locked")
+var ErrUnsupported = errors.New("keys are managed in the vault")
+var ErrDenied = errors.New("signing request denied")
+
+type Key struct {
+	Name    string
+	Key     interface{}
+	Confirm bool
+}
+
+type entry struct {
+	name    string
+	signer  ssh.Signer
+	confirm bool
+}
+
+type Agent struct {
+	mu         sync.Mutex
+	keys       []entry
+	passphrase []byte
+	confirm    func(name string) bool
+	listener   net.Listener
+}
+
+func New(confirm func(name string) bool) *Agent {
+	return &Agent{confirm: confirm}
+}
+
+func DefaultSocket() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), fmt.Sprintf("finalpass-%d", os.Getuid()))
+	}
+	return filepath.Join(dir, "finalpass-ssh.sock")
+}
+
+func (a *Agent) Listen(path string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.listener != nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		conn, err2 := net.Dial("unix", path)
+		if err2 == nil {
+			conn.Close()
+			return fmt.Errorf("socket %s is already in use", path)
+		}
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return err
+	}
+	a.listener = listener
+	go a.serve(listener)
+	return nil
+}
+
+func (a *Agent) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Println(err)
+			}
+			return
+		}
+		go func() {
+			defer conn.Close()
+			if err := agent.ServeAgent(a, conn); err != nil && err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				log.Println(err)
+			}
+		}()
+	}
+}
+
+func (a *Agent) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.keys = nil
+	if a.listener == nil {
+		return nil
+	}
+	err := a.listener.Close()
+	a.listener = nil
+	return err
+}
+
+func (a *Agent) SetKeys(keys []Key) error {
+	var entries []entry
+	for _, key := range keys {
+		signer, err := ssh.NewSignerFromKey(key.Key)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key.Name, err)
+		}
+		entries = append(entries, entry{name: key.Name, signer: signer, confirm: key.Confirm})
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.keys = entries
+	return nil
+}
+
+func (a *Agent) Clear() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.keys = nil
+}
+
+func (a *Agent) List() ([]*agent.Key, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.passphrase != nil {
+		return []*agent.Key{}, nil
+	}
+	keys := []*agent.Key{}
+	for _, key := range a.keys {
+		public := key.signer.PublicKey()
+		keys = append(keys, &agent.Key{
+			Format:  public.Type(),
+			Blob:    public.Marshal(),
+			Comment: key.name,
+		})
+	}
+	return keys, nil
+}
+
+func (a *Agent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return a.SignWithFlags(key, data, 0)
+}
+
+func (a *Agent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	a.mu.Lock()
+	if a.passphrase != nil {
+		a.mu.Unlock()
+		return nil, ErrLocked
+	}
+	var found *entry
+	for i := range a.keys {
+		if bytes.Equal(a.keys[i].signer.PublicKey().Marshal(), key.Marshal()) {
+			found = &a.keys[i]
+			break
+		}
+	}
+	a.mu.Unlock()
+	if found == nil {
+		return nil, errors.New("key not found")
+	}
+	if found.confirm && (a.confirm == nil || !a.confirm(found.name)) {
+		return nil, ErrDenied
+	}
+	if flags == 0 {
+		return found.signer.Sign(nil, data)
+	}
+	algorithmSigner, ok := found.signer.(ssh.AlgorithmSigner)
+	if !ok {
+		return nil, fmt.Errorf("signature does not support non-default signature algorithm: %T", found.signer)
+	}
+	switch {
+	case flags&agent.SignatureFlagRsaSha256 != 0:
+		return algorithmSigner.SignWithAlgorithm(nil, data, ssh.KeyAlgoRSASHA256)
+	case flags&agent.SignatureFlagRsaSha512 != 0:
+		return algorithmSigner.SignWithAlgorithm(nil, data, ssh.KeyAlgoRSASHA512)
+	}
+	return nil, fmt.Errorf("unsupported signature flags: %d", flags)
+}
+
+func (a *Agent) Add(key agent.AddedKey) error {
+	return ErrUnsupported
+}
+
+func (a *Agent) Remove(key ssh.PublicKey) error {
+	return ErrUnsupported
+}
+
+func (a *Agent) RemoveAll() error {
+	a.Clear()
+	return nil
+}
+
+func (a *Agent) Lock(passphrase []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.passphrase != nil {
+		return ErrLocked
+	}
+	a.passphrase = append([]byte{}, passphrase...)
+	return nil
+}
+
+func (a *Agent) Unlock(passphrase []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.passphrase == nil {
+		return errors.New("agent is not locked")
+	}
+	if subtle.ConstantTimeCompare(a.passphrase, passphrase) != 1 {
+		return errors.New("incorrect passphrase")
+	}
+	a.passphrase = nil
+	return nil
+}
+
+func (a *Agent) Signers() ([]ssh.Signer, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.passphrase != nil {
+		return nil, ErrLocked
+	}
+	var signers []ssh.Signer
+	for _, key := range a.keys {
+		signers = append(signers, key.signer)
+	}
+	return signers, nil
+}
+
+func (a *Agent) Extension(extensionType string, contents []byte) ([]byte, error) {
+	return nil, agent.ErrExtensionUnsupported
+}
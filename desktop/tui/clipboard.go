@@ -0,0 +1,24 @@
+package tui
+
+import (
+	"encoding/base64"
+	"io"
+	"os"
+	"strings"
+)
+
+func osc52(text string) string {
+	sequence := "\x1b]52;c;" + base64.StdEncoding.EncodeToString([]byte(text)) + "\a"
+	if os.Getenv("TMUX") != "" {
+		return sequence + "\x1bPtmux;" + strings.ReplaceAll(sequence, "\x1b", "\x1b\x1b") + "\x1b\\"
+	}
+	if strings.HasPrefix(os.Getenv("TERM"), "screen") {
+		return "\x1bP" + sequence + "\x1b\\"
+	}
+	return sequence
+}
+
+func Copy(w io.Writer, text string) error {
+	_, err := io.WriteString(w, osc52(text))
+	return err
+}
@@ -0,0 +1,209 @@
+package tui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"desktop/controller"
+	"desktop/models"
+	"desktop/security"
+
+	"github.com/rivo/tview"
+)
+
+func (t *TUI) secretForm(where location, secret models.Secret) {
+	form := tview.NewForm().SetItemPadding(0)
+	form.SetBorder(true)
+	dismiss := func() {
+		t.pages.RemovePage("secret")
+		t.app.SetFocus(t.table)
+	}
+	form.SetCancelFunc(dismiss)
+	var build func() (models.Secret, string)
+	switch secret.Type {
+	case models.SecretTypeLogin, "":
+		build = loginForm(form, secret)
+	case models.SecretTypeNote:
+		build = noteForm(form, secret)
+	default:
+		build = entryForm(form, secret)
+	}
+	if secret.ID != 0 {
+		form.SetTitle(" Edit " + label(secret.Type) + " ")
+		form.AddTextView("Created at:", secret.Created_at, 0, 1, false, false)
+		form.AddTextView("Updated at:", secret.Updated_at, 0, 1, false, false)
+	} else {
+		form.SetTitle(" Create " + label(secret.Type) + " ")
+	}
+	form.AddButton("Save", func() {
+		result, problem := build()
+		if problem != "" {
+			t.showError(problem)
+			return
+		}
+		result.ID = secret.ID
+		result.Type = secret.Type
+		if t.saveSecret(where, result) {
+			dismiss()
+		}
+	})
+	form.AddButton("Cancel", dismiss)
+	t.pages.AddPage("secret", center(form, 80, 32), true, true)
+}
+
+func label(kind string) string {
+	for _, t := range secretTypes {
+		if t.Type == kind {
+			return strings.ToLower(t.Label)
+		}
+	}
+	return "secret"
+}
+
+func text(form *tview.Form, label string) string {
+	switch item := form.GetFormItemByLabel(label).(type) {
+	case *tview.InputField:
+		return item.GetText()
+	case *tview.TextArea:
+		return item.GetText()
+	}
+	return ""
+}
+
+func loginForm(form *tview.Form, secret models.Secret) func() (models.Secret, string) {
+	length := 20
+	lower := true
+	upper := true
+	digits := true
+	special := true
+	values := controller.DecodeFields(secret)
+	password := string(secret.Password)
+	if secret.ID == 0 {
+		password = security.GenerateStrongPassword(length, lower, upper, digits, special)
+	}
+	match := 0
+	for i, mode := range models.MatchModes {
+		if mode == controller.SecretMatch(secret) {
+			match = i
+		}
+	}
+
+	form.AddInputField("Title:", secret.Title, 0, nil, nil)
+	form.AddInputField("Username:", secret.Username, 0, nil, nil)
+	form.AddPasswordField("Password:", password, 0, '*', nil)
+	form.AddPasswordField("Repeat password:", password, 0, '*', nil)
+	form.AddInputField("Length:", strconv.Itoa(length), 4, tview.InputFieldInteger, func(value string) {
+		if n, err := strconv.Atoi(value); err == nil {
+			length = n
+		}
+	})
+	form.AddCheckbox("Lowercase:", lower, func(checked bool) {
+		lower = checked
+	})
+	form.AddCheckbox("Uppercase:", upper, func(checked bool) {
+		upper = checked
+	})
+	form.AddCheckbox("Digits:", digits, func(checked bool) {
+		digits = checked
+	})
+	form.AddCheckbox("Special characters:", special, func(checked bool) {
+		special = checked
+	})
+	form.AddInputField("URL:", secret.URL, 0, nil, nil)
+	form.AddTextArea("More URLs:", values["urls"], 0, 3, 0, nil)
+	form.AddDropDown("Match:", models.MatchModes, match, nil)
+	form.AddPasswordField("TOTP:", values["totp"], 0, '*', nil)
+	form.AddTextArea("Description:", secret.Description, 0, 4, 0, nil)
+	form.AddButton("Generate", func() {
+		if length < 4 || length > 30 || !(lower || upper || digits || special) {
+			return
+		}
+		generated := security.GenerateStrongPassword(length, lower, upper, digits, special)
+		form.GetFormItemByLabel("Password:").(*tview.InputField).SetText(generated)
+		form.GetFormItemByLabel("Repeat password:").(*tview.InputField).SetText(generated)
+	})
+
+	return func() (models.Secret, string) {
+		if text(form, "Password:") != text(form, "Repeat password:") {
+			return models.Secret{}, "Missing username or passwords do not match!"
+		}
+		totp := text(form, "TOTP:")
+		if totp != "" && !security.ValidateTOTP(totp) {
+			return models.Secret{}, "Invalid TOTP secret!"
+		}
+		_, mode := form.GetFormItemByLabel("Match:").(*tview.DropDown).GetCurrentOption()
+		values["totp"] = totp
+		values["urls"] = strings.TrimSpace(text(form, "More URLs:"))
+		values["match"] = mode
+		if values["match"] == models.MatchDomain {
+			values["match"] = ""
+		}
+		for name, value := range values {
+			if value == "" {
+				delete(values, name)
+			}
+		}
+		result := models.Secret{
+			Title:       text(form, "Title:"),
+			Username:    text(form, "Username:"),
+			Password:    []byte(text(form, "Password:")),
+			URL:         text(form, "URL:"),
+			Description: text(form, "Description:"),
+		}
+		if len(values) > 0 {
+			result.Fields = controller.EncodeFields(values)
+		}
+		if err := controller.ValidateMatch(mode, controller.SecretURLs(result)); err != nil {
+			return models.Secret{}, "Invalid URL: " + err.Error()
+		}
+		return result, ""
+	}
+}
+
+func noteForm(form *tview.Form, secret models.Secret) func() (models.Secret, string) {
+	form.AddInputField("Title:", secret.Title, 0, nil, nil)
+	form.AddTextArea("Note:", string(secret.Note), 0, 15, 0, nil)
+	return func() (models.Secret, string) {
+		if text(form, "Title:") == "" || text(form, "Note:") == "" {
+			return models.Secret{}, "Title or note is missing!"
+		}
+		return models.Secret{
+			Title:    text(form, "Title:"),
+			Password: []byte{},
+			Note:     []byte(text(form, "Note:")),
+		}, ""
+	}
+}
+
+func entryForm(form *tview.Form, secret models.Secret) func() (models.Secret, string) {
+	fields := models.SecretFields[secret.Type]
+	values := controller.DecodeFields(secret)
+	form.AddInputField("Title:", secret.Title, 0, nil, nil)
+	for _, field := range fields {
+		if field.Hidden {
+			form.AddPasswordField(field.Label+":", values[field.Name], 0, '*', nil)
+		} else {
+			form.AddInputField(field.Label+":", values[field.Name], 0, nil, nil)
+		}
+	}
+	form.AddTextArea("Notes:", secret.Description, 0, 4, 0, nil)
+	return func() (models.Secret, string) {
+		if text(form, "Title:") == "" {
+			return models.Secret{}, "Title is missing!"
+		}
+		for _, field := range fields {
+			values[field.Name] = strings.TrimSpace(text(form, field.Label+":"))
+		}
+		if err := controller.ValidateFields(secret.Type, values); err != nil {
+			return models.Secret{}, fmt.Sprintf("Please correct the %s!", strings.TrimPrefix(err.Error(), "invalid "))
+		}
+		return models.Secret{
+			Title:       text(form, "Title:"),
+			Username:    values[fields[0].Name],
+			Password:    []byte{},
+			Fields:      controller.EncodeFields(values),
+			Description: text(form, "Notes:"),
+		}, ""
+	}
+}
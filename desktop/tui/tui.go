@@ -0,0 +1,540 @@
+package tui
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"desktop/controller"
+	"desktop/models"
+	"desktop/security"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+const revealTimeout = 10 * time.Second
+
+const asterisk = "********"
+
+const help = "[yellow]/[white] search  [yellow]tab[white] switch  [yellow]a[white] add  [yellow]e[white] edit  [yellow]d[white] delete  [yellow]u[white] copy username  [yellow]c[white] copy password  [yellow]o[white] copy totp  [yellow]r[white] reveal  [yellow]g[white] new group  [yellow]b[white] new sub database  [yellow]q[white] quit"
+
+var secretTypes = []struct {
+	Type  string
+	Label string
+}{
+	{models.SecretTypeLogin, "Login"},
+	{models.SecretTypeNote, "Secure note"},
+	{models.SecretTypeCard, "Payment card"},
+	{models.SecretTypeIdentity, "Identity"},
+	{models.SecretTypeBank, "Bank account"},
+}
+
+type location struct {
+	database string
+	group    string
+}
+
+type TUI struct {
+	file      string
+	password  string
+	databases []models.Database
+	resolver  *controller.Resolver
+	entries   []models.Entry
+	revealed  map[int]string
+	app       *tview.Application
+	pages     *tview.Pages
+	tree      *tview.TreeView
+	table     *tview.Table
+	search    *tview.InputField
+	status    *tview.TextView
+}
+
+func Run(file string, password string) error {
+	t := &TUI{file: file, password: password, revealed: map[int]string{}}
+	if err := t.load(); err != nil {
+		return err
+	}
+	t.app = tview.NewApplication()
+	t.search = tview.NewInputField().SetLabel("Search: ")
+	t.tree = tview.NewTreeView()
+	t.tree.SetBorder(true).SetTitle(" " + filepath.Base(file) + " ")
+	t.table = tview.NewTable().SetSelectable(true, false).SetFixed(1, 0)
+	t.table.SetBorder(true)
+	t.status = tview.NewTextView().SetDynamicColors(true)
+	t.status.SetText(help)
+
+	t.search.SetChangedFunc(func(query string) {
+		t.showSearch(query)
+	})
+	t.search.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEscape {
+			t.search.SetText("")
+		}
+		t.app.SetFocus(t.table)
+	})
+	t.tree.SetChangedFunc(func(node *tview.TreeNode) {
+		t.search.SetText("")
+		t.showNode(node)
+	})
+	t.tree.SetInputCapture(t.treeKeys)
+	t.table.SetSelectedFunc(func(row int, column int) {
+		t.editSecret()
+	})
+	t.table.SetInputCapture(t.tableKeys)
+
+	body := tview.NewFlex().
+		AddItem(t.tree, 0, 1, false).
+		AddItem(t.table, 0, 3, true)
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(t.search, 1, 0, false).
+		AddItem(body, 0, 1, true).
+		AddItem(t.status, 1, 0, false)
+	t.pages = tview.NewPages().AddPage("main", layout, true, true)
+
+	t.buildTree(location{})
+	t.app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if t.pages.GetPageCount() > 1 {
+			return event
+		}
+		switch {
+		case event.Key() == tcell.KeyTab:
+			if t.table.HasFocus() {
+				t.app.SetFocus(t.tree)
+			} else {
+				t.app.SetFocus(t.table)
+			}
+			return nil
+		case event.Rune() == '/' && !t.search.HasFocus():
+			t.app.SetFocus(t.search)
+			return nil
+		case event.Rune() == 'q' && !t.search.HasFocus():
+			t.app.Stop()
+			return nil
+		}
+		return event
+	})
+	return t.app.SetRoot(t.pages, true).EnableMouse(true).SetFocus(t.table).Run()
+}
+
+func (t *TUI) load() error {
+	resolver, err := controller.NewResolver(t.file, t.password)
+	if err != nil {
+		return err
+	}
+	t.resolver = resolver
+	t.databases = resolver.Databases()
+	return nil
+}
+
+func (t *TUI) reload(current location) {
+	if err := t.load(); err != nil {
+		log.Println(err)
+		t.showError("Failed to load the vault!")
+		return
+	}
+	t.buildTree(current)
+}
+
+func (t *TUI) buildTree(current location) {
+	root := tview.NewTreeNode(filepath.Base(t.file)).SetReference(location{}).SetColor(tcell.ColorYellow)
+	selected := root
+	for _, database := range t.databases {
+		node := tview.NewTreeNode(database.Name).SetReference(location{database: database.Name}).SetColor(tcell.ColorGreen)
+		root.AddChild(node)
+		if current.database == database.Name && current.group == "" {
+			selected = node
+		}
+		for _, group := range database.SecretGroups {
+			child := tview.NewTreeNode(group.Name).SetReference(location{database: database.Name, group: group.Name})
+			node.AddChild(child)
+			if current.database == database.Name && current.group == group.Name {
+				selected = child
+			}
+		}
+	}
+	t.tree.SetRoot(root).SetCurrentNode(selected)
+	t.showNode(selected)
+}
+
+func (t *TUI) current() location {
+	node := t.tree.GetCurrentNode()
+	if node == nil {
+		return location{}
+	}
+	return node.GetReference().(location)
+}
+
+func (t *TUI) showNode(node *tview.TreeNode) {
+	if node == nil {
+		return
+	}
+	where := node.GetReference().(location)
+	var entries []models.Entry
+	for _, database := range t.databases {
+		if where.database != "" && database.Name != where.database {
+			continue
+		}
+		for _, group := range database.SecretGroups {
+			if where.group != "" && group.Name != where.group {
+				continue
+			}
+			for _, secret := range group.Secrets {
+				entries = append(entries, models.Entry{Database: database.Name, Group: group.Name, Secret: secret})
+			}
+		}
+	}
+	t.table.SetTitle(" " + node.GetText() + " ")
+	t.showEntries(entries)
+}
+
+func (t *TUI) showSearch(query string) {
+	if query == "" {
+		t.showNode(t.tree.GetCurrentNode())
+		return
+	}
+	t.table.SetTitle(" Search: " + query + " ")
+	t.showEntries(controller.FuzzySearch(t.databases, query))
+}
+
+func (t *TUI) showEntries(entries []models.Entry) {
+	t.entries = entries
+	t.table.Clear()
+	for column, header := range []string{"ID", "Title", "Username", "Password", "URL", "Description", "Updated At", "Database", "Group", "Type"} {
+		t.table.SetCell(0, column, tview.NewTableCell(header).SetTextColor(tcell.ColorYellow).SetSelectable(false))
+	}
+	for i, entry := range entries {
+		secret := entry.Secret
+		password := ""
+		if secret.Type == models.SecretTypeLogin || secret.Type == "" {
+			password = asterisk
+		}
+		if value, ok := t.revealed[secret.ID]; ok {
+			password = value
+		}
+		row := []string{strconv.Itoa(secret.ID), secret.Title, secret.Username, password, secret.URL, secret.Description, secret.Updated_at, entry.Database, entry.Group, secret.Type}
+		for column, text := range row {
+			t.table.SetCell(i+1, column, tview.NewTableCell(tview.Escape(text)).SetMaxWidth(40))
+		}
+	}
+	t.table.Select(1, 0)
+	t.table.ScrollToBeginning()
+}
+
+func (t *TUI) selected() (models.Entry, bool) {
+	row, _ := t.table.GetSelection()
+	if row < 1 || row > len(t.entries) {
+		return models.Entry{}, false
+	}
+	entry := t.entries[row-1]
+	decrypted, err := t.resolver.Entry(fmt.Sprintf("%s/%s/@%d", entry.Database, entry.Group, entry.Secret.ID))
+	if err != nil {
+		log.Println(err)
+		t.showError("Failed to read secret!")
+		return models.Entry{}, false
+	}
+	return decrypted, true
+}
+
+func (t *TUI) tableKeys(event *tcell.EventKey) *tcell.EventKey {
+	switch event.Rune() {
+	case 'a':
+		t.addSecret()
+	case 'e':
+		t.editSecret()
+	case 'd':
+		t.deleteSecret()
+	case 'u':
+		t.copyField("username")
+	case 'c':
+		t.copyField("password")
+	case 'o':
+		t.copyTOTP()
+	case 'r':
+		t.reveal()
+	case 'g':
+		t.addGroup()
+	case 'b':
+		t.addDatabase()
+	default:
+		return event
+	}
+	return nil
+}
+
+func (t *TUI) treeKeys(event *tcell.EventKey) *tcell.EventKey {
+	switch event.Rune() {
+	case 'a':
+		t.addSecret()
+	case 'd':
+		t.deleteNode()
+	case 'g':
+		t.addGroup()
+	case 'b':
+		t.addDatabase()
+	default:
+		return event
+	}
+	return nil
+}
+
+func (t *TUI) setStatus(message string) {
+	t.status.SetText(message)
+	go func() {
+		time.Sleep(3 * time.Second)
+		t.app.QueueUpdateDraw(func() {
+			if t.status.GetText(false) == message {
+				t.status.SetText(help)
+			}
+		})
+	}()
+}
+
+func (t *TUI) copyField(name string) {
+	entry, ok := t.selected()
+	if !ok {
+		return
+	}
+	value, err := controller.SecretField(entry.Secret, name)
+	if err != nil || value == "" {
+		t.setStatus(fmt.Sprintf("%s has no %s", entry.Secret.Title, name))
+		return
+	}
+	if err := Copy(os.Stdout, value); err != nil {
+		log.Println(err)
+		t.showError("Failed to copy " + name + "!")
+		return
+	}
+	t.setStatus(fmt.Sprintf("Copied %s of %s", name, entry.Secret.Title))
+}
+
+func (t *TUI) copyTOTP() {
+	entry, ok := t.selected()
+	if !ok {
+		return
+	}
+	spec := controller.DecodeFields(entry.Secret)["totp"]
+	if spec == "" {
+		t.setStatus(fmt.Sprintf("%s has no totp secret", entry.Secret.Title))
+		return
+	}
+	code, err := security.GenerateTOTP(spec, time.Now())
+	if err != nil {
+		log.Println(err)
+		t.showError("Failed to generate TOTP code!")
+		return
+	}
+	if err := Copy(os.Stdout, code.Code); err != nil {
+		log.Println(err)
+		t.showError("Failed to copy TOTP code!")
+		return
+	}
+	t.setStatus(fmt.Sprintf("Copied TOTP code of %s, valid for %d seconds", entry.Secret.Title, code.Remaining))
+}
+
+func (t *TUI) reveal() {
+	entry, ok := t.selected()
+	if !ok || (entry.Secret.Type != models.SecretTypeLogin && entry.Secret.Type != "") {
+		return
+	}
+	id := entry.Secret.ID
+	t.revealed[id] = string(entry.Secret.Password)
+	t.refreshPasswords()
+	time.AfterFunc(revealTimeout, func() {
+		t.app.QueueUpdateDraw(func() {
+			delete(t.revealed, id)
+			t.refreshPasswords()
+		})
+	})
+}
+
+func (t *TUI) refreshPasswords() {
+	for i, entry := range t.entries {
+		if entry.Secret.Type != models.SecretTypeLogin && entry.Secret.Type != "" {
+			continue
+		}
+		text := asterisk
+		if value, ok := t.revealed[entry.Secret.ID]; ok {
+			text = value
+		}
+		t.table.GetCell(i+1, 3).SetText(tview.Escape(text))
+	}
+}
+
+func (t *TUI) addSecret() {
+	where := t.current()
+	if where.group == "" {
+		t.showError("Select a secret group first!")
+		return
+	}
+	var labels []string
+	for _, kind := range secretTypes {
+		labels = append(labels, kind.Label)
+	}
+	labels = append(labels, "Cancel")
+	modal := tview.NewModal().SetText("Which kind of secret?").AddButtons(labels)
+	modal.SetDoneFunc(func(index int, label string) {
+		t.pages.RemovePage("kind")
+		if index < 0 || index >= len(secretTypes) {
+			return
+		}
+		t.secretForm(where, models.Secret{Type: secretTypes[index].Type})
+	})
+	t.pages.AddPage("kind", modal, true, true)
+}
+
+func (t *TUI) editSecret() {
+	entry, ok := t.selected()
+	if !ok {
+		return
+	}
+	if entry.Secret.Type == models.SecretTypeSSH {
+		t.showError("Edit SSH keys in the desktop app or with finalpass edit!")
+		return
+	}
+	t.secretForm(location{database: entry.Database, group: entry.Group}, entry.Secret)
+}
+
+func (t *TUI) saveSecret(where location, secret models.Secret) bool {
+	var err error
+	if secret.ID == 0 {
+		_, err = controller.CreateSecret(t.file, t.password, where.database, where.group, secret)
+	} else {
+		_, err = controller.UpdateSecret(t.file, t.password, where.database, where.group, secret.ID, secret)
+	}
+	if err != nil {
+		log.Println(err)
+		t.showError("Failed to save secret!")
+		return false
+	}
+	t.reload(t.current())
+	return true
+}
+
+func (t *TUI) deleteSecret() {
+	entry, ok := t.selected()
+	if !ok {
+		return
+	}
+	t.confirm(fmt.Sprintf("Delete %s from %s/%s?", entry.Secret.Title, entry.Database, entry.Group), func() {
+		if err := controller.DeleteSecret(t.file, t.password, entry.Database, entry.Group, entry.Secret.ID); err != nil {
+			log.Println(err)
+			t.showError("Failed to delete secret!")
+			return
+		}
+		t.reload(t.current())
+	})
+}
+
+func (t *TUI) deleteNode() {
+	where := t.current()
+	switch {
+	case where.group != "":
+		t.confirm(fmt.Sprintf("Delete secret group %s/%s and all its secrets?", where.database, where.group), func() {
+			if err := controller.DeleteSecretGroup(t.file, t.password, where.database, where.group); err != nil {
+				log.Println(err)
+				t.showError("Failed to delete secret group!")
+				return
+			}
+			t.reload(location{database: where.database})
+		})
+	case where.database != "":
+		t.confirm(fmt.Sprintf("Delete sub database %s and all its secrets?", where.database), func() {
+			if err := controller.DeleteDatabase(t.file, t.password, where.database); err != nil {
+				log.Println(err)
+				t.showError("Failed to delete sub database!")
+				return
+			}
+			t.reload(location{})
+		})
+	}
+}
+
+func (t *TUI) addGroup() {
+	where := t.current()
+	if where.database == "" {
+		t.showError("Select a sub database first!")
+		return
+	}
+	t.prompt("New secret group", "Name", func(name string) {
+		if _, err := controller.FindSecretGroup(t.databases, where.database, name); err == nil {
+			t.showError("Secret group already exists!")
+			return
+		}
+		if _, err := controller.CreateSecretGroup(t.file, t.password, where.database, name); err != nil {
+			log.Println(err)
+			t.showError("Failed to create secret group!")
+			return
+		}
+		t.reload(location{database: where.database, group: name})
+	})
+}
+
+func (t *TUI) addDatabase() {
+	t.prompt("New sub database", "Name", func(name string) {
+		if _, err := controller.FindDatabase(t.databases, name); err == nil {
+			t.showError("Sub database already exists!")
+			return
+		}
+		if _, err := controller.CreateSubDatabase(t.file, t.password, name); err != nil {
+			log.Println(err)
+			t.showError("Failed to create sub database!")
+			return
+		}
+		t.reload(location{database: name})
+	})
+}
+
+func (t *TUI) prompt(title string, label string, done func(string)) {
+	form := tview.NewForm()
+	form.AddInputField(label+":", "", 30, nil, nil)
+	dismiss := func() {
+		t.pages.RemovePage("prompt")
+		t.app.SetFocus(t.table)
+	}
+	form.AddButton("OK", func() {
+		name := form.GetFormItem(0).(*tview.InputField).GetText()
+		if name == "" {
+			return
+		}
+		dismiss()
+		done(name)
+	})
+	form.AddButton("Cancel", dismiss)
+	form.SetCancelFunc(dismiss)
+	form.SetBorder(true).SetTitle(" " + title + " ")
+	t.pages.AddPage("prompt", center(form, 50, 7), true, true)
+}
+
+func (t *TUI) confirm(message string, yes func()) {
+	modal := tview.NewModal().SetText(message).AddButtons([]string{"Yes", "No"})
+	modal.SetDoneFunc(func(index int, label string) {
+		t.pages.RemovePage("confirm")
+		t.app.SetFocus(t.table)
+		if label == "Yes" {
+			yes()
+		}
+	})
+	t.pages.AddPage("confirm", modal, true, true)
+}
+
+func (t *TUI) showError(message string) {
+	modal := tview.NewModal().SetText(message).AddButtons([]string{"OK"})
+	modal.SetDoneFunc(func(index int, label string) {
+		t.pages.RemovePage("error")
+	})
+	t.pages.AddPage("error", modal, true, true)
+}
+
+func center(p tview.Primitive, width int, height int) tview.Primitive {
+	return tview.NewFlex().
+		AddItem(nil, 0, 1, false).
+		AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(nil, 0, 1, false).
+			AddItem(p, height, 1, true).
+			AddItem(nil, 0, 1, false), width, 1, true).
+		AddItem(nil, 0, 1, false)
+}
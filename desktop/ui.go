@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"os"
+	"strconv"
 
 	"desktop/models"
 	"desktop/views"
@@ -29,6 +30,18 @@ func main() {
 	}
 	models.Url = url
 	models.Password = password
+	if client := os.Getenv("CLIENT"); client != "" {
+		models.Client = client
+	}
+	models.BreachPath = os.Getenv("BREACH_PATH")
+	if size := os.Getenv("MAX_ATTACHMENT_MB"); size != "" {
+		mb, err := strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			log.Fatal("MAX_ATTACHMENT_MB environment variable is not a number")
+		}
+		models.MaxAttachmentSize = mb * 1024 * 1024
+	}
+	models.SSHAuthSock = os.Getenv("SSH_AGENT_SOCKET")
 	app := widgets.NewQApplication(len(os.Args), os.Args)
 	window := widgets.NewQMainWindow(nil, 0)
 	icon := gui.NewQIcon5("icons/main.svg")
@@ -50,5 +63,7 @@ func main() {
 	window.SetCentralWidget(central)
 	window.Show()
 	views.Inits()
+	views.WatchPairings()
 	app.Exec()
+	views.StopAgent()
 }
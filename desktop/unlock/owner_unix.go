@@ -0,0 +1,20 @@
+//go:build unix
+
+package unlock
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+func checkOwner(path string, info os.FileInfo, mode os.FileMode) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("%s is not owned by the current user", path)
+	}
+	if mode != 0 && info.Mode().Perm() != mode {
+		return fmt.Errorf("%s must have mode %o, not %o", path, mode, info.Mode().Perm())
+	}
+	return nil
+}
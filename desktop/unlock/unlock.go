@@ -0,0 +1,98 @@
+package unlock
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/term"
+)
+
+var ErrNoTerminal = errors.New("no terminal to read the password from, set FINALPASS_PASSWORD or use --password-fd")
+
+func RuntimeDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("finalpass-%d", os.Getuid()))
+}
+
+func SecureDir(dir string, create bool) error {
+	info, err := os.Lstat(dir)
+	if create && os.IsNotExist(err) {
+		if err2 := os.Mkdir(dir, 0700); err2 != nil {
+			return err2
+		}
+		info, err = os.Lstat(dir)
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("%s is a symlink", dir)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return checkOwner(dir, info, 0700)
+}
+
+func CheckSocket(socket string) error {
+	if err := SecureDir(filepath.Dir(socket), false); err != nil {
+		return err
+	}
+	info, err := os.Lstat(socket)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s is not a socket", socket)
+	}
+	return checkOwner(socket, info, 0)
+}
+
+func Listen(socket string) (net.Listener, error) {
+	if err := SecureDir(filepath.Dir(socket), true); err != nil {
+		return nil, err
+	}
+	if info, err := os.Lstat(socket); err == nil {
+		if err2 := checkOwner(socket, info, 0); err2 != nil {
+			return nil, err2
+		}
+		conn, err2 := net.Dial("unix", socket)
+		if err2 == nil {
+			conn.Close()
+			return nil, fmt.Errorf("socket %s is already in use", socket)
+		}
+		os.Remove(socket)
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socket, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+func ReadPassword(prompt string) (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return "", ErrNoTerminal
+		}
+		fmt.Fprint(os.Stderr, prompt)
+		password, err2 := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		return string(password), err2
+	}
+	defer tty.Close()
+	fmt.Fprint(tty, prompt)
+	password, err3 := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
+	return string(password), err3
+}
@@ -7,6 +7,8 @@ import (
 	"io"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 
 	"desktop/models"
 
@@ -34,7 +36,7 @@ func Login() (string, string, error) {
 	totpLabel.SetVisible(false)
 	layout.AddWidget(totpLabel, 0, core.Qt__AlignLeft)
 	totp := widgets.NewQLineEdit(nil)
-	totp.SetPlaceholderText("Code")
+	totp.SetPlaceholderText("Authenticator or recovery code")
 	totp.SetVisible(false)
 	layout.AddWidget(totp, 0, 0)
 	checkbox := widgets.NewQCheckBox(nil)
@@ -47,19 +49,29 @@ func Login() (string, string, error) {
 		}
 	})
 	layout.AddWidget(checkbox, 0, core.Qt__AlignLeft)
+	forgot := widgets.NewQPushButton2("Forgot password?", nil)
+	forgot.SetFlat(true)
+	forgot.ConnectClicked(func(checked bool) {
+		ForgotPassword(email.Text())
+	})
+	layout.AddWidget(forgot, 0, core.Qt__AlignLeft)
+	resend := widgets.NewQPushButton2("Resend verification", nil)
+	resend.SetFlat(true)
+	resend.ConnectClicked(func(checked bool) {
+		ResendVerification(email.Text())
+	})
+	layout.AddWidget(resend, 0, core.Qt__AlignLeft)
 	buttons := widgets.NewQDialogButtonBox(nil)
 	buttons.SetOrientation(core.Qt__Horizontal)
 	buttons.SetStandardButtons(widgets.QDialogButtonBox__Ok | widgets.QDialogButtonBox__Cancel)
 	var token string = ""
 	buttons.ConnectAccepted(func() {
 		if email.Text() != "" && password.Text() != "" {
-			var data []byte
-			if totp.Text() != "" {
-				data = []byte(fmt.Sprintf(`{"username":"%s","password":"%s","totp":"%s"}`, email.Text(), password.Text(), totp.Text()))
-			} else {
-				data = []byte(fmt.Sprintf(`{"username":"%s","password":"%s"}`, email.Text(), password.Text()))
+			device, err3 := os.Hostname()
+			if err3 != nil {
+				log.Println(err3)
 			}
-			res, err := controller.SendRequest(fmt.Sprintf("%s/login", models.Url), "POST", data, "")
+			res, err := controller.Login(email.Text(), password.Text(), totp.Text(), device)
 			if err != nil {
 				showError(err.Error())
 			} else {
@@ -78,11 +90,17 @@ func Login() (string, string, error) {
 				if res.StatusCode == 200 {
 					showInfo(fmt.Sprintf("%s as %s.", data["message"].(string), email.Text()))
 					token = data["token"].(string)
+					models.AccessToken = token
+					models.RefreshToken, _ = data["refresh_token"].(string)
 					dialog.Accept()
+				} else if res.StatusCode == 429 {
+					showError(tooManyAttempts(res.Header.Get("Retry-After")))
 				} else if res.StatusCode == 405 {
 					showInfo("Authenticator code required!")
 					totpLabel.SetVisible(true)
 					totp.SetVisible(true)
+				} else if totp.IsVisible() {
+					showError("Wrong email, password or authenticator code!")
 				} else {
 					showError("Wrong email or password!")
 				}
@@ -103,6 +121,162 @@ func Login() (string, string, error) {
 	return "", "", fmt.Errorf("Login failed")
 }
 
+func ForgotPassword(address string) {
+	if emailRequest("Forgot password", "Enter the email of your account and we will send you a reset code.", "password/forgot", address) {
+		ResetPassword()
+	}
+}
+
+func ResendVerification(address string) {
+	emailRequest("Resend verification", "Enter the email you registered with and we will send you a new verification link.", "verify/resend", address)
+}
+
+func emailRequest(title string, text string, path string, address string) bool {
+	dialog := widgets.NewQDialog(nil, 0)
+	dialog.SetWindowTitle(title)
+	layout := widgets.NewQVBoxLayout2(dialog)
+	dialog.SetLayout(layout)
+	layout.AddWidget(widgets.NewQLabel2(text, nil, 0), 0, core.Qt__AlignLeft)
+	email := widgets.NewQLineEdit(nil)
+	email.SetPlaceholderText("Email")
+	email.SetText(address)
+	layout.AddWidget(email, 0, 0)
+	buttons := widgets.NewQDialogButtonBox(nil)
+	buttons.SetOrientation(core.Qt__Horizontal)
+	buttons.SetStandardButtons(widgets.QDialogButtonBox__Ok | widgets.QDialogButtonBox__Cancel)
+	buttons.ConnectAccepted(func() {
+		if !controller.ValidateEmail(email.Text()) {
+			showError("Email is missing or invalid!")
+			return
+		}
+		data, err := json.Marshal(map[string]string{"username": email.Text()})
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		res, err2 := controller.SendRequest(fmt.Sprintf("%s/%s", models.Url, path), "POST", data, "")
+		if err2 != nil {
+			showError(err2.Error())
+			return
+		}
+		defer res.Body.Close()
+		var body map[string]interface{}
+		err3 := json.NewDecoder(res.Body).Decode(&body)
+		if err3 != nil {
+			log.Println(err3)
+			return
+		}
+		if res.StatusCode == 200 {
+			showInfo(body["message"].(string) + ".")
+			dialog.Accept()
+		} else if res.StatusCode == 429 {
+			showError(tooManyAttempts(res.Header.Get("Retry-After")))
+		} else {
+			showError("Failed to send the email!")
+		}
+	})
+	buttons.ConnectRejected(func() {
+		dialog.Reject()
+	})
+	layout.AddWidget(buttons, 0, core.Qt__AlignRight)
+	dialog.SetModal(true)
+	dialog.Show()
+	return dialog.Exec() == int(widgets.QDialog__Accepted)
+}
+
+func ResetPassword() bool {
+	dialog := widgets.NewQDialog(nil, 0)
+	dialog.SetWindowTitle("Reset password")
+	layout := widgets.NewQVBoxLayout2(dialog)
+	dialog.SetLayout(layout)
+	warning := widgets.NewQLabel2("This only resets the password of your Finalpass account and logs out all your devices.\nYour vaults stay encrypted with their master passwords, which can not be reset or recovered.", nil, 0)
+	warning.SetStyleSheet("color: red")
+	layout.AddWidget(warning, 0, core.Qt__AlignLeft)
+	layout.AddWidget(widgets.NewQLabel2("Reset code", nil, 0), 0, core.Qt__AlignLeft)
+	code := widgets.NewQLineEdit(nil)
+	code.SetPlaceholderText("Reset code from the email")
+	layout.AddWidget(code, 0, 0)
+	layout.AddWidget(widgets.NewQLabel2("New password", nil, 0), 0, core.Qt__AlignLeft)
+	password := widgets.NewQLineEdit(nil)
+	password.SetPlaceholderText("Password")
+	password.SetEchoMode(2)
+	layout.AddWidget(password, 0, 0)
+	repeat := widgets.NewQLineEdit(nil)
+	repeat.SetPlaceholderText("Repeat")
+	repeat.SetEchoMode(2)
+	layout.AddWidget(repeat, 0, 0)
+	checkbox := widgets.NewQCheckBox(nil)
+	checkbox.SetText("Show password")
+	checkbox.ConnectStateChanged(func(state int) {
+		if state == int(core.Qt__Checked) {
+			password.SetEchoMode(0)
+			repeat.SetEchoMode(0)
+		} else {
+			password.SetEchoMode(2)
+			repeat.SetEchoMode(2)
+		}
+	})
+	layout.AddWidget(checkbox, 0, core.Qt__AlignLeft)
+	totpLabel := widgets.NewQLabel2("Code", nil, 0)
+	totpLabel.SetVisible(false)
+	layout.AddWidget(totpLabel, 0, core.Qt__AlignLeft)
+	totp := widgets.NewQLineEdit(nil)
+	totp.SetPlaceholderText("Authenticator or recovery code")
+	totp.SetVisible(false)
+	layout.AddWidget(totp, 0, 0)
+	buttons := widgets.NewQDialogButtonBox(nil)
+	buttons.SetOrientation(core.Qt__Horizontal)
+	buttons.SetStandardButtons(widgets.QDialogButtonBox__Ok | widgets.QDialogButtonBox__Cancel)
+	buttons.ConnectAccepted(func() {
+		if code.Text() == "" || password.Text() != repeat.Text() || !controller.IsPasswordSecure(password.Text()) {
+			showError("Reset code is missing or passwords dont match or password is too weak!")
+			return
+		}
+		res, err2 := controller.ResetPassword(code.Text(), password.Text(), totp.Text())
+		if err2 != nil {
+			showError(err2.Error())
+			return
+		}
+		defer res.Body.Close()
+		var body map[string]interface{}
+		err3 := json.NewDecoder(res.Body).Decode(&body)
+		if err3 != nil {
+			log.Println(err3)
+			return
+		}
+		if res.StatusCode == 200 {
+			showInfo(body["message"].(string) + ".")
+			dialog.Accept()
+		} else if res.StatusCode == 405 {
+			showInfo("Authenticator code required!")
+			totpLabel.SetVisible(true)
+			totp.SetVisible(true)
+		} else if res.StatusCode == 429 {
+			showError(tooManyAttempts(res.Header.Get("Retry-After")))
+		} else {
+			showError(body["message"].(string) + "!")
+		}
+	})
+	buttons.ConnectRejected(func() {
+		dialog.Reject()
+	})
+	layout.AddWidget(buttons, 0, core.Qt__AlignRight)
+	dialog.SetModal(true)
+	dialog.Show()
+	return dialog.Exec() == int(widgets.QDialog__Accepted)
+}
+
+func tooManyAttempts(retryAfter string) string {
+	seconds, err := strconv.Atoi(retryAfter)
+	if err != nil || seconds <= 0 {
+		return "Too many attempts, try again later!"
+	}
+	if seconds < 60 {
+		return fmt.Sprintf("Too many attempts, try again in %d seconds!", seconds)
+	}
+	return fmt.Sprintf("Too many attempts, try again in %d minutes!", (seconds+59)/60)
+}
+
 func Register() bool {
 	dialog := widgets.NewQDialog(nil, 0)
 	dialog.SetWindowTitle("Register")
@@ -142,6 +316,13 @@ func Register() bool {
 	label2.SetVisible(false)
 	layout.AddWidget(label2, 0, core.Qt__AlignLeft)
 
+	resend := widgets.NewQPushButton2("Resend verification", nil)
+	resend.SetFlat(true)
+	resend.ConnectClicked(func(checked bool) {
+		ResendVerification(email.Text())
+	})
+	layout.AddWidget(resend, 0, core.Qt__AlignLeft)
+
 	password.ConnectTextChanged(func(text string) {
 		if controller.IsPasswordSecure(password.Text()) {
 			label2.SetVisible(false)
@@ -177,8 +358,7 @@ func Register() bool {
 	buttons.SetStandardButtons(widgets.QDialogButtonBox__Ok | widgets.QDialogButtonBox__Cancel)
 	buttons.ConnectAccepted(func() {
 		if email.Text() != "" && password.Text() != "" && repeat.Text() != "" && password.Text() == repeat.Text() && controller.IsPasswordSecure(password.Text()) {
-			data := []byte(fmt.Sprintf(`{"username":"%s","password":"%s"}`, email.Text(), password.Text()))
-			res, err := controller.SendRequest(fmt.Sprintf("%s/register", models.Url), "POST", data, "")
+			res, err := controller.Register(email.Text(), password.Text())
 			if err != nil {
 				showError(err.Error())
 			} else {
@@ -197,6 +377,8 @@ func Register() bool {
 				if res.StatusCode == 201 {
 					showInfo(fmt.Sprintf("Account created for %s. %s.", email.Text(), data["message"].(string)))
 					dialog.Accept()
+				} else if res.StatusCode == 429 {
+					showError(tooManyAttempts(res.Header.Get("Retry-After")))
 				} else {
 					showError("Wrong email or password!")
 				}
@@ -215,6 +397,10 @@ func Register() bool {
 }
 
 func Settings(user *models.User) {
+	err := controller.GetSettings(user)
+	if err != nil {
+		log.Println(err)
+	}
 	dialog := widgets.NewQDialog(nil, 0)
 	dialog.SetWindowTitle("Settings")
 	layout := widgets.NewQVBoxLayout2(dialog)
@@ -246,14 +432,18 @@ func Settings(user *models.User) {
 	layout.AddWidget(password, 0, 0)
 	layout.AddWidget(repeat, 0, 0)
 	layout.AddWidget(checkbox, 0, core.Qt__AlignLeft)
+	var loadSessions func()
 	passwordButton := widgets.NewQPushButton2("Change", nil)
 	passwordButton.ConnectClicked(func(checked bool) {
 		if password.Text() != repeat.Text() {
 			showError("Passwords dont match!")
 			return
 		}
-		data := []byte(fmt.Sprintf(`{"currentpassword":"%s","newpassword":"%s"}`, current.Text(), password.Text()))
-		res, err := controller.SendRequest(fmt.Sprintf("%s/user/password", models.Url), "POST", data, user.Token)
+		if !controller.IsPasswordSecure(password.Text()) {
+			showError("Password requirements not met!")
+			return
+		}
+		res, err := controller.ChangeAccountPassword(user, current.Text(), password.Text())
 		if err != nil {
 			showError(err.Error())
 		} else {
@@ -271,6 +461,7 @@ func Settings(user *models.User) {
 			}
 			if res.StatusCode == 200 {
 				showInfo(data["message"].(string))
+				loadSessions()
 			} else {
 				showError("Wrong password!")
 			}
@@ -288,92 +479,97 @@ func Settings(user *models.User) {
 	} else {
 		mfaCheckbox.SetCheckState(core.Qt__Unchecked)
 	}
+	recoveryLabel := widgets.NewQLabel2(fmt.Sprintf("%d recovery codes left", user.RecoveryCodes), nil, 0)
+	recoveryLabel.SetVisible(user.Totp)
 	mfaCheckbox.ConnectStateChanged(func(state int) {
+		var ok bool
 		if state == int(core.Qt__Checked) {
-			res, err := controller.SendRequest(fmt.Sprintf("%s/otp/generate", models.Url), "POST", nil, user.Token)
-			if err != nil {
-				showError(err.Error())
-			} else {
-				defer res.Body.Close()
-				body, err := io.ReadAll(res.Body)
-				if err != nil {
-					log.Println(err)
-					return
-				}
-				var data map[string]interface{}
-				err2 := json.Unmarshal([]byte(body), &data)
-				if err2 != nil {
-					log.Println(err2)
-					return
-				}
-				if res.StatusCode == 200 {
-					qr := data["qr"].(string)
-					message := data["message"].(string)
-					dialog := widgets.NewQDialog(nil, 0)
-					dialog.SetWindowTitle("QR code")
-					layout := widgets.NewQVBoxLayout2(dialog)
-					layout.AddWidget(widgets.NewQLabel2("Scan the QR code below with your authenticator app", nil, 0), 0, core.Qt__AlignLeft)
-					dialog.SetLayout(layout)
-					err := qrcode.WriteFile(qr, qrcode.Medium, 256, "qr.png")
-					if err != nil {
-						log.Println(err)
-						return
-					}
-					pixmap := gui.NewQPixmap()
-					pixmap.Load("qr.png", "PNG", 0)
-					os.Remove("qr.png")
-					label := widgets.NewQLabel(nil, 0)
-					label.SetPixmap(pixmap)
-					layout.AddWidget(label, 0, core.Qt__AlignCenter)
-					label2 := widgets.NewQLabel2(message, nil, 0)
-					layout.AddWidget(label2, 0, core.Qt__AlignCenter)
-					buttons := widgets.NewQDialogButtonBox(nil)
-					buttons.SetOrientation(core.Qt__Horizontal)
-					buttons.SetStandardButtons(widgets.QDialogButtonBox__Ok)
-					buttons.ConnectAccepted(func() {
-						dialog.Accept()
-					})
-					buttons.ConnectRejected(func() {
-						dialog.Reject()
-					})
-					layout.AddWidget(buttons, 0, core.Qt__AlignRight)
-					dialog.SetModal(true)
-					dialog.Show()
-					dialog.Exec()
-				} else {
-					showError("Failed to enable 2FA!")
-				}
-			}
+			ok = enableTotp(user)
 		} else {
-			res, err := controller.SendRequest(fmt.Sprintf("%s/otp/remove", models.Url), "POST", nil, user.Token)
-			if err != nil {
-				showError(err.Error())
-			} else {
-				defer res.Body.Close()
-				body, err := io.ReadAll(res.Body)
-				if err != nil {
-					log.Println(err)
-					return
-				}
-				var data map[string]interface{}
-				err2 := json.Unmarshal([]byte(body), &data)
-				if err2 != nil {
-					log.Println(err2)
-					return
-				}
-				if res.StatusCode == 200 {
-					showInfo(data["message"].(string))
-				} else {
-					showError("Failed to disable 2FA!")
-				}
-			}
+			ok = disableTotp(user)
 		}
+		if !ok {
+			mfaCheckbox.BlockSignals(true)
+			mfaCheckbox.SetChecked(state != int(core.Qt__Checked))
+			mfaCheckbox.BlockSignals(false)
+			return
+		}
+		user.Totp = state == int(core.Qt__Checked)
+		user.RecoveryCodes = 0
+		if user.Totp {
+			user.RecoveryCodes = 10
+		}
+		recoveryLabel.SetText(fmt.Sprintf("%d recovery codes left", user.RecoveryCodes))
+		recoveryLabel.SetVisible(user.Totp)
 	})
 	layout.AddWidget(mfaCheckbox, 0, core.Qt__AlignLeft)
+	layout.AddWidget(recoveryLabel, 0, core.Qt__AlignLeft)
 	separator2 := widgets.NewQFrame(nil, 0)
 	separator2.SetFrameShape(widgets.QFrame__HLine)
 	separator2.SetFrameShadow(widgets.QFrame__Sunken)
 	layout.AddWidget(separator2, 0, 0)
+	layout.AddWidget(widgets.NewQLabel2("Sessions", nil, 0), 0, core.Qt__AlignLeft)
+	sessions := widgets.NewQTableWidget(nil)
+	sessions.SetColumnCount(4)
+	sessions.SetHorizontalHeaderLabels([]string{"Device", "IP", "Last seen", "Created at"})
+	sessions.SetEditTriggers(widgets.QAbstractItemView__NoEditTriggers)
+	sessions.SetSelectionBehavior(widgets.QAbstractItemView__SelectRows)
+	sessions.SetSelectionMode(widgets.QAbstractItemView__SingleSelection)
+	sessions.VerticalHeader().SetVisible(false)
+	sessions.SetMinimumSize2(550, 150)
+	var list []models.Session
+	loadSessions = func() {
+		result, err := controller.GetSessions(user)
+		if err != nil {
+			showError("Failed to get sessions!")
+			return
+		}
+		list = result
+		sessions.ClearContents()
+		sessions.SetRowCount(0)
+		for _, session := range list {
+			row := sessions.RowCount()
+			sessions.InsertRow(row)
+			device := session.Device
+			if session.Current {
+				device += " (this device)"
+			}
+			item := widgets.NewQTableWidgetItem2(device, 0)
+			item.SetToolTip(session.UserAgent)
+			sessions.SetItem(row, 0, item)
+			sessions.SetItem(row, 1, widgets.NewQTableWidgetItem2(session.IP, 0))
+			sessions.SetItem(row, 2, widgets.NewQTableWidgetItem2(session.LastSeen, 0))
+			sessions.SetItem(row, 3, widgets.NewQTableWidgetItem2(session.CreatedAt, 0))
+		}
+	}
+	loadSessions()
+	layout.AddWidget(sessions, 0, 0)
+	revokeButton := widgets.NewQPushButton2("Revoke", nil)
+	revokeButton.ConnectClicked(func(checked bool) {
+		row := sessions.CurrentRow()
+		if row < 0 || row >= len(list) {
+			showError("No session selected!")
+			return
+		}
+		if list[row].Current {
+			showError("Use Logout to end this session!")
+			return
+		}
+		if !areYouSure(fmt.Sprintf("Log out %s?", list[row].Device)) {
+			return
+		}
+		err := controller.RevokeSession(user, list[row].ID)
+		if err != nil {
+			showError("Failed to revoke session!")
+			return
+		}
+		loadSessions()
+	})
+	layout.AddWidget(revokeButton, 0, core.Qt__AlignRight)
+	separator3 := widgets.NewQFrame(nil, 0)
+	separator3.SetFrameShape(widgets.QFrame__HLine)
+	separator3.SetFrameShadow(widgets.QFrame__Sunken)
+	layout.AddWidget(separator3, 0, 0)
 	buttons := widgets.NewQDialogButtonBox(nil)
 	buttons.SetOrientation(core.Qt__Horizontal)
 	buttons.SetStandardButtons(widgets.QDialogButtonBox__Cancel)
@@ -385,3 +581,166 @@ func Settings(user *models.User) {
 	dialog.Show()
 	dialog.Exec()
 }
+
+func enableTotp(user *models.User) bool {
+	res, err := controller.SendRequest(fmt.Sprintf("%s/otp/generate", models.Url), "POST", nil, user.Token)
+	if err != nil {
+		showError(err.Error())
+		return false
+	}
+	defer res.Body.Close()
+	var data map[string]interface{}
+	err2 := json.NewDecoder(res.Body).Decode(&data)
+	if err2 != nil {
+		log.Println(err2)
+		return false
+	}
+	if res.StatusCode != 200 {
+		showError("Failed to enable 2FA!")
+		return false
+	}
+	qr := data["qr"].(string)
+	dialog := widgets.NewQDialog(nil, 0)
+	dialog.SetWindowTitle("QR code")
+	layout := widgets.NewQVBoxLayout2(dialog)
+	layout.AddWidget(widgets.NewQLabel2("Scan the QR code below with your authenticator app", nil, 0), 0, core.Qt__AlignLeft)
+	dialog.SetLayout(layout)
+	err3 := qrcode.WriteFile(qr, qrcode.Medium, 256, "qr.png")
+	if err3 != nil {
+		log.Println(err3)
+		return false
+	}
+	pixmap := gui.NewQPixmap()
+	pixmap.Load("qr.png", "PNG", 0)
+	os.Remove("qr.png")
+	label := widgets.NewQLabel(nil, 0)
+	label.SetPixmap(pixmap)
+	layout.AddWidget(label, 0, core.Qt__AlignCenter)
+	layout.AddWidget(widgets.NewQLabel2("Enter the code from the app to turn on 2FA", nil, 0), 0, core.Qt__AlignLeft)
+	code := widgets.NewQLineEdit(nil)
+	code.SetPlaceholderText("Code")
+	layout.AddWidget(code, 0, 0)
+	var codes []string
+	buttons := widgets.NewQDialogButtonBox(nil)
+	buttons.SetOrientation(core.Qt__Horizontal)
+	buttons.SetStandardButtons(widgets.QDialogButtonBox__Ok | widgets.QDialogButtonBox__Cancel)
+	buttons.ConnectAccepted(func() {
+		body, err := json.Marshal(map[string]string{"code": code.Text()})
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		res, err2 := controller.SendRequest(fmt.Sprintf("%s/otp/confirm", models.Url), "POST", body, user.Token)
+		if err2 != nil {
+			showError(err2.Error())
+			return
+		}
+		defer res.Body.Close()
+		var data struct {
+			Message       string   `json:"message"`
+			RecoveryCodes []string `json:"recovery_codes"`
+		}
+		err3 := json.NewDecoder(res.Body).Decode(&data)
+		if err3 != nil {
+			log.Println(err3)
+			return
+		}
+		if res.StatusCode == 200 {
+			codes = data.RecoveryCodes
+			dialog.Accept()
+		} else if res.StatusCode == 429 {
+			showError(tooManyAttempts(res.Header.Get("Retry-After")))
+		} else {
+			showError("Wrong code!")
+		}
+	})
+	buttons.ConnectRejected(func() {
+		dialog.Reject()
+	})
+	layout.AddWidget(buttons, 0, core.Qt__AlignRight)
+	dialog.SetModal(true)
+	dialog.Show()
+	if dialog.Exec() != int(widgets.QDialog__Accepted) {
+		return false
+	}
+	showRecoveryCodes(codes)
+	return true
+}
+
+func showRecoveryCodes(codes []string) {
+	dialog := widgets.NewQDialog(nil, 0)
+	dialog.SetWindowTitle("Recovery codes")
+	layout := widgets.NewQVBoxLayout2(dialog)
+	dialog.SetLayout(layout)
+	layout.AddWidget(widgets.NewQLabel2("2FA is on. Save these recovery codes somewhere safe, they are only shown once.\nEach code can be used once instead of an authenticator code.", nil, 0), 0, core.Qt__AlignLeft)
+	list := widgets.NewQTextEdit(nil)
+	list.SetPlainText(strings.Join(codes, "\n"))
+	list.SetReadOnly(true)
+	layout.AddWidget(list, 0, 0)
+	copyButton := widgets.NewQPushButton2("Copy", nil)
+	copyButton.ConnectClicked(func(checked bool) {
+		gui.QGuiApplication_Clipboard().SetText(strings.Join(codes, "\n"), gui.QClipboard__Clipboard)
+	})
+	layout.AddWidget(copyButton, 0, core.Qt__AlignLeft)
+	buttons := widgets.NewQDialogButtonBox(nil)
+	buttons.SetOrientation(core.Qt__Horizontal)
+	buttons.SetStandardButtons(widgets.QDialogButtonBox__Ok)
+	buttons.ConnectAccepted(func() {
+		dialog.Accept()
+	})
+	layout.AddWidget(buttons, 0, core.Qt__AlignRight)
+	dialog.SetModal(true)
+	dialog.Show()
+	dialog.Exec()
+}
+
+func disableTotp(user *models.User) bool {
+	dialog := widgets.NewQDialog(nil, 0)
+	dialog.SetWindowTitle("Disable 2FA")
+	layout := widgets.NewQVBoxLayout2(dialog)
+	dialog.SetLayout(layout)
+	layout.AddWidget(widgets.NewQLabel2("Confirm with an authenticator code, a recovery code or your account password", nil, 0), 0, core.Qt__AlignLeft)
+	code := widgets.NewQLineEdit(nil)
+	code.SetPlaceholderText("Authenticator or recovery code")
+	layout.AddWidget(code, 0, 0)
+	password := widgets.NewQLineEdit(nil)
+	password.SetPlaceholderText("Password")
+	password.SetEchoMode(2)
+	layout.AddWidget(password, 0, 0)
+	buttons := widgets.NewQDialogButtonBox(nil)
+	buttons.SetOrientation(core.Qt__Horizontal)
+	buttons.SetStandardButtons(widgets.QDialogButtonBox__Ok | widgets.QDialogButtonBox__Cancel)
+	buttons.ConnectAccepted(func() {
+		if code.Text() == "" && password.Text() == "" {
+			showError("Code or password is missing!")
+			return
+		}
+		res, err2 := controller.DisableTotp(user, code.Text(), password.Text())
+		if err2 != nil {
+			showError(err2.Error())
+			return
+		}
+		defer res.Body.Close()
+		var data map[string]interface{}
+		err3 := json.NewDecoder(res.Body).Decode(&data)
+		if err3 != nil {
+			log.Println(err3)
+			return
+		}
+		if res.StatusCode == 200 {
+			showInfo(data["message"].(string))
+			dialog.Accept()
+		} else if res.StatusCode == 429 {
+			showError(tooManyAttempts(res.Header.Get("Retry-After")))
+		} else {
+			showError("Wrong code or password!")
+		}
+	})
+	buttons.ConnectRejected(func() {
+		dialog.Reject()
+	})
+	layout.AddWidget(buttons, 0, core.Qt__AlignRight)
+	dialog.SetModal(true)
+	dialog.Show()
+	return dialog.Exec() == int(widgets.QDialog__Accepted)
+}
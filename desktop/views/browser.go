@@ -0,0 +1,52 @@
+package views
+
+import (
+	"fmt"
+	"log"
+
+	"desktop/browser"
+
+	"github.com/therecipe/qt/core"
+)
+
+var pairingTimer *core.QTimer = nil
+var pairingDialog bool = false
+
+func WatchPairings() {
+	if pairingTimer != nil {
+		return
+	}
+	pairingTimer = core.NewQTimer(nil)
+	pairingTimer.ConnectTimeout(func() {
+		if pairingDialog {
+			return
+		}
+		pending, err := browser.Pending()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if len(pending) == 0 {
+			return
+		}
+		pairingDialog = true
+		defer func() {
+			pairingDialog = false
+		}()
+		pairing := pending[0]
+		message := fmt.Sprintf("%s (%s) wants to use Finalpass.\n\nOnly allow it if the browser shows the code %s.", pairing.Name, pairing.Origin, pairing.Code)
+		if areYouSure(message) {
+			err2 := browser.Confirm(pairing.ID)
+			if err2 != nil {
+				log.Println(err2)
+				showError("Failed to pair browser!")
+			}
+			return
+		}
+		err3 := browser.Remove(pairing.ID)
+		if err3 != nil {
+			log.Println(err3)
+		}
+	})
+	pairingTimer.Start(1000)
+}
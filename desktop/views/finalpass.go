@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"desktop/controller"
 	"desktop/models"
@@ -21,6 +22,8 @@ var tree *widgets.QTreeWidget = nil
 var group *widgets.QAction = nil
 var sub *widgets.QAction = nil
 var add *widgets.QAction = nil
+var note *widgets.QAction = nil
+var search *widgets.QLineEdit = nil
 var save *widgets.QAction = nil
 var sync *widgets.QAction = nil
 var table *widgets.QTableWidget = nil
@@ -32,6 +35,18 @@ var masterPassword string = ""
 var fileDB string = ""
 var asterisk string = "********************"
 var user models.User = models.User{}
+var secretTypes = []struct {
+	Type  string
+	Label string
+	Icon  string
+}{
+	{models.SecretTypeLogin, "Login", "icons/key.svg"},
+	{models.SecretTypeNote, "Secure note", "icons/note.svg"},
+	{models.SecretTypeCard, "Payment card", "icons/card.svg"},
+	{models.SecretTypeIdentity, "Identity", "icons/identity.svg"},
+	{models.SecretTypeBank, "Bank account", "icons/bank.svg"},
+	{models.SecretTypeSSH, "SSH key", "icons/sshkey.svg"},
+}
 
 func CreateMenu() *widgets.QMenuBar {
 	menu := widgets.NewQMenuBar(nil)
@@ -121,7 +136,13 @@ func CreateMenu() *widgets.QMenuBar {
 	logout.SetIcon(gui.NewQIcon5("icons/logout.svg"))
 	logout.SetText("Logout")
 	logout.ConnectTriggered(func(bool) {
+		err := controller.Logout(&user)
+		if err != nil {
+			log.Println(err)
+		}
 		user = models.User{}
+		models.AccessToken = ""
+		models.RefreshToken = ""
 		logout.SetEnabled(false)
 		logout.SetText("Logout")
 		login.SetEnabled(true)
@@ -150,6 +171,17 @@ func CreateMenu() *widgets.QMenuBar {
 	file.InsertAction(nil, newDatabase)
 	file.InsertAction(nil, openDatabase)
 
+	tools := menu.AddMenu2("Tools")
+
+	report := widgets.NewQAction(nil)
+	report.SetIcon(gui.NewQIcon5("icons/password.svg"))
+	report.SetText("Breach report")
+	report.ConnectTriggered(func(bool) {
+		breachReport()
+	})
+
+	tools.InsertAction(nil, report)
+
 	help := menu.AddMenu2("Help")
 
 	update := widgets.NewQAction(nil)
@@ -286,10 +318,27 @@ func CreateToolBar() *widgets.QToolBar {
 	add.SetIcon(gui.NewQIcon5("icons/key.svg"))
 	add.SetToolTip("Add new secret")
 	add.ConnectTriggered(func(bool) {
-		addSecret()
+		addSecret(models.SecretTypeLogin)
 	})
+	addMenu := widgets.NewQMenu(nil)
+	for _, t := range secretTypes {
+		kind := t.Type
+		action := addMenu.AddAction2(gui.NewQIcon5(t.Icon), t.Label)
+		action.ConnectTriggered(func(bool) {
+			addSecret(kind)
+		})
+	}
+	add.SetMenu(addMenu)
 	add.SetEnabled(false)
 
+	note = widgets.NewQAction(nil)
+	note.SetIcon(gui.NewQIcon5("icons/note.svg"))
+	note.SetToolTip("Add new secure note")
+	note.ConnectTriggered(func(bool) {
+		addSecret(models.SecretTypeNote)
+	})
+	note.SetEnabled(false)
+
 	save = widgets.NewQAction(nil)
 	save.SetIcon(gui.NewQIcon5("icons/save.svg"))
 	save.SetToolTip("Save")
@@ -359,6 +408,21 @@ func CreateToolBar() *widgets.QToolBar {
 	tool.InsertAction(nil, sub)
 	tool.InsertAction(nil, group)
 	tool.InsertAction(nil, add)
+	tool.InsertAction(nil, note)
+
+	spacer := widgets.NewQWidget(nil, 0)
+	spacer.SetSizePolicy2(widgets.QSizePolicy__Expanding, widgets.QSizePolicy__Preferred)
+	tool.AddWidget(spacer)
+
+	search = widgets.NewQLineEdit(nil)
+	search.SetPlaceholderText("Search")
+	search.SetClearButtonEnabled(true)
+	search.SetFixedWidth(200)
+	search.SetEnabled(false)
+	search.ConnectReturnPressed(func() {
+		searchSecrets(search.Text())
+	})
+	tool.AddWidget(search)
 
 	return tool
 }
@@ -381,6 +445,7 @@ func CreateSideMenu() *widgets.QWidget {
 	tree.SetAutoScrollMargin(10)
 
 	tree.ConnectItemClicked(func(item *widgets.QTreeWidgetItem, column int) {
+		search.Clear()
 		table.ClearContents()
 		table.SetRowCount(0)
 		if item.Parent().Text(0) == "" {
@@ -460,8 +525,11 @@ func CreateSideMenu() *widgets.QWidget {
 					table.SetRowCount(0)
 					group.SetEnabled(false)
 					add.SetEnabled(false)
+					note.SetEnabled(false)
+					search.SetEnabled(false)
 					save.SetEnabled(true)
 					sub.SetEnabled(false)
+					refreshAgent()
 				}
 			}
 		} else {
@@ -478,6 +546,7 @@ func CreateSideMenu() *widgets.QWidget {
 				return
 			} else {
 				tree.CurrentItem().Parent().RemoveChild(tree.CurrentItem())
+				refreshAgent()
 			}
 		}
 	})
@@ -504,9 +573,9 @@ func CreateMain() *widgets.QWidget {
 	widget.SetStyleSheet("background-color: #FFFFFF;")
 
 	table = widgets.NewQTableWidget(nil)
-	table.SetColumnCount(8)
+	table.SetColumnCount(11)
 	table.SetRowCount(0)
-	table.SetHorizontalHeaderLabels([]string{"ID", "Title", "Username", "Password", "URL", "Description", "Created At", "Updated At"})
+	table.SetHorizontalHeaderLabels([]string{"ID", "Title", "Username", "Password", "URL", "Description", "Created At", "Updated At", "Database", "Group", "Type"})
 	table.SetEditTriggers(widgets.QAbstractItemView__NoEditTriggers)
 	table.SetSelectionBehavior(widgets.QAbstractItemView__SelectRows)
 	table.SetSelectionMode(widgets.QAbstractItemView__SingleSelection)
@@ -516,59 +585,15 @@ func CreateMain() *widgets.QWidget {
 	table.SetAutoScroll(true)
 	table.SetAutoScrollMargin(10)
 	table.SetColumnHidden(0, true)
+	table.SetColumnHidden(8, true)
+	table.SetColumnHidden(9, true)
+	table.SetColumnHidden(10, true)
 	table.VerticalHeader().SetVisible(false)
 	table.SetAlternatingRowColors(true)
 	table.SetStyleSheet("alternate-background-color: #d1dce0;")
 
 	table.ConnectCellDoubleClicked(func(row int, column int) {
-		id := table.Item(row, 0).Text()
-		integer, err := strconv.Atoi(id)
-		if err != nil {
-			log.Println(err)
-			showError("Failed to update secret!")
-			return
-		}
-		if tree.CurrentItem().Parent().Text(0) == "" {
-			if tree.CurrentItem().Child(0).Text(0) != "" {
-				s, err := controller.GetSecret(fileDB, masterPassword, tree.CurrentItem().Text(0), tree.CurrentItem().Child(0).Text(0), integer)
-				if err != nil {
-					log.Println(err)
-					showError("Failed to update secret!")
-					return
-				}
-				secret := getSecret(s)
-				if secret.Username == "" && secret.Password == nil {
-					return
-				}
-				sct, err := controller.UpdateSecret(fileDB, masterPassword, tree.CurrentItem().Text(0), tree.CurrentItem().Child(0).Text(0), integer, secret)
-				if err != nil {
-					log.Println(err)
-					showError("Failed to update secret!")
-					return
-				} else {
-					setTableItems2(row, sct)
-				}
-			}
-		} else {
-			s, err := controller.GetSecret(fileDB, masterPassword, tree.CurrentItem().Parent().Text(0), tree.CurrentItem().Text(0), integer)
-			if err != nil {
-				log.Println(err)
-				showError("Failed to update secret!")
-				return
-			}
-			secret := getSecret(s)
-			if secret.Username == "" && secret.Password == nil {
-				return
-			}
-			sct, err := controller.UpdateSecret(fileDB, masterPassword, tree.CurrentItem().Parent().Text(0), tree.CurrentItem().Text(0), integer, secret)
-			if err != nil {
-				log.Println(err)
-				showError("Failed to update secret!")
-				return
-			} else {
-				setTableItems2(row, sct)
-			}
-		}
+		editRow(row)
 	})
 
 	menu := widgets.NewQMenu(nil)
@@ -595,85 +620,70 @@ func CreateMain() *widgets.QWidget {
 			showError("Failed to copy password!")
 			return
 		}
-		if tree.CurrentItem().Parent().Text(0) == "" {
-			if tree.CurrentItem().Child(0).Text(0) != "" {
-				s, err := controller.GetSecret(fileDB, masterPassword, tree.CurrentItem().Text(0), tree.CurrentItem().Child(0).Text(0), integer)
-				if err != nil {
-					log.Println(err)
-					showError("Failed to copy password!")
-					return
-				}
-				clipboard := gui.QGuiApplication_Clipboard()
-				clipboard.SetText(string(s.Password), gui.QClipboard__Clipboard)
-			}
+		d, g := secretLocation(row)
+		if d == "" {
+			return
+		}
+		s, err := controller.GetSecret(fileDB, masterPassword, d, g, integer)
+		if err != nil {
+			log.Println(err)
+			showError("Failed to copy password!")
+			return
+		}
+		clipboard := gui.QGuiApplication_Clipboard()
+		if s.Type == models.SecretTypeNote {
+			clipboard.SetText(string(s.Note), gui.QClipboard__Clipboard)
 		} else {
-			s, err := controller.GetSecret(fileDB, masterPassword, tree.CurrentItem().Parent().Text(0), tree.CurrentItem().Text(0), integer)
-			if err != nil {
-				log.Println(err)
-				showError("Failed to copy password!")
-				return
-			}
-			clipboard := gui.QGuiApplication_Clipboard()
 			clipboard.SetText(string(s.Password), gui.QClipboard__Clipboard)
 		}
 	})
 
-	separator := widgets.NewQAction(nil)
-	separator.SetSeparator(true)
-	menu.InsertAction(nil, separator)
-
-	edit := menu.AddAction("Edit")
-	edit.SetIcon(gui.NewQIcon5("icons/edit.svg"))
-	edit.ConnectTriggered(func(bool) {
+	copyTOTP := menu.AddAction("Copy TOTP code")
+	copyTOTP.SetIcon(gui.NewQIcon5("icons/password.svg"))
+	copyTOTP.ConnectTriggered(func(bool) {
 		row := table.CurrentRow()
 		id := table.Item(row, 0).Text()
 		integer, err := strconv.Atoi(id)
 		if err != nil {
 			log.Println(err)
-			showError("Failed to update secret!")
+			showError("Failed to copy TOTP code!")
 			return
 		}
-		if tree.CurrentItem().Parent().Text(0) == "" {
-			if tree.CurrentItem().Child(0).Text(0) != "" {
-				s, err := controller.GetSecret(fileDB, masterPassword, tree.CurrentItem().Text(0), tree.CurrentItem().Child(0).Text(0), integer)
-				if err != nil {
-					log.Println(err)
-					showError("Failed to update secret!")
-					return
-				}
-				secret := getSecret(s)
-				if secret.Username == "" && secret.Password == nil {
-					return
-				}
-				sct, err := controller.UpdateSecret(fileDB, masterPassword, tree.CurrentItem().Text(0), tree.CurrentItem().Child(0).Text(0), integer, secret)
-				if err != nil {
-					log.Println(err)
-					showError("Failed to update secret!")
-					return
-				} else {
-					setTableItems2(row, sct)
-				}
-			}
-		} else {
-			s, err := controller.GetSecret(fileDB, masterPassword, tree.CurrentItem().Parent().Text(0), tree.CurrentItem().Text(0), integer)
-			if err != nil {
-				log.Println(err)
-				showError("Failed to update secret!")
-				return
-			}
-			secret := getSecret(s)
-			if secret.Username == "" && secret.Password == nil {
-				return
-			}
-			sct, err := controller.UpdateSecret(fileDB, masterPassword, tree.CurrentItem().Parent().Text(0), tree.CurrentItem().Text(0), integer, secret)
-			if err != nil {
-				log.Println(err)
-				showError("Failed to update secret!")
-				return
-			} else {
-				setTableItems2(row, sct)
-			}
+		d, g := secretLocation(row)
+		if d == "" {
+			return
+		}
+		s, err := controller.GetSecret(fileDB, masterPassword, d, g, integer)
+		if err != nil {
+			log.Println(err)
+			showError("Failed to copy TOTP code!")
+			return
+		}
+		spec := controller.DecodeFields(s)["totp"]
+		if spec == "" {
+			showInfo("This secret has no TOTP secret.")
+			return
+		}
+		code, err := security.GenerateTOTP(spec, time.Now())
+		if err != nil {
+			log.Println(err)
+			showError("Failed to copy TOTP code!")
+			return
 		}
+		clipboard := gui.QGuiApplication_Clipboard()
+		clipboard.SetText(code.Code, gui.QClipboard__Clipboard)
+	})
+
+	copyField := menu.AddMenu3(gui.NewQIcon5("icons/copy.svg"), "Copy field")
+
+	separator := widgets.NewQAction(nil)
+	separator.SetSeparator(true)
+	menu.InsertAction(nil, separator)
+
+	edit := menu.AddAction("Edit")
+	edit.SetIcon(gui.NewQIcon5("icons/edit.svg"))
+	edit.ConnectTriggered(func(bool) {
+		editRow(table.CurrentRow())
 	})
 
 	delete := menu.AddAction("Delete")
@@ -687,27 +697,19 @@ func CreateMain() *widgets.QWidget {
 			showError("Failed to delete secret!")
 			return
 		}
-		if tree.CurrentItem().Parent().Text(0) == "" {
-			if tree.CurrentItem().Child(0).Text(0) != "" {
-				err := controller.DeleteSecret(fileDB, masterPassword, tree.CurrentItem().Text(0), tree.CurrentItem().Child(0).Text(0), integer)
-				if err != nil {
-					log.Println(err)
-					showError("Failed to delete secret!")
-					return
-				}
-				table.RemoveRow(row)
-				save.SetEnabled(true)
-			}
-		} else {
-			err := controller.DeleteSecret(fileDB, masterPassword, tree.CurrentItem().Parent().Text(0), tree.CurrentItem().Text(0), integer)
-			if err != nil {
-				log.Println(err)
-				showError("Failed to delete secret!")
-				return
-			}
-			table.RemoveRow(row)
-			save.SetEnabled(true)
+		d, g := secretLocation(row)
+		if d == "" {
+			return
 		}
+		err = controller.DeleteSecret(fileDB, masterPassword, d, g, integer)
+		if err != nil {
+			log.Println(err)
+			showError("Failed to delete secret!")
+			return
+		}
+		table.RemoveRow(row)
+		save.SetEnabled(true)
+		refreshAgent()
 	})
 
 	separator2 := widgets.NewQAction(nil)
@@ -717,9 +719,17 @@ func CreateMain() *widgets.QWidget {
 	add := menu.AddAction("Add new secret")
 	add.SetIcon(gui.NewQIcon5("icons/key.svg"))
 	add.ConnectTriggered(func(bool) {
-		addSecret()
+		addSecret(models.SecretTypeLogin)
 	})
 
+	for _, t := range secretTypes[1:] {
+		kind := t.Type
+		action := menu.AddAction2(gui.NewQIcon5(t.Icon), "Add new "+strings.ToLower(t.Label))
+		action.ConnectTriggered(func(bool) {
+			addSecret(kind)
+		})
+	}
+
 	table.SetContextMenuPolicy(core.Qt__CustomContextMenu)
 
 	table.ConnectCustomContextMenuRequested(func(pos *core.QPoint) {
@@ -731,6 +741,20 @@ func CreateMain() *widgets.QWidget {
 			table.ClearSelection()
 			return
 		}
+		kind := table.Item(row, 10).Text()
+		fields, structured := models.SecretFields[kind]
+		copyUsername.SetVisible(!structured)
+		copyPassword.SetVisible(!structured)
+		copyTOTP.SetVisible(kind == models.SecretTypeLogin || kind == "")
+		copyField.MenuAction().SetVisible(structured)
+		copyField.Clear()
+		for _, field := range fields {
+			name := field.Name
+			action := copyField.AddAction(field.Label)
+			action.ConnectTriggered(func(bool) {
+				copySecretField(row, name)
+			})
+		}
 		menu.Exec2(table.MapToGlobal(pos), nil)
 	})
 
@@ -976,10 +1000,23 @@ func getSecret(secret models.Secret) models.Secret {
 	passwordField := widgets.NewQLineEdit(nil)
 	repeatField := widgets.NewQLineEdit(nil)
 	urlField := widgets.NewQLineEdit(nil)
+	urlsField := widgets.NewQTextEdit(nil)
+	matchField := widgets.NewQComboBox(nil)
+	totpField := widgets.NewQLineEdit(nil)
 	descriptionField := widgets.NewQTextEdit(nil)
 	createdField := widgets.NewQLineEdit(nil)
 	updatedField := widgets.NewQLineEdit(nil)
 
+	values := controller.DecodeFields(secret)
+	totpField.SetEchoMode(2)
+	totpField.SetPlaceholderText("otpauth:// URI or base32 secret")
+	totpField.SetText(values["totp"])
+	urlsField.SetPlaceholderText("One url or androidapp:// id per line")
+	urlsField.SetFixedHeight(60)
+	urlsField.SetText(values["urls"])
+	matchField.AddItems(models.MatchModes)
+	matchField.SetCurrentText(controller.SecretMatch(secret))
+
 	passwordField.SetText(password)
 	passwordField.SetEchoMode(2)
 	passwordField.SetStyleSheet("border: 1px solid green")
@@ -991,7 +1028,32 @@ func getSecret(secret models.Secret) models.Secret {
 	createdField.SetReadOnly(true)
 	updatedField.SetReadOnly(true)
 
+	provider := breachProvider()
+	breachLabel := widgets.NewQLabel(nil, 0)
+	breachLabel.SetStyleSheet("color: red")
+	breachLabel.SetVisible(false)
+
+	checkBreach := func() bool {
+		if provider == nil {
+			return false
+		}
+		count, err := security.CheckBreached(provider, passwordField.Text())
+		if err != nil {
+			log.Println(err)
+			breachLabel.SetVisible(false)
+			return false
+		}
+		if count > 0 {
+			breachLabel.SetText(fmt.Sprintf("This password has appeared in %d data breaches!", count))
+			breachLabel.SetVisible(true)
+			return true
+		}
+		breachLabel.SetVisible(false)
+		return false
+	}
+
 	passwordField.ConnectTextChanged(func(_ string) {
+		breachLabel.SetVisible(false)
 		if passwordField.EchoMode() == 0 {
 			repeatField.SetText(passwordField.Text())
 		}
@@ -1062,8 +1124,20 @@ func getSecret(secret models.Secret) models.Secret {
 	formLayout.AddRow3("Username:", usernameField)
 	formLayout.AddRow3("Password:", passwordField)
 	formLayout.AddRow3("Repeat password:", repeatField)
+	breachButton := widgets.NewQPushButton2("Check breaches", nil)
+	breachButton.SetEnabled(provider != nil)
+	breachButton.ConnectClicked(func(bool) {
+		if !checkBreach() && provider != nil {
+			showInfo("This password was not found in any data breach.")
+		}
+	})
+	formLayout.AddRow3("", breachButton)
+	formLayout.AddRow3("", breachLabel)
 	formLayout.AddRow3("", passSettings)
 	formLayout.AddRow3("URL:", urlField)
+	formLayout.AddRow3("More URLs:", urlsField)
+	formLayout.AddRow3("Match:", matchField)
+	formLayout.AddRow3("TOTP:", totpField)
 	formLayout.AddRow3("Description:", descriptionField)
 
 	passSettings.ConnectClicked(func(bool) {
@@ -1088,6 +1162,7 @@ func getSecret(secret models.Secret) models.Secret {
 		updatedField.SetText(secret.Updated_at)
 		formLayout.AddRow3("Created at:", createdField)
 		formLayout.AddRow3("Updated at:", updatedField)
+		formLayout.AddRow4("Attachments:", attachmentsLayout(secret.ID))
 	}
 
 	formLayout2 := widgets.NewQFormLayout(nil)
@@ -1148,7 +1223,15 @@ func getSecret(secret models.Secret) models.Secret {
 	buttons.SetOrientation(core.Qt__Horizontal)
 	buttons.SetStandardButtons(widgets.QDialogButtonBox__Ok | widgets.QDialogButtonBox__Cancel)
 	buttons.ConnectAccepted(func() {
-		if passwordField.Text() == repeatField.Text() {
+		urls := controller.SecretURLs(models.Secret{URL: urlField.Text(), Fields: controller.EncodeFields(map[string]string{"urls": urlsField.ToPlainText()})})
+		if totpField.Text() != "" && !security.ValidateTOTP(totpField.Text()) {
+			showError("Invalid TOTP secret!")
+		} else if err := controller.ValidateMatch(matchField.CurrentText(), urls); err != nil {
+			showError("Invalid URL: " + err.Error())
+		} else if passwordField.Text() == repeatField.Text() {
+			if !breachLabel.IsVisible() && checkBreach() {
+				return
+			}
 			dialog.Accept()
 		} else {
 			showError("Missing username or passwords do not match!")
@@ -1163,10 +1246,29 @@ func getSecret(secret models.Secret) models.Secret {
 	dialog.Show()
 
 	if dialog.Exec() == int(widgets.QDialog__Accepted) {
+		if totpField.Text() != "" {
+			values["totp"] = totpField.Text()
+		} else {
+			delete(values, "totp")
+		}
+		values["urls"] = strings.TrimSpace(urlsField.ToPlainText())
+		values["match"] = matchField.CurrentText()
+		if values["urls"] == "" {
+			delete(values, "urls")
+		}
+		if values["match"] == models.MatchDomain {
+			delete(values, "match")
+		}
+		var fields []byte
+		if len(values) > 0 {
+			fields = controller.EncodeFields(values)
+		}
 		return models.Secret{
+			Type:        secret.Type,
 			Title:       titleField.Text(),
 			Username:    usernameField.Text(),
 			Password:    []byte(passwordField.Text()),
+			Fields:      fields,
 			URL:         urlField.Text(),
 			Description: descriptionField.ToPlainText(),
 		}
@@ -1174,6 +1276,384 @@ func getSecret(secret models.Secret) models.Secret {
 	return models.Secret{}
 }
 
+func breachProvider() security.BreachProvider {
+	if models.BreachPath == "" {
+		return nil
+	}
+	provider, err := security.NewBreachProvider(models.BreachPath)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	return provider
+}
+
+func breachReport() {
+	if fileDB == "" {
+		showError("Open a database first!")
+		return
+	}
+	provider := breachProvider()
+	if provider == nil {
+		showError("No breach dataset configured!\n\nSet BREACH_PATH in config.env to a Pwned Passwords file or range directory.")
+		return
+	}
+	breaches, err := controller.BreachReport(fileDB, masterPassword, provider)
+	if err != nil {
+		log.Println(err)
+		showError("Failed to check for breached passwords!")
+		return
+	}
+	if len(breaches) == 0 {
+		showInfo("None of your passwords were found in the breach dataset.")
+		return
+	}
+
+	dialog := widgets.NewQDialog(nil, 0)
+	dialog.SetWindowTitle("Breach report")
+	dialog.SetMinimumSize2(600, 300)
+	layout := widgets.NewQVBoxLayout2(dialog)
+	label := widgets.NewQLabel2(fmt.Sprintf("%d of your passwords have appeared in data breaches and should be changed.", len(breaches)), nil, 0)
+	label.SetStyleSheet("color: red")
+	layout.AddWidget(label, 0, core.Qt__AlignLeft)
+
+	report := widgets.NewQTableWidget(nil)
+	report.SetColumnCount(5)
+	report.SetRowCount(0)
+	report.SetHorizontalHeaderLabels([]string{"Sub database", "Group", "Title", "Username", "Breaches"})
+	report.SetEditTriggers(widgets.QAbstractItemView__NoEditTriggers)
+	report.SetSelectionBehavior(widgets.QAbstractItemView__SelectRows)
+	report.VerticalHeader().SetVisible(false)
+	for _, breach := range breaches {
+		row := report.RowCount()
+		report.InsertRow(row)
+		title := widgets.NewQTableWidgetItem2(breach.Secret.Title, 0)
+		title.SetIcon(gui.NewQIcon5("icons/key.svg"))
+		report.SetItem(row, 0, widgets.NewQTableWidgetItem2(breach.Database, 0))
+		report.SetItem(row, 1, widgets.NewQTableWidgetItem2(breach.Group, 0))
+		report.SetItem(row, 2, title)
+		report.SetItem(row, 3, widgets.NewQTableWidgetItem2(breach.Secret.Username, 0))
+		report.SetItem(row, 4, widgets.NewQTableWidgetItem2(fmt.Sprint(breach.Count), 0))
+	}
+	layout.AddWidget(report, 0, 0)
+
+	buttons := widgets.NewQDialogButtonBox(nil)
+	buttons.SetOrientation(core.Qt__Horizontal)
+	buttons.SetStandardButtons(widgets.QDialogButtonBox__Ok)
+	buttons.ConnectAccepted(func() {
+		dialog.Accept()
+	})
+	layout.AddWidget(buttons, 0, core.Qt__AlignRight)
+
+	dialog.SetModal(true)
+	dialog.Show()
+	dialog.Exec()
+}
+
+func getNote(secret models.Secret) models.Secret {
+	dialog := widgets.NewQDialog(nil, 0)
+	dialog.SetWindowTitle("Create secure note")
+	dialog.SetMinimumSize2(500, 400)
+
+	layout := widgets.NewQVBoxLayout2(dialog)
+
+	formLayout := widgets.NewQFormLayout(nil)
+
+	titleField := widgets.NewQLineEdit(nil)
+	noteField := widgets.NewQTextEdit(nil)
+	noteField.SetAcceptRichText(false)
+	createdField := widgets.NewQLineEdit(nil)
+	updatedField := widgets.NewQLineEdit(nil)
+
+	createdField.SetReadOnly(true)
+	updatedField.SetReadOnly(true)
+
+	formLayout.AddRow3("Title:", titleField)
+	formLayout.AddRow3("Note:", noteField)
+
+	if secret.ID != 0 {
+		dialog.SetWindowTitle("Edit secure note")
+		titleField.SetText(secret.Title)
+		noteField.SetPlainText(string(secret.Note))
+		createdField.SetText(secret.Created_at)
+		updatedField.SetText(secret.Updated_at)
+		formLayout.AddRow3("Created at:", createdField)
+		formLayout.AddRow3("Updated at:", updatedField)
+		formLayout.AddRow4("Attachments:", attachmentsLayout(secret.ID))
+	}
+
+	layout.AddLayout(formLayout, 0)
+
+	buttons := widgets.NewQDialogButtonBox(nil)
+	buttons.SetOrientation(core.Qt__Horizontal)
+	buttons.SetStandardButtons(widgets.QDialogButtonBox__Ok | widgets.QDialogButtonBox__Cancel)
+	buttons.ConnectAccepted(func() {
+		if titleField.Text() != "" && noteField.ToPlainText() != "" {
+			dialog.Accept()
+		} else {
+			showError("Title or note is missing!")
+		}
+	})
+	buttons.ConnectRejected(func() {
+		dialog.Reject()
+	})
+	layout.AddWidget(buttons, 0, core.Qt__AlignRight)
+
+	dialog.SetModal(true)
+	dialog.Show()
+
+	if dialog.Exec() == int(widgets.QDialog__Accepted) {
+		return models.Secret{
+			Type:     models.SecretTypeNote,
+			Title:    titleField.Text(),
+			Password: []byte{},
+			Note:     []byte(noteField.ToPlainText()),
+		}
+	}
+	return models.Secret{}
+}
+
+func getEntry(secret models.Secret) models.Secret {
+	fields := models.SecretFields[secret.Type]
+	values := controller.DecodeFields(secret)
+
+	dialog := widgets.NewQDialog(nil, 0)
+	dialog.SetWindowTitle("Create " + secretLabel(secret.Type))
+
+	layout := widgets.NewQVBoxLayout2(dialog)
+
+	formLayout := widgets.NewQFormLayout(nil)
+
+	titleField := widgets.NewQLineEdit(nil)
+	descriptionField := widgets.NewQTextEdit(nil)
+	createdField := widgets.NewQLineEdit(nil)
+	updatedField := widgets.NewQLineEdit(nil)
+
+	createdField.SetReadOnly(true)
+	updatedField.SetReadOnly(true)
+
+	expiryLabel := widgets.NewQLabel(nil, 0)
+	expiryLabel.SetStyleSheet("color: red")
+	expiryLabel.SetVisible(false)
+
+	formLayout.AddRow3("Title:", titleField)
+
+	inputs := map[string]*widgets.QLineEdit{}
+	current := func() map[string]string {
+		result := map[string]string{}
+		for _, field := range fields {
+			if text := strings.TrimSpace(inputs[field.Name].Text()); text != "" {
+				result[field.Name] = text
+			}
+		}
+		return result
+	}
+	checkFields := func() {
+		for _, field := range fields {
+			input := inputs[field.Name]
+			if controller.ValidateField(field, input.Text()) {
+				input.SetStyleSheet("")
+			} else {
+				input.SetStyleSheet("border: 1px solid red")
+			}
+		}
+		warning := controller.ExpiryWarning(models.Secret{Type: secret.Type, Fields: controller.EncodeFields(current())}, 30*24*time.Hour)
+		expiryLabel.SetText(warning)
+		expiryLabel.SetVisible(warning != "")
+	}
+
+	for _, field := range fields {
+		input := widgets.NewQLineEdit(nil)
+		input.SetText(values[field.Name])
+		input.ConnectTextChanged(func(_ string) {
+			checkFields()
+		})
+		inputs[field.Name] = input
+
+		row := widgets.NewQHBoxLayout2(nil)
+		row.AddWidget(input, 0, 0)
+
+		if field.Hidden {
+			input.SetEchoMode(2)
+			sh := gui.NewQIcon5("icons/show.svg")
+			show := widgets.NewQPushButton3(sh, "", nil)
+			show.SetStyleSheet("border-width: 0px;")
+			show.ConnectClicked(func(bool) {
+				if input.EchoMode() == 2 {
+					input.SetEchoMode(0)
+					show.SetIcon(gui.NewQIcon5("icons/dontshow.svg"))
+				} else {
+					input.SetEchoMode(2)
+					show.SetIcon(gui.NewQIcon5("icons/show.svg"))
+				}
+			})
+			row.AddWidget(show, 0, 0)
+		}
+
+		copyButton := widgets.NewQPushButton3(gui.NewQIcon5("icons/copy.svg"), "", nil)
+		copyButton.SetStyleSheet("border-width: 0px;")
+		copyButton.SetToolTip("Copy " + strings.ToLower(field.Label))
+		copyButton.ConnectClicked(func(bool) {
+			if input.Text() != "" {
+				clipboard := gui.QGuiApplication_Clipboard()
+				clipboard.SetText(input.Text(), gui.QClipboard__Clipboard)
+			}
+		})
+		row.AddWidget(copyButton, 0, 0)
+
+		formLayout.AddRow4(field.Label+":", row)
+	}
+
+	formLayout.AddRow3("", expiryLabel)
+	formLayout.AddRow3("Notes:", descriptionField)
+
+	if secret.ID != 0 {
+		dialog.SetWindowTitle("Edit " + secretLabel(secret.Type))
+		titleField.SetText(secret.Title)
+		descriptionField.SetText(secret.Description)
+		createdField.SetText(secret.Created_at)
+		updatedField.SetText(secret.Updated_at)
+		formLayout.AddRow3("Created at:", createdField)
+		formLayout.AddRow3("Updated at:", updatedField)
+		formLayout.AddRow4("Attachments:", attachmentsLayout(secret.ID))
+	}
+
+	checkFields()
+
+	layout.AddLayout(formLayout, 0)
+
+	buttons := widgets.NewQDialogButtonBox(nil)
+	buttons.SetOrientation(core.Qt__Horizontal)
+	buttons.SetStandardButtons(widgets.QDialogButtonBox__Ok | widgets.QDialogButtonBox__Cancel)
+	buttons.ConnectAccepted(func() {
+		if titleField.Text() == "" {
+			showError("Title is missing!")
+			return
+		}
+		err := controller.ValidateFields(secret.Type, current())
+		if err != nil {
+			showError(fmt.Sprintf("Please correct the %s!", strings.TrimPrefix(err.Error(), "invalid ")))
+			return
+		}
+		dialog.Accept()
+	})
+	buttons.ConnectRejected(func() {
+		dialog.Reject()
+	})
+	layout.AddWidget(buttons, 0, core.Qt__AlignRight)
+
+	dialog.SetModal(true)
+	dialog.Show()
+
+	if dialog.Exec() == int(widgets.QDialog__Accepted) {
+		result := current()
+		return models.Secret{
+			Type:        secret.Type,
+			Title:       titleField.Text(),
+			Username:    result[fields[0].Name],
+			Password:    []byte{},
+			Description: descriptionField.ToPlainText(),
+			Fields:      controller.EncodeFields(result),
+		}
+	}
+	return models.Secret{}
+}
+
+func attachmentsLayout(id int) *widgets.QVBoxLayout {
+	layout := widgets.NewQVBoxLayout2(nil)
+	list := widgets.NewQListWidget(nil)
+	list.SetMaximumHeight(100)
+	layout.AddWidget(list, 0, 0)
+
+	attachments := []models.Attachment{}
+	reload := func() {
+		var err error
+		attachments, err = controller.GetAttachments(fileDB, masterPassword, id)
+		if err != nil {
+			log.Println(err)
+			showError("Failed to get attachments!")
+			return
+		}
+		list.Clear()
+		for _, attachment := range attachments {
+			list.AddItem(fmt.Sprintf("%s (%s)", attachment.Name, formatSize(attachment.Size)))
+		}
+	}
+
+	addButton := widgets.NewQPushButton2("Add", nil)
+	addButton.ConnectClicked(func(bool) {
+		dialog := widgets.NewQFileDialog(nil, 0)
+		file := dialog.GetOpenFileName(nil, "Add attachment", "", "All files (*)", "", 0)
+		if file == "" {
+			return
+		}
+		_, err := controller.AddAttachment(fileDB, masterPassword, id, file)
+		if err != nil {
+			log.Println(err)
+			showError(fmt.Sprintf("Failed to add attachment: %s!", err.Error()))
+			return
+		}
+		save.SetEnabled(true)
+		reload()
+	})
+
+	saveAsButton := widgets.NewQPushButton2("Save as", nil)
+	saveAsButton.ConnectClicked(func(bool) {
+		row := list.CurrentRow()
+		if row < 0 || row >= len(attachments) {
+			return
+		}
+		dialog := widgets.NewQFileDialog(nil, 0)
+		file := dialog.GetSaveFileName(nil, "Save attachment", attachments[row].Name, "All files (*)", "", 0)
+		if file == "" {
+			return
+		}
+		err := controller.SaveAttachment(fileDB, masterPassword, attachments[row].ID, file)
+		if err != nil {
+			log.Println(err)
+			showError("Failed to save attachment!")
+		}
+	})
+
+	removeButton := widgets.NewQPushButton2("Remove", nil)
+	removeButton.ConnectClicked(func(bool) {
+		row := list.CurrentRow()
+		if row < 0 || row >= len(attachments) {
+			return
+		}
+		if !areYouSure(fmt.Sprintf("Are you sure you want to remove %s?", attachments[row].Name)) {
+			return
+		}
+		err := controller.DeleteAttachment(fileDB, masterPassword, attachments[row].ID)
+		if err != nil {
+			log.Println(err)
+			showError("Failed to remove attachment!")
+			return
+		}
+		save.SetEnabled(true)
+		reload()
+	})
+
+	buttons := widgets.NewQHBoxLayout2(nil)
+	buttons.AddWidget(addButton, 0, 0)
+	buttons.AddWidget(saveAsButton, 0, 0)
+	buttons.AddWidget(removeButton, 0, 0)
+	layout.AddLayout(buttons, 0)
+
+	reload()
+	return layout
+}
+
+func formatSize(size int64) string {
+	if size < 1024 {
+		return fmt.Sprintf("%d B", size)
+	}
+	if size < 1024*1024 {
+		return fmt.Sprintf("%.1f KB", float64(size)/1024)
+	}
+	return fmt.Sprintf("%.1f MB", float64(size)/(1024*1024))
+}
+
 func saveFile() string {
 	dialog := widgets.NewQFileDialog(nil, 0)
 	file := dialog.GetSaveFileName(nil, "Create new database", "", "Database (*.db)", "", 0)
@@ -1233,21 +1713,21 @@ func openDb(f string) {
 		file = loadFile()
 	}
 	if file != "" && controller.CheckFileExist(file) {
-		databases := []models.Database{}
+		password, databases := sessionUnlock(file)
 		var err error
-		password := ""
-		for i := 0; i < 3; i++ {
+		for i := 0; i < 3 && password == ""; i++ {
 			password = getPassword(file)
 			if password != "" {
 				databases, err = controller.GetAllDatabases(file, password)
 				if err != nil {
 					log.Println(err)
 					showError("Wrong password!")
+					password = ""
 					if i == 2 {
 						return
 					}
 				} else {
-					break
+					shareSession(file, password)
 				}
 			} else {
 				return
@@ -1275,9 +1755,13 @@ func openDb(f string) {
 		}
 		group.SetEnabled(true)
 		add.SetEnabled(true)
+		note.SetEnabled(true)
+		search.SetEnabled(true)
 		sub.SetEnabled(true)
 		masterPassword = password
 		fileDB = file
+		checkExpiry()
+		startAgent()
 		err2 := controller.WriteConfig(fileDB)
 		if err2 != nil {
 			log.Println(err2)
@@ -1330,9 +1814,13 @@ func newDbFile() {
 				}
 				group.SetEnabled(true)
 				add.SetEnabled(true)
+				note.SetEnabled(true)
+				search.SetEnabled(true)
 				sub.SetEnabled(true)
 				masterPassword = password
 				fileDB = file
+				shareSession(fileDB, masterPassword)
+				startAgent()
 				err2 := controller.WriteConfig(fileDB)
 				if err2 != nil {
 					log.Println(err2)
@@ -1343,41 +1831,189 @@ func newDbFile() {
 	}
 }
 
-func addSecret() {
-	secret := getSecret(models.Secret{})
-	if secret.Username == "" && secret.Password == nil {
+func addSecret(kind string) {
+	secret := editSecret(models.Secret{Type: kind})
+	if isEmptySecret(secret) {
 		return
 	}
+	d, g := currentLocation()
+	if d == "" {
+		return
+	}
+	sct, err := controller.CreateSecret(fileDB, masterPassword, d, g, secret)
+	if err != nil {
+		log.Println(err)
+		showError("Failed to add secret!")
+		return
+	} else {
+		setTableItems(sct)
+		save.SetEnabled(true)
+		if sct.Type == models.SecretTypeSSH {
+			refreshAgent()
+		}
+	}
+}
+
+func editRow(row int) {
+	id := table.Item(row, 0).Text()
+	integer, err := strconv.Atoi(id)
+	if err != nil {
+		log.Println(err)
+		showError("Failed to update secret!")
+		return
+	}
+	d, g := secretLocation(row)
+	if d == "" {
+		return
+	}
+	s, err := controller.GetSecret(fileDB, masterPassword, d, g, integer)
+	if err != nil {
+		log.Println(err)
+		showError("Failed to update secret!")
+		return
+	}
+	secret := editSecret(s)
+	if isEmptySecret(secret) {
+		return
+	}
+	sct, err := controller.UpdateSecret(fileDB, masterPassword, d, g, integer, secret)
+	if err != nil {
+		log.Println(err)
+		showError("Failed to update secret!")
+		return
+	} else {
+		setTableItems2(row, sct)
+		if sct.Type == models.SecretTypeSSH {
+			refreshAgent()
+		}
+	}
+}
+
+func editSecret(secret models.Secret) models.Secret {
+	if secret.Type == models.SecretTypeNote {
+		return getNote(secret)
+	}
+	if secret.Type == models.SecretTypeSSH {
+		return getSSHKey(secret)
+	}
+	if _, ok := models.SecretFields[secret.Type]; ok {
+		return getEntry(secret)
+	}
+	return getSecret(secret)
+}
+
+func isEmptySecret(secret models.Secret) bool {
+	return secret.Username == "" && secret.Password == nil && secret.Note == nil && secret.Fields == nil
+}
+
+func currentLocation() (string, string) {
 	if tree.CurrentItem().Parent().Text(0) == "" {
 		if tree.CurrentItem().Child(0).Text(0) != "" {
-			sct, err := controller.CreateSecret(fileDB, masterPassword, tree.CurrentItem().Text(0), tree.CurrentItem().Child(0).Text(0), secret)
-			if err != nil {
-				log.Println(err)
-				showError("Failed to add secret!")
-				return
-			} else {
-				setTableItems(sct)
-				save.SetEnabled(true)
-			}
+			return tree.CurrentItem().Text(0), tree.CurrentItem().Child(0).Text(0)
 		}
-	} else {
-		sct, err := controller.CreateSecret(fileDB, masterPassword, tree.CurrentItem().Parent().Text(0), tree.CurrentItem().Text(0), secret)
-		if err != nil {
-			log.Println(err)
-			showError("Failed to add secret!")
-			return
-		} else {
-			setTableItems(sct)
-			save.SetEnabled(true)
+		return "", ""
+	}
+	return tree.CurrentItem().Parent().Text(0), tree.CurrentItem().Text(0)
+}
+
+func secretLocation(row int) (string, string) {
+	if table.Item(row, 8).Text() != "" {
+		return table.Item(row, 8).Text(), table.Item(row, 9).Text()
+	}
+	return currentLocation()
+}
+
+func searchSecrets(query string) {
+	if fileDB == "" {
+		return
+	}
+	if query == "" {
+		tree.ItemClicked(tree.CurrentItem(), 0)
+		return
+	}
+	entries, err := controller.SearchSecrets(fileDB, masterPassword, query)
+	if err != nil {
+		log.Println(err)
+		showError("Failed to search!")
+		return
+	}
+	table.ClearContents()
+	table.SetRowCount(0)
+	for _, entry := range entries {
+		setTableItems(entry.Secret)
+		setTableLocation(table.RowCount()-1, entry.Database, entry.Group)
+	}
+}
+
+func setTableLocation(row int, d string, g string) {
+	table.SetItem(row, 8, widgets.NewQTableWidgetItem2(d, 0))
+	table.SetItem(row, 9, widgets.NewQTableWidgetItem2(g, 0))
+}
+
+func secretIcon(secret models.Secret) *gui.QIcon {
+	for _, t := range secretTypes {
+		if t.Type == secret.Type {
+			return gui.NewQIcon5(t.Icon)
+		}
+	}
+	return gui.NewQIcon5("icons/key.svg")
+}
+
+func secretLabel(kind string) string {
+	for _, t := range secretTypes {
+		if t.Type == kind {
+			return strings.ToLower(t.Label)
 		}
 	}
+	return "secret"
+}
+
+func copySecretField(row int, name string) {
+	id := table.Item(row, 0).Text()
+	integer, err := strconv.Atoi(id)
+	if err != nil {
+		log.Println(err)
+		showError("Failed to copy field!")
+		return
+	}
+	d, g := secretLocation(row)
+	if d == "" {
+		return
+	}
+	s, err := controller.GetSecret(fileDB, masterPassword, d, g, integer)
+	if err != nil {
+		log.Println(err)
+		showError("Failed to copy field!")
+		return
+	}
+	value := controller.DecodeFields(s)[name]
+	if value != "" {
+		clipboard := gui.QGuiApplication_Clipboard()
+		clipboard.SetText(value, gui.QClipboard__Clipboard)
+	}
+}
+
+func checkExpiry() {
+	entries, err := controller.ExpiringSecrets(fileDB, masterPassword, 30*24*time.Hour)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if len(entries) == 0 {
+		return
+	}
+	message := "The following items have expired or expire within 30 days:\n"
+	for _, entry := range entries {
+		message += fmt.Sprintf("\n%s / %s / %s: %s", entry.Database, entry.Group, entry.Secret.Title, controller.ExpiryWarning(entry.Secret, 30*24*time.Hour))
+	}
+	showInfo(message)
 }
 
 func setTableItems(secret models.Secret) {
 	row := table.RowCount()
 	table.InsertRow(row)
 	title := widgets.NewQTableWidgetItem2(secret.Title, 0)
-	title.SetIcon(gui.NewQIcon5("icons/key.svg"))
+	title.SetIcon(secretIcon(secret))
 	table.SetItem(row, 0, widgets.NewQTableWidgetItem2(fmt.Sprint(secret.ID), 0))
 	table.SetItem(row, 1, title)
 	table.SetItem(row, 2, widgets.NewQTableWidgetItem2(secret.Username, 0))
@@ -1386,11 +2022,12 @@ func setTableItems(secret models.Secret) {
 	table.SetItem(row, 5, widgets.NewQTableWidgetItem2(secret.Description, 0))
 	table.SetItem(row, 6, widgets.NewQTableWidgetItem2(secret.Created_at, 0))
 	table.SetItem(row, 7, widgets.NewQTableWidgetItem2(secret.Updated_at, 0))
+	table.SetItem(row, 10, widgets.NewQTableWidgetItem2(secret.Type, 0))
 }
 
 func setTableItems2(row int, secret models.Secret) {
 	title := widgets.NewQTableWidgetItem2(secret.Title, 0)
-	title.SetIcon(gui.NewQIcon5("icons/key.svg"))
+	title.SetIcon(secretIcon(secret))
 	table.SetItem(row, 0, widgets.NewQTableWidgetItem2(fmt.Sprint(secret.ID), 0))
 	table.SetItem(row, 1, title)
 	table.SetItem(row, 2, widgets.NewQTableWidgetItem2(secret.Username, 0))
@@ -1399,6 +2036,7 @@ func setTableItems2(row int, secret models.Secret) {
 	table.SetItem(row, 5, widgets.NewQTableWidgetItem2(secret.Description, 0))
 	table.SetItem(row, 6, widgets.NewQTableWidgetItem2(secret.Created_at, 0))
 	table.SetItem(row, 7, widgets.NewQTableWidgetItem2(secret.Updated_at, 0))
+	table.SetItem(row, 10, widgets.NewQTableWidgetItem2(secret.Type, 0))
 	save.SetEnabled(true)
 }
 
@@ -1418,21 +2056,21 @@ func Inits() {
 		}
 		return
 	}
-	databases := []models.Database{}
+	password, databases := sessionUnlock(file)
 	var err error
-	password := ""
-	for i := 0; i < 3; i++ {
+	for i := 0; i < 3 && password == ""; i++ {
 		password = getPassword(file)
 		if password != "" {
 			databases, err = controller.GetAllDatabases(file, password)
 			if err != nil {
 				log.Println(err)
 				showError("Wrong password!")
+				password = ""
 				if i == 2 {
 					return
 				}
 			} else {
-				break
+				shareSession(file, password)
 			}
 		} else {
 			return
@@ -1457,7 +2095,11 @@ func Inits() {
 	}
 	group.SetEnabled(true)
 	add.SetEnabled(true)
+	note.SetEnabled(true)
+	search.SetEnabled(true)
 	sub.SetEnabled(true)
 	masterPassword = password
 	fileDB = file
+	checkExpiry()
+	startAgent()
 }
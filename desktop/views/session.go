@@ -0,0 +1,45 @@
+package views
+
+import (
+	"log"
+	"path/filepath"
+
+	"desktop/agent"
+	"desktop/controller"
+	"desktop/models"
+)
+
+func sessionUnlock(file string) (string, []models.Database) {
+	key, err := filepath.Abs(file)
+	if err != nil {
+		log.Println(err)
+		return "", nil
+	}
+	password, err2 := agent.Key(agent.DefaultSocket(), key)
+	if err2 != nil {
+		return "", nil
+	}
+	databases, err3 := controller.GetAllDatabases(file, password)
+	if err3 != nil {
+		log.Println(err3)
+		return "", nil
+	}
+	log.Println("Unlocked " + file + " from agent session")
+	return password, databases
+}
+
+func shareSession(file string, password string) {
+	socket := agent.DefaultSocket()
+	if !agent.Running(socket) {
+		return
+	}
+	key, err := filepath.Abs(file)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	err2 := agent.Unlock(socket, key, password, 0)
+	if err2 != nil {
+		log.Println(err2)
+	}
+}
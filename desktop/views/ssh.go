@@ -0,0 +1,315 @@
+package views
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"desktop/controller"
+	"desktop/models"
+	"desktop/security"
+	"desktop/sshagent"
+
+	"github.com/therecipe/qt/core"
+	"github.com/therecipe/qt/gui"
+	"github.com/therecipe/qt/widgets"
+)
+
+type confirmRequest struct {
+	name  string
+	reply chan bool
+}
+
+var sshAgent *sshagent.Agent = nil
+var confirmRequests = make(chan confirmRequest)
+
+func startAgent() {
+	if sshAgent == nil {
+		sshAgent = sshagent.New(confirmSign)
+		socket := models.SSHAuthSock
+		if socket == "" {
+			socket = sshagent.DefaultSocket()
+		}
+		err := sshAgent.Listen(socket)
+		if err != nil {
+			log.Println(err)
+			sshAgent = nil
+			return
+		}
+		log.Println("SSH agent listening on " + socket)
+		timer := core.NewQTimer(nil)
+		timer.ConnectTimeout(func() {
+			select {
+			case request := <-confirmRequests:
+				request.reply <- areYouSure(fmt.Sprintf("Allow the use of SSH key %s?", request.name))
+			default:
+			}
+		})
+		timer.Start(200)
+	}
+	refreshAgent()
+}
+
+func refreshAgent() {
+	if sshAgent == nil || fileDB == "" {
+		return
+	}
+	keys, err := controller.SSHKeys(fileDB, masterPassword)
+	if err != nil {
+		log.Println(err)
+		sshAgent.Clear()
+		return
+	}
+	err2 := sshAgent.SetKeys(keys)
+	if err2 != nil {
+		log.Println(err2)
+		sshAgent.Clear()
+	}
+}
+
+func StopAgent() {
+	if sshAgent == nil {
+		return
+	}
+	err := sshAgent.Close()
+	if err != nil {
+		log.Println(err)
+	}
+	sshAgent = nil
+}
+
+func confirmSign(name string) bool {
+	request := confirmRequest{name: name, reply: make(chan bool, 1)}
+	select {
+	case confirmRequests <- request:
+	case <-time.After(30 * time.Second):
+		return false
+	}
+	select {
+	case allowed := <-request.reply:
+		return allowed
+	case <-time.After(60 * time.Second):
+		return false
+	}
+}
+
+func getSSHKey(secret models.Secret) models.Secret {
+	values := controller.DecodeFields(secret)
+
+	dialog := widgets.NewQDialog(nil, 0)
+	dialog.SetWindowTitle("Create SSH key")
+	dialog.SetMinimumSize2(600, 500)
+
+	layout := widgets.NewQVBoxLayout2(dialog)
+
+	formLayout := widgets.NewQFormLayout(nil)
+
+	titleField := widgets.NewQLineEdit(nil)
+	privateField := widgets.NewQTextEdit(nil)
+	privateField.SetAcceptRichText(false)
+	privateField.SetPlainText(values["private_key"])
+	passphraseField := widgets.NewQLineEdit(nil)
+	passphraseField.SetEchoMode(2)
+	passphraseField.SetText(values["passphrase"])
+	publicField := widgets.NewQLineEdit(nil)
+	publicField.SetReadOnly(true)
+	fingerprintField := widgets.NewQLineEdit(nil)
+	fingerprintField.SetReadOnly(true)
+	descriptionField := widgets.NewQTextEdit(nil)
+	createdField := widgets.NewQLineEdit(nil)
+	updatedField := widgets.NewQLineEdit(nil)
+
+	createdField.SetReadOnly(true)
+	updatedField.SetReadOnly(true)
+
+	agentC := widgets.NewQCheckBox(nil)
+	agentC.SetText("Expose in SSH agent while the vault is open")
+	agentC.SetChecked(values["agent"] == "true")
+	confirmC := widgets.NewQCheckBox(nil)
+	confirmC.SetText("Confirm each use")
+	confirmC.SetChecked(values["confirm"] == "true")
+	confirmC.SetEnabled(agentC.IsChecked())
+	agentC.ConnectStateChanged(func(state int) {
+		confirmC.SetEnabled(state == 2)
+	})
+
+	errorLabel := widgets.NewQLabel(nil, 0)
+	errorLabel.SetStyleSheet("color: red")
+	errorLabel.SetVisible(false)
+
+	valid := false
+	updateKey := func() {
+		private := strings.TrimSpace(privateField.ToPlainText())
+		if private == "" {
+			valid = false
+			publicField.SetText("")
+			fingerprintField.SetText("")
+			errorLabel.SetVisible(false)
+			return
+		}
+		public, fingerprint, err := security.SSHPublicKey(private, passphraseField.Text(), titleField.Text())
+		if err != nil {
+			valid = false
+			publicField.SetText("")
+			fingerprintField.SetText("")
+			errorLabel.SetText(fmt.Sprintf("Invalid private key: %s", err.Error()))
+			errorLabel.SetVisible(true)
+			return
+		}
+		valid = true
+		publicField.SetText(public)
+		fingerprintField.SetText(fingerprint)
+		errorLabel.SetVisible(false)
+	}
+	privateField.ConnectTextChanged(func() {
+		updateKey()
+	})
+	passphraseField.ConnectTextChanged(func(_ string) {
+		updateKey()
+	})
+	titleField.ConnectTextChanged(func(_ string) {
+		updateKey()
+	})
+
+	kindField := widgets.NewQComboBox(nil)
+	kindField.AddItems([]string{"ed25519", "rsa 3072", "rsa 4096"})
+	generateButton := widgets.NewQPushButton2("Generate", nil)
+	generateButton.ConnectClicked(func(bool) {
+		if strings.TrimSpace(privateField.ToPlainText()) != "" && !areYouSure("Are you sure you want to replace the private key?") {
+			return
+		}
+		kind := security.SSHKeyEd25519
+		bits := 0
+		switch kindField.CurrentText() {
+		case "rsa 3072":
+			kind, bits = security.SSHKeyRSA, 3072
+		case "rsa 4096":
+			kind, bits = security.SSHKeyRSA, 4096
+		}
+		private, err := security.GenerateSSHKey(kind, bits, titleField.Text(), passphraseField.Text())
+		if err != nil {
+			log.Println(err)
+			showError("Failed to generate key!")
+			return
+		}
+		privateField.SetPlainText(private)
+	})
+	importButton := widgets.NewQPushButton2("Import", nil)
+	importButton.ConnectClicked(func(bool) {
+		fileDialog := widgets.NewQFileDialog(nil, 0)
+		file := fileDialog.GetOpenFileName(nil, "Import private key", "", "All files (*)", "", 0)
+		if file == "" {
+			return
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			log.Println(err)
+			showError("Failed to read key!")
+			return
+		}
+		privateField.SetPlainText(string(data))
+	})
+	keyButtons := widgets.NewQHBoxLayout2(nil)
+	keyButtons.AddWidget(kindField, 0, 0)
+	keyButtons.AddWidget(generateButton, 0, 0)
+	keyButtons.AddWidget(importButton, 0, 0)
+
+	sh := gui.NewQIcon5("icons/show.svg")
+	showPassphrase := widgets.NewQPushButton3(sh, "", nil)
+	showPassphrase.SetStyleSheet("border-width: 0px;")
+	showPassphrase.ConnectClicked(func(bool) {
+		if passphraseField.EchoMode() == 2 {
+			passphraseField.SetEchoMode(0)
+			showPassphrase.SetIcon(gui.NewQIcon5("icons/dontshow.svg"))
+		} else {
+			passphraseField.SetEchoMode(2)
+			showPassphrase.SetIcon(gui.NewQIcon5("icons/show.svg"))
+		}
+	})
+	passphraseRow := widgets.NewQHBoxLayout2(nil)
+	passphraseRow.AddWidget(passphraseField, 0, 0)
+	passphraseRow.AddWidget(showPassphrase, 0, 0)
+
+	copyPublic := widgets.NewQPushButton3(gui.NewQIcon5("icons/copy.svg"), "", nil)
+	copyPublic.SetStyleSheet("border-width: 0px;")
+	copyPublic.SetToolTip("Copy public key")
+	copyPublic.ConnectClicked(func(bool) {
+		if publicField.Text() != "" {
+			clipboard := gui.QGuiApplication_Clipboard()
+			clipboard.SetText(publicField.Text(), gui.QClipboard__Clipboard)
+		}
+	})
+	publicRow := widgets.NewQHBoxLayout2(nil)
+	publicRow.AddWidget(publicField, 0, 0)
+	publicRow.AddWidget(copyPublic, 0, 0)
+
+	formLayout.AddRow3("Title:", titleField)
+	formLayout.AddRow4("", keyButtons)
+	formLayout.AddRow3("Private key:", privateField)
+	formLayout.AddRow4("Passphrase:", passphraseRow)
+	formLayout.AddRow3("", errorLabel)
+	formLayout.AddRow4("Public key:", publicRow)
+	formLayout.AddRow3("Fingerprint:", fingerprintField)
+	formLayout.AddRow3("", agentC)
+	formLayout.AddRow3("", confirmC)
+	formLayout.AddRow3("Notes:", descriptionField)
+
+	if secret.ID != 0 {
+		dialog.SetWindowTitle("Edit SSH key")
+		titleField.SetText(secret.Title)
+		descriptionField.SetText(secret.Description)
+		createdField.SetText(secret.Created_at)
+		updatedField.SetText(secret.Updated_at)
+		formLayout.AddRow3("Created at:", createdField)
+		formLayout.AddRow3("Updated at:", updatedField)
+		formLayout.AddRow4("Attachments:", attachmentsLayout(secret.ID))
+	}
+
+	updateKey()
+
+	layout.AddLayout(formLayout, 0)
+
+	buttons := widgets.NewQDialogButtonBox(nil)
+	buttons.SetOrientation(core.Qt__Horizontal)
+	buttons.SetStandardButtons(widgets.QDialogButtonBox__Ok | widgets.QDialogButtonBox__Cancel)
+	buttons.ConnectAccepted(func() {
+		if titleField.Text() == "" {
+			showError("Title is missing!")
+			return
+		}
+		if !valid {
+			showError("Private key is missing or invalid!")
+			return
+		}
+		dialog.Accept()
+	})
+	buttons.ConnectRejected(func() {
+		dialog.Reject()
+	})
+	layout.AddWidget(buttons, 0, core.Qt__AlignRight)
+
+	dialog.SetModal(true)
+	dialog.Show()
+
+	if dialog.Exec() == int(widgets.QDialog__Accepted) {
+		fields := map[string]string{
+			"fingerprint": fingerprintField.Text(),
+			"public_key":  publicField.Text(),
+			"private_key": strings.TrimSpace(privateField.ToPlainText()) + "\n",
+			"passphrase":  passphraseField.Text(),
+			"agent":       fmt.Sprint(agentC.IsChecked()),
+			"confirm":     fmt.Sprint(agentC.IsChecked() && confirmC.IsChecked()),
+		}
+		return models.Secret{
+			Type:        models.SecretTypeSSH,
+			Title:       titleField.Text(),
+			Username:    fingerprintField.Text(),
+			Password:    []byte{},
+			Description: descriptionField.ToPlainText(),
+			Fields:      controller.EncodeFields(fields),
+		}
+	}
+	return models.Secret{}
+}